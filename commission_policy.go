@@ -0,0 +1,374 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pkg/errors"
+)
+
+// DS commission methods.
+const (
+	// CommissionMethodOneDay takes one day's contribution per cycle.
+	CommissionMethodOneDay = "one_day"
+	// CommissionMethodPercentage takes a share of the cycle total when the
+	// cycle is paid out.
+	CommissionMethodPercentage = "percentage"
+	// CommissionMethodFlat takes a fixed fee per cycle.
+	CommissionMethodFlat = "flat"
+)
+
+// defaultCommissionPolicyID identifies the policy used when no stored policy
+// applies. It takes the first contribution of every cycle.
+const defaultCommissionPolicyID = "default"
+
+var defaultCommissionPolicy = CommissionPolicy{
+	ID:      defaultCommissionPolicyID,
+	Method:  CommissionMethodOneDay,
+	MinDays: 1,
+}
+
+// CommissionPolicy defines the DS fee of the cycles started from its
+// effective date. A policy applies to a branch and an account type, either
+// of which may be left empty to apply to all. Policies are never changed:
+// a new version with a later effective date replaces one, so that every
+// DSCommission can be explained by the policy it records.
+type CommissionPolicy struct {
+	ID          string `json:"id" truss:"api-read"`
+	BranchID    string `json:"branch_id"`
+	AccountType string `json:"account_type"`
+	Method      string `json:"method" validate:"required,oneof=one_day percentage flat"`
+	// BasisPoints is the share of the cycle total taken by the percentage
+	// method, 250 being 2.5%.
+	BasisPoints int64 `json:"basis_points"`
+	FlatFee     Money `json:"flat_fee"`
+	// MinDays is the number of days a cycle must be paid for before a fee applies.
+	MinDays       int64  `json:"min_days"`
+	EffectiveFrom int64  `json:"effective_from"`
+	Version       int64  `json:"version" truss:"api-read"`
+	CreatedBy     string `json:"created_by"`
+	CreatedAt     int64  `json:"created_at" truss:"api-read"`
+}
+
+func (p CommissionPolicy) validate() error {
+	switch p.Method {
+	case CommissionMethodOneDay:
+	case CommissionMethodPercentage:
+		if p.BasisPoints <= 0 || p.BasisPoints > 10000 {
+			return errors.New("basis points must be between 1 and 10000")
+		}
+	case CommissionMethodFlat:
+		if p.FlatFee <= 0 {
+			return errors.New("flat fee must be greater than zero")
+		}
+	default:
+		return errors.Errorf("unknown commission method %q", p.Method)
+	}
+	if p.MinDays < 0 || p.MinDays > dsCycleDays {
+		return errors.Errorf("min days must be between 0 and %d", dsCycleDays)
+	}
+	return nil
+}
+
+// sameScope reports whether p and o apply to the same branch and account type.
+func (p CommissionPolicy) sameScope(o CommissionPolicy) bool {
+	return p.BranchID == o.BranchID && p.AccountType == o.AccountType
+}
+
+// fee returns the fee due on cycle under the policy, zero when none is due
+// yet. amount is the contribution just added to the cycle and closing is set
+// when the cycle is being paid out. The fee never exceeds the cycle total.
+func (p CommissionPolicy) fee(cycle DSCycle, amount Money, closing bool) Money {
+	if cycle.CommissionID != "" || cycle.DaysPaid < p.MinDays || cycle.DaysPaid == 0 {
+		return 0
+	}
+	var fee Money
+	switch p.Method {
+	case CommissionMethodOneDay:
+		fee = amount
+		if fee == 0 {
+			fee = cycle.DailyRate
+		}
+	case CommissionMethodFlat:
+		fee = p.FlatFee
+	case CommissionMethodPercentage:
+		if !closing {
+			return 0
+		}
+		fee = cycle.AmountPaid * Money(p.BasisPoints) / 10000
+	}
+	if fee > cycle.AmountPaid {
+		fee = cycle.AmountPaid
+	}
+	return fee
+}
+
+// selectCommissionPolicy returns the policy for a cycle of the branch and
+// account type starting at the given time. A policy naming the branch wins
+// over one naming the account type which wins over one naming neither; the
+// latest effective version of the most specific scope is used.
+func selectCommissionPolicy(policies []CommissionPolicy, branchID, accountType string, at int64) CommissionPolicy {
+	selected, best := defaultCommissionPolicy, -1
+	for _, p := range policies {
+		if p.EffectiveFrom > at {
+			continue
+		}
+		if (p.BranchID != "" && p.BranchID != branchID) || (p.AccountType != "" && p.AccountType != accountType) {
+			continue
+		}
+		var score int
+		if p.BranchID != "" {
+			score += 2
+		}
+		if p.AccountType != "" {
+			score++
+		}
+		if score > best || (score == best && (p.EffectiveFrom > selected.EffectiveFrom ||
+			p.EffectiveFrom == selected.EffectiveFrom && p.Version > selected.Version)) {
+			selected, best = p, score
+		}
+	}
+	return selected
+}
+
+// cyclePolicy returns the policy recorded on a cycle. Cycles started before
+// policies were recorded use the default policy.
+func cyclePolicy(ctx context.Context, tx Tx, cycle *DSCycle) (CommissionPolicy, error) {
+	if cycle.PolicyID == "" || cycle.PolicyID == defaultCommissionPolicyID {
+		return defaultCommissionPolicy, nil
+	}
+	p, err := tx.GetCommissionPolicy(ctx, cycle.PolicyID)
+	if err != nil {
+		return CommissionPolicy{}, err
+	}
+	return *p, nil
+}
+
+// CreateCommissionPolicyHTTP is an HTTP Cloud Function that adds a version of
+// the commission policy of a branch and account type.
+func CreateCommissionPolicyHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).CreateCommissionPolicyHTTP)
+}
+
+func (h *Handler) CreateCommissionPolicyHTTP(w http.ResponseWriter, r *http.Request) {
+	var req CommissionPolicy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if err := req.validate(); err != nil {
+		sendError(w, err.Error())
+		return
+	}
+
+	policy, err := createCommissionPolicy(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendError(w, err.Error())
+		return
+	}
+	sendResponse(w, policy)
+}
+
+// commissionPolicyID returns the document ID of a version of the policy of a
+// scope, so that two versions with the same number cannot both be created.
+func commissionPolicyID(p CommissionPolicy) string {
+	scope := func(s string) string {
+		if s == "" {
+			return "all"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s_%s_v%d", scope(p.BranchID), scope(p.AccountType), p.Version)
+}
+
+// createCommissionPolicy saves req as the next version of the policy of its
+// scope. The version is allocated in the transaction that creates it: a
+// concurrent creation of the same version makes the transaction run again and
+// take the following one.
+func createCommissionPolicy(ctx context.Context, req CommissionPolicy, currentDate time.Time, store Store) (*CommissionPolicy, error) {
+	if req.EffectiveFrom == 0 {
+		req.EffectiveFrom = currentDate.Unix()
+	}
+	if req.EffectiveFrom < now.New(currentDate).BeginningOfDay().Unix() {
+		return nil, errors.New("a commission policy cannot take effect in the past")
+	}
+
+	// Versions created before IDs followed the version have random IDs, so
+	// the listing gives the version to start from.
+	policies, err := store.ListCommissionPolicies(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read commission policies")
+	}
+	var latest int64
+	for _, p := range policies {
+		if p.sameScope(req) && p.Version > latest {
+			latest = p.Version
+		}
+	}
+	req.CreatedAt = currentDate.Unix()
+
+	policy := req
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		policy = req
+		for policy.Version = latest + 1; ; policy.Version++ {
+			_, err := tx.GetCommissionPolicy(ctx, commissionPolicyID(policy))
+			if err == ErrNotFound {
+				break
+			}
+			if err != nil {
+				log.Println(err)
+				return errors.New("cannot read commission policies")
+			}
+		}
+		policy.ID = commissionPolicyID(policy)
+		tx.CreateCommissionPolicy(policy)
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot save commission policy")
+	}
+	return &policy, nil
+}
+
+// ListCommissionPoliciesHTTP is an HTTP Cloud Function that lists every
+// version of the commission policies.
+func ListCommissionPoliciesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListCommissionPoliciesHTTP)
+}
+
+func (h *Handler) ListCommissionPoliciesHTTP(w http.ResponseWriter, r *http.Request) {
+	policies, err := h.store.ListCommissionPolicies(r.Context())
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read commission policies")
+		return
+	}
+	sendResponse(w, append([]CommissionPolicy{defaultCommissionPolicy}, policies...))
+}
+
+// CommissionPreviewRequest is a proposed deposit to a DS account.
+type CommissionPreviewRequest struct {
+	AccountNumber string `json:"account_number" validate:"required"`
+	Amount        Money  `json:"amount" validate:"required,gt=0"`
+}
+
+// CommissionPreviewDay is the fee taken on a day covered by the deposit.
+type CommissionPreviewDay struct {
+	EffectiveDate int64  `json:"effective_date"`
+	NewCycle      bool   `json:"new_cycle"`
+	PolicyID      string `json:"policy_id"`
+	Fee           Money  `json:"fee"`
+}
+
+// CommissionPreview is the outcome of a proposed deposit.
+type CommissionPreview struct {
+	Days []CommissionPreviewDay `json:"days"`
+	Fee  Money                  `json:"fee"`
+	// PayoutFee is the fee that would be taken if the cycle was paid out
+	// after the deposit.
+	PayoutFee Money `json:"payout_fee"`
+}
+
+// PreviewCommissionHTTP is an HTTP Cloud Function that previews the DS fee
+// of a proposed deposit without posting it.
+func PreviewCommissionHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).PreviewCommissionHTTP)
+}
+
+func (h *Handler) PreviewCommissionHTTP(w http.ResponseWriter, r *http.Request) {
+	var req CommissionPreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	preview, err := previewCommission(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendError(w, err.Error())
+		return
+	}
+	sendResponse(w, preview)
+}
+
+// previewCommission runs the fee rules of create over the days of a
+// proposed deposit.
+func previewCommission(ctx context.Context, req CommissionPreviewRequest, currentDate time.Time, store Store) (*CommissionPreview, error) {
+	account, err := getAccountByNumber(ctx, req.AccountNumber, store)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("Invalid account number")
+	}
+	if account.Type != AccountTypeDS {
+		return nil, errors.New("only DS accounts pay a commission")
+	}
+	if account.Target <= 0 {
+		return nil, errors.New("The daily contribution of this account is not set")
+	}
+	if req.Amount <= 0 || req.Amount%account.Target != 0 {
+		return nil, errors.Errorf("Amount must be a multiple of %s", account.Target)
+	}
+
+	policies, err := store.ListCommissionPolicies(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read commission policies")
+	}
+
+	var cycle *DSCycle
+	policy := defaultCommissionPolicy
+	if account.CurrentCycleID != "" {
+		if cycle, err = store.GetCycle(ctx, account.CurrentCycleID); err != nil {
+			log.Println(err)
+			return nil, errors.New("cannot read the DS cycle of the account")
+		}
+		if cycle.PolicyID != "" && cycle.PolicyID != defaultCommissionPolicyID {
+			p, err := store.GetCommissionPolicy(ctx, cycle.PolicyID)
+			if err != nil {
+				log.Println(err)
+				return nil, errors.New("cannot read the commission policy of the cycle")
+			}
+			policy = *p
+		}
+	}
+
+	today := now.New(currentDate).BeginningOfDay()
+	preview := &CommissionPreview{Days: []CommissionPreviewDay{}}
+	for paid := Money(0); paid < req.Amount; paid += account.Target {
+		effectiveDate := nextContributionDate(account, cycle, today)
+		day := CommissionPreviewDay{EffectiveDate: effectiveDate.Unix()}
+		if due, _ := startingNewCircle(account.LastCommissionDate, effectiveDate); due {
+			policy = selectCommissionPolicy(policies, account.BranchID, account.Type, effectiveDate.Unix())
+			cycle = &DSCycle{StartDate: effectiveDate.Unix(), DailyRate: account.Target, PolicyID: policy.ID}
+			account.LastCommissionDate = cycle.StartDate
+			day.NewCycle = true
+		}
+		if cycle != nil {
+			cycle.contribute(account.Target, effectiveDate.Unix())
+			day.PolicyID = policy.ID
+			day.Fee = policy.fee(*cycle, account.Target, false)
+			if day.Fee > 0 {
+				cycle.CommissionID = "preview"
+				cycle.FeeTaken = day.Fee
+				preview.Fee += day.Fee
+			}
+		} else {
+			account.RecentTransactions = append([]Transaction{{
+				Type:          TransactionType_Deposit,
+				EffectiveDate: effectiveDate.Unix(),
+			}}, account.RecentTransactions...)
+		}
+		preview.Days = append(preview.Days, day)
+	}
+	if cycle != nil {
+		preview.PayoutFee = policy.fee(*cycle, 0, true)
+	}
+	return preview, nil
+}
@@ -0,0 +1,144 @@
+package surebankltd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCommissionPolicyFee(t *testing.T) {
+	oneDay := CommissionPolicy{Method: CommissionMethodOneDay, MinDays: 1}
+	flat := CommissionPolicy{Method: CommissionMethodFlat, FlatFee: 300 * Naira, MinDays: 5}
+	percentage := CommissionPolicy{Method: CommissionMethodPercentage, BasisPoints: 250}
+	cycle := func(days int64, paid Money) DSCycle {
+		return DSCycle{DaysPaid: days, AmountPaid: paid, DailyRate: 500 * Naira}
+	}
+
+	tests := []struct {
+		name    string
+		policy  CommissionPolicy
+		cycle   DSCycle
+		amount  Money
+		closing bool
+		want    Money
+	}{
+		{"one day takes the contribution", oneDay, cycle(1, 700*Naira), 700 * Naira, false, 700 * Naira},
+		{"one day on payout takes the daily rate", oneDay, cycle(3, 1500*Naira), 0, true, 500 * Naira},
+		{"one day never exceeds the cycle", oneDay, cycle(1, 200*Naira), 0, true, 200 * Naira},
+		{"nothing before a contribution", oneDay, cycle(0, 0), 0, true, 0},
+		{"flat waits for the minimum days", flat, cycle(4, 2000*Naira), 500 * Naira, false, 0},
+		{"flat once the minimum is paid", flat, cycle(5, 2500*Naira), 500 * Naira, false, 300 * Naira},
+		{"flat capped by the cycle total", flat, cycle(5, 250*Naira), 50 * Naira, false, 250 * Naira},
+		{"percentage waits for payout", percentage, cycle(31, 15500*Naira), 500 * Naira, false, 0},
+		{"percentage on payout", percentage, cycle(31, 15500*Naira), 0, true, 38750},
+		{"percentage rounds down to the kobo", percentage, cycle(1, 1001), 0, true, 25},
+		{"no second fee", oneDay, DSCycle{DaysPaid: 2, AmountPaid: 1000 * Naira, CommissionID: "c1"}, 500 * Naira, false, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.fee(tt.cycle, tt.amount, tt.closing); got != tt.want {
+			t.Errorf("%s: fee = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommissionPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy CommissionPolicy
+		ok     bool
+	}{
+		{CommissionPolicy{Method: CommissionMethodOneDay}, true},
+		{CommissionPolicy{Method: CommissionMethodPercentage, BasisPoints: 10000}, true},
+		{CommissionPolicy{Method: CommissionMethodPercentage}, false},
+		{CommissionPolicy{Method: CommissionMethodPercentage, BasisPoints: 10001}, false},
+		{CommissionPolicy{Method: CommissionMethodFlat}, false},
+		{CommissionPolicy{Method: CommissionMethodFlat, FlatFee: Naira, MinDays: dsCycleDays + 1}, false},
+		{CommissionPolicy{Method: "weekly"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, want ok %v", tt.policy, err, tt.ok)
+		}
+	}
+}
+
+func TestSelectCommissionPolicy(t *testing.T) {
+	policies := []CommissionPolicy{
+		{ID: "all-v1", Version: 1, EffectiveFrom: 100},
+		{ID: "all-v2", Version: 2, EffectiveFrom: 200},
+		{ID: "ds", AccountType: AccountTypeDS, Version: 1, EffectiveFrom: 100},
+		{ID: "lagos", BranchID: "lagos", Version: 1, EffectiveFrom: 100},
+		{ID: "lagos-future", BranchID: "lagos", Version: 2, EffectiveFrom: 900},
+	}
+	tests := []struct {
+		branch, accountType string
+		at                  int64
+		want                string
+	}{
+		{"", AccountTypeSB, 50, defaultCommissionPolicyID},
+		{"", AccountTypeSB, 150, "all-v1"},
+		{"", AccountTypeSB, 250, "all-v2"},
+		{"", AccountTypeDS, 250, "ds"},
+		{"lagos", AccountTypeDS, 250, "lagos"},
+		{"lagos", AccountTypeDS, 1000, "lagos-future"},
+		{"abuja", AccountTypeDS, 250, "ds"},
+	}
+	for _, tt := range tests {
+		if got := selectCommissionPolicy(policies, tt.branch, tt.accountType, tt.at); got.ID != tt.want {
+			t.Errorf("selectCommissionPolicy(%q, %q, %d) = %s, want %s", tt.branch, tt.accountType, tt.at, got.ID, tt.want)
+		}
+	}
+}
+
+func TestCreateCommissionPolicyVersions(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStore()
+	currentDate := time.Date(2026, 3, 1, 9, 0, 0, 0, time.Local)
+
+	// A version saved before IDs followed the version.
+	batch := memory.Batch()
+	batch.CreateCommissionPolicy(CommissionPolicy{ID: "0f1c", AccountType: AccountTypeDS, Method: CommissionMethodOneDay, Version: 3})
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	req := CommissionPolicy{AccountType: AccountTypeDS, Method: CommissionMethodFlat, FlatFee: 200 * Naira}
+	store := &conflictStore{MemoryStore: memory, interleave: func() {
+		if _, err := createCommissionPolicy(ctx, req, currentDate, memory); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	policy, err := createCommissionPolicy(ctx, req, currentDate, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Version != 5 || policy.ID != "all_DS_v5" {
+		t.Errorf("got version %d with ID %s after a concurrent creation, want 5", policy.Version, policy.ID)
+	}
+
+	other, err := createCommissionPolicy(ctx, CommissionPolicy{BranchID: "lagos", Method: CommissionMethodOneDay}, currentDate, memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Version != 1 || other.ID != "lagos_all_v1" {
+		t.Errorf("got version %d with ID %s for a new scope, want 1", other.Version, other.ID)
+	}
+
+	policies, err := memory.ListCommissionPolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := map[int64]int{}
+	for _, p := range policies {
+		if p.AccountType == AccountTypeDS {
+			versions[p.Version]++
+		}
+	}
+	if len(versions) != 3 || versions[3] != 1 || versions[4] != 1 || versions[5] != 1 {
+		t.Errorf("got DS policy versions %v, want 3, 4 and 5 once", versions)
+	}
+
+	if _, err = createCommissionPolicy(ctx, CommissionPolicy{Method: CommissionMethodOneDay, EffectiveFrom: currentDate.AddDate(0, 0, -1).Unix()},
+		currentDate, memory); err == nil {
+		t.Error("a policy taking effect in the past was accepted")
+	}
+}
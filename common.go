@@ -64,7 +64,7 @@ type documentCount struct {
 
 type txStat struct {
 	Count int
-	Total float64
-	Bank  float64
-	Cash  float64
+	Total Money
+	Bank  Money
+	Cash  Money
 }
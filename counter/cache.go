@@ -0,0 +1,98 @@
+package counter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache keeps the aggregated values of counters for a while so that reads
+// do not add up the shards on every request.
+type Cache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// gen is incremented by every invalidation.
+	gen       uint64
+	nextSweep time.Time
+}
+
+// cacheEntry is the cached value of a key or, when it is not valid, the
+// mark of its invalidation kept until loads started before it are over.
+type cacheEntry struct {
+	value     int64
+	valid     bool
+	expiresAt time.Time
+	// gen is the generation of the last invalidation of the key.
+	gen uint64
+}
+
+// NewCache returns a Cache keeping values for ttl. A Cache with a ttl that
+// is not positive does not keep values.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, now: time.Now, entries: map[string]cacheEntry{}}
+}
+
+// Get returns the cached value of key, loading and caching it when it is
+// missing or expired. A value loaded while the key is invalidated, or
+// loaded for longer than the ttl, is returned but not cached.
+func (c *Cache) Get(ctx context.Context, key string, load func(ctx context.Context) (int64, error)) (int64, error) {
+	if c.ttl <= 0 {
+		return load(ctx)
+	}
+
+	c.mu.Lock()
+	start := c.now()
+	c.sweep(start)
+	entry, ok := c.entries[key]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && entry.valid && start.Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	value, err := load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	entry = c.entries[key]
+	if entry.gen > gen || !now.Before(start.Add(c.ttl)) {
+		return value, nil
+	}
+	c.entries[key] = cacheEntry{value: value, valid: true, expiresAt: now.Add(c.ttl), gen: entry.gen}
+	return value, nil
+}
+
+// sweep deletes the expired entries, at most once per ttl. Invalidation
+// marks expire a ttl after they are made, by when the loads started before
+// them are too old to be cached.
+func (c *Cache) sweep(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	c.nextSweep = now.Add(c.ttl)
+}
+
+// Invalidate drops the cached values of keys, e.g. after they were incremented.
+func (c *Cache) Invalidate(keys ...string) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	expiresAt := c.now().Add(c.ttl)
+	for _, key := range keys {
+		c.entries[key] = cacheEntry{expiresAt: expiresAt, gen: c.gen}
+	}
+}
@@ -0,0 +1,126 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheKeepsValuesForTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	c := NewCache(time.Minute)
+	c.now = func() time.Time { return clock }
+
+	var loads int64
+	load := func(ctx context.Context) (int64, error) {
+		loads++
+		return loads * 10, nil
+	}
+	get := func(want int64) {
+		t.Helper()
+		if got, err := c.Get(ctx, "stats/customer", load); err != nil || got != want {
+			t.Fatalf("Get = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	get(10)
+	get(10)
+	clock = clock.Add(59 * time.Second)
+	get(10)
+	clock = clock.Add(time.Second)
+	get(20)
+	c.Invalidate("stats/other", "stats/customer")
+	get(30)
+	if loads != 3 {
+		t.Errorf("loaded %d times, want 3", loads)
+	}
+}
+
+func TestCacheDoesNotKeepErrors(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(time.Minute)
+	fail := errors.New("unavailable")
+	if _, err := c.Get(ctx, "k", func(context.Context) (int64, error) { return 0, fail }); err != fail {
+		t.Fatalf("got error %v, want %v", err, fail)
+	}
+	if got, err := c.Get(ctx, "k", func(context.Context) (int64, error) { return 5, nil }); err != nil || got != 5 {
+		t.Fatalf("Get after an error = %d, %v, want 5", got, err)
+	}
+}
+
+func TestCacheWithoutTTLAlwaysLoads(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(0)
+	var loads int64
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(ctx, "k", func(context.Context) (int64, error) { loads++; return loads, nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loads != 3 {
+		t.Errorf("loaded %d times, want 3", loads)
+	}
+}
+
+func TestCacheSweepsExpiredEntries(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	c := NewCache(time.Minute)
+	c.now = func() time.Time { return clock }
+	load := func(context.Context) (int64, error) { return 1, nil }
+
+	for _, key := range []string{"stats/day/1", "stats/day/2", "stats/day/3"} {
+		if _, err := c.Get(ctx, key, load); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Invalidate("stats/day/4")
+	if len(c.entries) != 4 {
+		t.Fatalf("got %d entries, want 4", len(c.entries))
+	}
+	clock = clock.Add(time.Minute)
+	if _, err := c.Get(ctx, "stats/day/5", load); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.entries["stats/day/5"]; len(c.entries) != 1 || !ok {
+		t.Errorf("got entries %v, want only the one just loaded", c.entries)
+	}
+}
+
+func TestCacheDropsLoadsStartedBeforeInvalidate(t *testing.T) {
+	ctx := context.Background()
+	clock := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	c := NewCache(time.Minute)
+	c.now = func() time.Time { return clock }
+
+	// The counter is incremented and invalidated while the old value loads.
+	stale := func(context.Context) (int64, error) {
+		c.Invalidate("stats/customer")
+		return 10, nil
+	}
+	if got, err := c.Get(ctx, "stats/customer", stale); err != nil || got != 10 {
+		t.Fatalf("Get = %d, %v, want 10", got, err)
+	}
+	fresh := func(context.Context) (int64, error) { return 11, nil }
+	if got, err := c.Get(ctx, "stats/customer", fresh); err != nil || got != 11 {
+		t.Fatalf("Get after the invalidation = %d, %v, want 11", got, err)
+	}
+	if got, err := c.Get(ctx, "stats/customer", stale); err != nil || got != 11 {
+		t.Fatalf("Get of the cached value = %d, %v, want 11", got, err)
+	}
+
+	// A load slower than the ttl may have missed an invalidation whose mark
+	// is already swept.
+	slow := func(context.Context) (int64, error) {
+		clock = clock.Add(2 * time.Minute)
+		return 20, nil
+	}
+	if got, err := c.Get(ctx, "stats/other", slow); err != nil || got != 20 {
+		t.Fatalf("Get = %d, %v, want 20", got, err)
+	}
+	if _, ok := c.entries["stats/other"]; ok {
+		t.Error("a load slower than the ttl was cached")
+	}
+}
@@ -0,0 +1,136 @@
+// Package counter implements sharded counters on Firestore. A counter is a
+// document whose "shards" subcollection holds the parts of its value, so that
+// frequent increments are spread over several documents.
+package counter
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultShards is the number of shards of a counter when none is configured.
+const DefaultShards = 10
+
+// field is the shard field holding the value.
+const field = "Count"
+
+// Shard is a single part of a counter.
+type Shard struct {
+	Count int64
+}
+
+// Counter is a sharded counter stored under a document.
+type Counter struct {
+	ref       *firestore.DocumentRef
+	numShards int
+}
+
+// New returns the counter stored under ref. Increments are spread over
+// numShards shards, DefaultShards when numShards is not positive. The shard
+// count of a counter may change over time since reads add up every shard.
+func New(ref *firestore.DocumentRef, numShards int) *Counter {
+	if numShards <= 0 {
+		numShards = DefaultShards
+	}
+	return &Counter{ref: ref, numShards: numShards}
+}
+
+// Path returns the path of the counter document.
+func (c *Counter) Path() string {
+	return c.ref.Path
+}
+
+// Init creates the shards that do not exist yet. Existing shards are left
+// untouched so Init may be called any number of times. Init is optional as
+// Increment creates the shard it writes to.
+func (c *Counter) Init(ctx context.Context) error {
+	for num := 0; num < c.numShards; num++ {
+		_, err := c.shard(num).Create(ctx, Shard{})
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return errors.Wrapf(err, "cannot create shard %d of %s", num, c.ref.Path)
+		}
+	}
+	return nil
+}
+
+// Increment returns a randomly picked shard and the data that adds n to it
+// when set with firestore.MergeAll. The shard is created if it is missing.
+func (c *Counter) Increment(n int64) (*firestore.DocumentRef, map[string]interface{}) {
+	return c.shard(rand.Intn(c.numShards)), map[string]interface{}{
+		field: firestore.Increment(n),
+	}
+}
+
+func (c *Counter) shard(num int) *firestore.DocumentRef {
+	return c.ref.Collection("shards").Doc(strconv.Itoa(num))
+}
+
+// sum adds up the shards, converting float values with toInt.
+func (c *Counter) sum(ctx context.Context, toInt func(float64) int64) (int64, error) {
+	var total int64
+	shards := c.ref.Collection("shards").Documents(ctx)
+	defer shards.Stop()
+	for {
+		doc, err := shards.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, errors.Wrapf(err, "cannot read shards of %s", c.ref.Path)
+		}
+		v, err := shardValue(doc.Ref.Path, doc.Data()[field], toInt)
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+	return total, nil
+}
+
+// shardValue returns the value held by the shard at path, converting a float
+// value with toInt. A shard without a value counts as zero.
+func shardValue(path string, value interface{}, toInt func(float64) int64) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		if toInt == nil {
+			return 0, errors.Errorf("shard %s holds %v, want an integer", path, v)
+		}
+		return toInt(v), nil
+	case nil:
+		return 0, nil
+	default:
+		return 0, errors.Errorf("shard %s holds %T, want int64", path, v)
+	}
+}
+
+// Int is a counter of whole numbers.
+type Int struct {
+	*Counter
+}
+
+// Value returns the count across all shards.
+func (c Int) Value(ctx context.Context) (int64, error) {
+	return c.sum(ctx, nil)
+}
+
+// Amount is a counter of amounts in minor units. Shards written before
+// amounts were kept in minor units hold float values in major units, which
+// are converted with ToMinor.
+type Amount struct {
+	*Counter
+	ToMinor func(float64) int64
+}
+
+// Value returns the amount across all shards in minor units.
+func (c Amount) Value(ctx context.Context) (int64, error) {
+	return c.sum(ctx, c.ToMinor)
+}
@@ -0,0 +1,82 @@
+package counter
+
+import (
+	"context"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestShardValue(t *testing.T) {
+	toMinor := func(f float64) int64 { return int64(math.Round(f * 100)) }
+	tests := []struct {
+		value   interface{}
+		toInt   func(float64) int64
+		want    int64
+		wantErr bool
+	}{
+		{int64(42), nil, 42, false},
+		{int64(-7), toMinor, -7, false},
+		{nil, nil, 0, false},
+		{12.5, toMinor, 1250, false},
+		{12.5, nil, 0, true},
+		{"12", toMinor, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := shardValue("stats/x/shards/0", tt.value, tt.toInt)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("shardValue(%v) = %d, %v, want %d, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestCounterSumsShards runs against the Firestore emulator when
+// FIRESTORE_EMULATOR_HOST is set.
+func TestCounterSumsShards(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, "surebank-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ref := client.Doc("stats/" + strings.ReplaceAll(t.Name(), "/", "-"))
+	c := New(ref, 4)
+	if err = c.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Init(ctx); err != nil {
+		t.Fatalf("a second Init failed: %v", err)
+	}
+	var want int64
+	for i := int64(1); i <= 40; i++ {
+		shard, data := c.Increment(i)
+		if !strings.HasPrefix(shard.Path, ref.Path+"/shards/") {
+			t.Fatalf("increment went to %s", shard.Path)
+		}
+		if _, err = shard.Set(ctx, data, firestore.MergeAll); err != nil {
+			t.Fatal(err)
+		}
+		want += i
+	}
+	if got, err := (Int{c}).Value(ctx); err != nil || got != want {
+		t.Fatalf("Int value = %d, %v, want %d", got, err, want)
+	}
+
+	if _, err = ref.Collection("shards").Doc("legacy").Set(ctx, map[string]interface{}{field: 2.5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = (Int{c}).Value(ctx); err == nil {
+		t.Error("an Int counter accepted a float shard")
+	}
+	amount := Amount{Counter: c, ToMinor: func(f float64) int64 { return int64(math.Round(f * 100)) }}
+	if got, err := amount.Value(ctx); err != nil || got != want+250 {
+		t.Errorf("Amount value = %d, %v, want %d", got, err, want+250)
+	}
+}
@@ -5,14 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
-	"strconv"
+	"os"
+	"strings"
 	"time"
+	"unicode"
 
-	"cloud.google.com/go/firestore"
+	"github.com/ademuanthony/surebankltd/notify"
 	"github.com/pborman/uuid"
-	"google.golang.org/api/iterator"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -22,12 +23,11 @@ const (
 
 // CreateCustomerHTTP is an HTTP Cloud Function for creating a customer
 func CreateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).CreateCustomerHTTP)
+}
+
+// CreateCustomerHTTP creates a customer together with its first account.
+func (h *Handler) CreateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 	var req CreateCustomerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -42,8 +42,15 @@ func CreateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 		sendError(w, "phone number is required")
 		return
 	}
+	if err := req.Notifications.validate(); err != nil {
+		sendError(w, err.Error())
+		return
+	}
+	if !notify.SupportedLanguage(strings.ToLower(req.Language)) {
+		sendErrorf(w, "unsupported language %q", req.Language)
+		return
+	}
 
-	customerCollection := client.Collection("customer")
 	now := timeNow()
 	m := Customer{
 		ID:          uuid.NewRandom().String(),
@@ -58,9 +65,12 @@ func CreateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 		Branch:      req.Branch,
 		SalesRep:    req.SalesRep,
 		ShortName:   req.ShortName,
+
+		Language:      strings.ToLower(req.Language),
+		Notifications: req.Notifications,
 	}
 
-	accountNumber, err := generateAccountNumber(r.Context(), client, req.Type)
+	accountNumber, err := generateAccountNumber(r.Context(), h.store, req.Type, req.BranchID)
 	if err != nil {
 		sendError(w, fmt.Sprintf("cannot generate account number, %s", err.Error()))
 		return
@@ -77,30 +87,17 @@ func CreateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 		Target:     req.Target,
 		TargetInfo: req.TargetInfo,
 		Type:       req.Type,
+		CreatedAt:  now.Unix(),
+		UpdatedAt:  now.Unix(),
 	}
 
-	customerStat := client.Doc("stats/customer")
-	customerCounter, err := initCounter(r.Context(), 10, customerStat)
-	if err != nil {
-		log.Println(err)
-		sendError(w, "Cannot init customer count")
-	}
-	batch := customerCounter.incrementCounter(r.Context(), customerStat, 1, client.Batch())
-
-	accountStat := client.Doc("stats/account")
-	accountCounter, err := initCounter(r.Context(), 10, accountStat)
-	if err != nil {
-		log.Println(err)
-		sendError(w, "Cannot init account count")
-	}
-	batch = accountCounter.incrementCounter(r.Context(), accountStat, 1, batch)
-
-	if _, err := batch.
-		Create(customerCollection.Doc(m.ID), m).
-		Update(customerStat, []firestore.Update{{Path: "Count", Value: firestore.Increment(1)}}).
-		Create(client.Doc("account/"+accountNumber), account).
-		Update(accountStat, []firestore.Update{{Path: "Count", Value: firestore.Increment(1)}}).
-		Commit(r.Context()); err != nil {
+	batch := h.store.Batch()
+	batch.CreateCustomer(m)
+	batch.IncrementCount("stats/customer", 1)
+	batch.CreateAccount(account)
+	batch.IncrementCount("stats/account", 1)
+	batch.SetCustomerSearchEntry(customerSearchEntry(m, []string{account.Number}))
+	if err := batch.Commit(r.Context()); err != nil {
 		sendError(w, err.Error())
 		return
 	}
@@ -109,12 +106,10 @@ func CreateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func ListCustomerHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).ListCustomerHTTP)
+}
+
+func (h *Handler) ListCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 	var req FindCustomerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -122,41 +117,23 @@ func ListCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var query firestore.Query = client.Collection("customer").OrderBy("CreatedAt", firestore.Desc)
-	if req.Limit > 0 {
-		query = query.Limit(req.Limit)
-	}
-	if req.Offset > 0 {
-		query = query.Offset(req.Offset)
+	if _, _, err := customerOrder(req.Order); err != nil {
+		sendError(w, err.Error())
+		return
 	}
-	if req.SalesRepID != "" {
-		query = query.Where("SalesRepID", "==", req.SalesRepID)
+	if query := customerSearchText(req.Args); query != "" {
+		h.searchCustomerList(w, r, req, query)
+		return
 	}
 
-	var customers []Customer
-	iter := query.Documents(r.Context())
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Println(err)
-			sendError(w, "cannot read customer data")
-			return
-		}
-		var c Customer
-		if err = doc.DataTo(&c); err != nil {
-			log.Println(err)
-			sendError(w, "cannot read customer data")
-			return
-		}
-		customers = append(customers, c)
+	customers, err := h.store.ListCustomers(r.Context(), req)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read customer data")
+		return
 	}
 
-	customerStatRef := client.Doc("stats/customer")
-	totalCount, err := getCount(r.Context(), customerStatRef)
+	totalCount, err := h.store.Count(r.Context(), "stats/customer")
 	if err != nil {
 		sendError(w, "cannot get the total count of customers")
 		log.Println(err)
@@ -165,13 +142,81 @@ func ListCustomerHTTP(w http.ResponseWriter, r *http.Request) {
 	sendPagedResponse(w, customers, totalCount)
 }
 
-func FindCustomerByIdHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
+// customerSearchText returns the words of the search arguments of a customer
+// list.
+func customerSearchText(args []interface{}) string {
+	var words []string
+	for _, arg := range args {
+		if s, ok := arg.(string); ok && strings.TrimSpace(s) != "" {
+			words = append(words, strings.TrimSpace(s))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// searchCustomerList sends the customers found by query as a customer list.
+// The number of customers matching the query is not known, so the list has
+// no total count.
+func (h *Handler) searchCustomerList(w http.ResponseWriter, r *http.Request, req FindCustomerRequest, query string) {
+	results, err := searchCustomers(r.Context(), SearchCustomersRequest{
+		Query:           query,
+		SalesRepID:      req.SalesRepID,
+		IncludeArchived: req.IncludeArchived,
+		Limit:           req.Limit,
+		Offset:          req.Offset,
+	}, h.store)
 	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
+		sendErrorf(w, "cannot search customers, %s", err.Error())
 		return
 	}
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.CustomerID
+	}
+	var customers []Customer
+	if len(ids) > 0 {
+		if customers, err = h.store.GetCustomers(r.Context(), ids); err != nil {
+			log.Println(err)
+			sendError(w, "cannot read customer data")
+			return
+		}
+	}
+	if customers == nil {
+		customers = []Customer{}
+	}
+	sendResponse(w, customers)
+}
+
+// customerOrder returns the field and the direction of the first order of a
+// customer list, e.g. "name asc". Customers are listed newest first by
+// default.
+func customerOrder(order []string) (field string, desc bool, err error) {
+	if len(order) == 0 || strings.TrimSpace(order[0]) == "" {
+		return "CreatedAt", true, nil
+	}
+	parts := strings.Fields(strings.ToLower(order[0]))
+	fields := map[string]string{"created_at": "CreatedAt", "updated_at": "UpdatedAt", "name": "Name"}
+	field, ok := fields[parts[0]]
+	if !ok || len(parts) > 2 {
+		return "", false, errors.Errorf("cannot order customers by %q", order[0])
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return "", false, errors.Errorf("cannot order customers by %q", order[0])
+		}
+	}
+	return field, desc, nil
+}
+
+func FindCustomerByIdHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).FindCustomerByIdHTTP)
+}
+
+func (h *Handler) FindCustomerByIdHTTP(w http.ResponseWriter, r *http.Request) {
 	var req FindByIdRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -179,7 +224,7 @@ func FindCustomerByIdHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customer, err := getCustomerByID(r.Context(), req.ID, client)
+	customer, err := getCustomerByID(r.Context(), req.ID, h.store)
 	if err != nil {
 		sendError(w, "cannot map customer data")
 		return
@@ -188,27 +233,17 @@ func FindCustomerByIdHTTP(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, customer)
 }
 
-func getCustomerByID(ctx context.Context, id string, client *firestore.Client) (*Customer, error) {
-	docSnap, err := client.Collection("customer").Doc(id).Get(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	var customer Customer
-	if err = docSnap.DataTo(&customer); err != nil {
-		return nil, err
-	}
-	return &customer, nil
+func getCustomerByID(ctx context.Context, id string, store Store) (*Customer, error) {
+	return store.GetCustomer(ctx, id)
 }
 
 // CreateAccountHTTP is an HTTP Cloud Function for creating an account
 func CreateAccountHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).CreateAccountHTTP)
+}
+
+// CreateAccountHTTP opens a new account for an existing customer.
+func (h *Handler) CreateAccountHTTP(w http.ResponseWriter, r *http.Request) {
 	var req Account
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -224,7 +259,20 @@ func CreateAccountHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accountNumber, err := generateAccountNumber(r.Context(), client, req.Type)
+	customer, err := h.store.GetCustomer(r.Context(), req.CustomerID)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read customer, please check the ID")
+		return
+	}
+	accounts, err := h.store.ListAccounts(r.Context(), AccountQuery{CustomerID: customer.ID})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read the accounts of the customer")
+		return
+	}
+
+	accountNumber, err := generateAccountNumber(r.Context(), h.store, req.Type, req.BranchID)
 	if err != nil {
 		sendError(w, fmt.Sprintf("cannot generate account number, %s", err.Error()))
 		return
@@ -235,19 +283,11 @@ func CreateAccountHTTP(w http.ResponseWriter, r *http.Request) {
 	req.CreatedAt = now.Unix()
 	req.UpdatedAt = now.Unix()
 
-	accountStat := client.Doc("stats/account")
-	if _, err := accountStat.Get(r.Context()); err != nil {
-		_, err = accountStat.Set(r.Context(), documentCount{})
-		if err != nil {
-			log.Println(err)
-			sendError(w, "Cannot init account count")
-		}
-	}
-
-	if _, err := client.Batch().
-		Create(client.Doc("account/"+accountNumber), req).
-		Update(accountStat, []firestore.Update{{Path: "Count", Value: firestore.Increment(1)}}).
-		Commit(r.Context()); err != nil {
+	batch := h.store.Batch()
+	batch.CreateAccount(req)
+	batch.IncrementCount("stats/account", 1)
+	batch.SetCustomerSearchEntry(customerSearchEntry(*customer, append(accountNumbers(accounts), req.Number)))
+	if err := batch.Commit(r.Context()); err != nil {
 		sendError(w, err.Error())
 		return
 	}
@@ -256,12 +296,10 @@ func CreateAccountHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func ListAccountHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).ListAccountHTTP)
+}
+
+func (h *Handler) ListAccountHTTP(w http.ResponseWriter, r *http.Request) {
 	var req FindCustomerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -269,56 +307,18 @@ func ListAccountHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var query firestore.Query = client.Collection("account").OrderBy("CreatedAt", firestore.Desc)
-	if req.Limit > 0 {
-		query = query.Limit(req.Limit)
-	}
-	if req.Offset > 0 {
-		query = query.Offset(req.Offset)
-	}
-	if req.SalesRepID != "" {
-		query = query.Where("SalesRepID", "==", req.SalesRepID)
-	}
-
-	var accounts []Account
-	iter := query.Documents(r.Context())
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Println(err)
-			sendError(w, "cannot read account data")
-			return
-		}
-		var c Account
-		if err = doc.DataTo(&c); err != nil {
-			log.Println(err)
-			sendError(w, "cannot read account data")
-			return
-		}
-		accounts = append(accounts, c)
-	}
-
-	customerStatRef := client.Doc("stats/account")
-	totalCount, err := getCount(r.Context(), customerStatRef)
-	if err != nil {
-		sendError(w, "cannot get the total count of accounts")
-		log.Println(err)
-		return
-	}
-	sendPagedResponse(w, accounts, totalCount)
+	h.listAccounts(w, r, AccountQuery{
+		SalesRepID: req.SalesRepID,
+		Limit:      req.Limit,
+		Offset:     req.Offset,
+	})
 }
 
 func ListDSAccountHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).ListDSAccountHTTP)
+}
+
+func (h *Handler) ListDSAccountHTTP(w http.ResponseWriter, r *http.Request) {
 	var req FindCustomerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -326,58 +326,20 @@ func ListDSAccountHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var query firestore.Query = client.Collection("account").Where("Type", "==", "DS").Where("Balance", ">", 0).
-		OrderBy("CreatedAt", firestore.Desc)
-	if req.Limit > 0 {
-		query = query.Limit(req.Limit)
-	}
-	if req.Offset > 0 {
-		query = query.Offset(req.Offset)
-	}
-	if req.SalesRepID != "" {
-		query = query.Where("SalesRepID", "==", req.SalesRepID)
-	}
-
-	var accounts []Account
-	iter := query.Documents(r.Context())
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Println(err)
-			sendError(w, "cannot read account data")
-			return
-		}
-		var c Account
-		if err = doc.DataTo(&c); err != nil {
-			log.Println(err)
-			sendError(w, "cannot read account data")
-			return
-		}
-		accounts = append(accounts, c)
-	}
-
-	customerStatRef := client.Doc("stats/account")
-	totalCount, err := getCount(r.Context(), customerStatRef)
-	if err != nil {
-		sendError(w, "cannot get the total count of accounts")
-		log.Println(err)
-		return
-	}
-
-	sendPagedResponse(w, accounts, totalCount)
+	h.listAccounts(w, r, AccountQuery{
+		Type:            AccountTypeDS,
+		PositiveBalance: true,
+		SalesRepID:      req.SalesRepID,
+		Limit:           req.Limit,
+		Offset:          req.Offset,
+	})
 }
 
 func ListDebtorsHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).ListDebtorsHTTP)
+}
+
+func (h *Handler) ListDebtorsHTTP(w http.ResponseWriter, r *http.Request) {
 	var req FindCustomerRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -389,44 +351,25 @@ func ListDebtorsHTTP(w http.ResponseWriter, r *http.Request) {
 	threeDaysAgo := currentDate.Add(-3 * 24 * time.Hour).Unix()
 	thirtyDaysAgo := currentDate.Add(-30 * 24 * time.Hour).Unix()
 
-	var query firestore.Query = client.Collection("account").Where("Type", "==", "DS").
-		Where("LastPaymentDate", ">=", thirtyDaysAgo).
-		Where("LastPaymentDate", "<=", threeDaysAgo).
-		OrderBy("LastPaymentDate", firestore.Asc)
-	if req.Limit > 0 {
-		query = query.Limit(req.Limit)
-	}
-	if req.Offset > 0 {
-		query = query.Offset(req.Offset)
-	}
-	if req.SalesRepID != "" {
-		query = query.Where("SalesRepID", "==", req.SalesRepID)
-	}
+	h.listAccounts(w, r, AccountQuery{
+		Type:            AccountTypeDS,
+		LastPaymentFrom: thirtyDaysAgo,
+		LastPaymentTo:   threeDaysAgo,
+		SalesRepID:      req.SalesRepID,
+		Limit:           req.Limit,
+		Offset:          req.Offset,
+	})
+}
 
-	var accounts []Account
-	iter := query.Documents(r.Context())
-	defer iter.Stop()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Println(err)
-			sendError(w, "cannot read account data")
-			return
-		}
-		var c Account
-		if err = doc.DataTo(&c); err != nil {
-			log.Println(err)
-			sendError(w, "cannot read account data")
-			return
-		}
-		accounts = append(accounts, c)
+func (h *Handler) listAccounts(w http.ResponseWriter, r *http.Request, query AccountQuery) {
+	accounts, err := h.store.ListAccounts(r.Context(), query)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read account data")
+		return
 	}
 
-	customerStatRef := client.Doc("stats/account")
-	totalCount, err := getCount(r.Context(), customerStatRef)
+	totalCount, err := h.store.Count(r.Context(), "stats/account")
 	if err != nil {
 		sendError(w, "cannot get the total count of accounts")
 		log.Println(err)
@@ -437,12 +380,10 @@ func ListDebtorsHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func FindAccountByIdHTTP(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).FindAccountByIdHTTP)
+}
+
+func (h *Handler) FindAccountByIdHTTP(w http.ResponseWriter, r *http.Request) {
 	var req FindByIdRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -450,7 +391,7 @@ func FindAccountByIdHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	account, err := getAccountByNumber(r.Context(), req.ID, client)
+	account, err := getAccountByNumber(r.Context(), req.ID, h.store)
 	if err != nil {
 		log.Println(err)
 		sendError(w, "Cannot read account by the specified number")
@@ -460,34 +401,58 @@ func FindAccountByIdHTTP(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, account)
 }
 
-func getAccountByNumber(ctx context.Context, accountNumber string, client *firestore.Client) (*Account, error) {
-	docSnap, err := client.Collection("account").Doc(accountNumber).Get(ctx)
-	if err != nil {
-		return nil, err
+// errInvalidAccountNumber is returned for account numbers with a wrong format
+// or check digit.
+var errInvalidAccountNumber = errors.New("invalid account number")
+
+// accountSequenceDigits is the zero padded width of the account sequence.
+const accountSequenceDigits = 7
+
+func getAccountByNumber(ctx context.Context, accountNumber string, store Store) (*Account, error) {
+	if !validAccountNumber(accountNumber) {
+		return nil, errInvalidAccountNumber
 	}
+	return store.GetAccount(ctx, accountNumber)
+}
 
-	var account Account
-	if err = docSnap.DataTo(&account); err != nil {
-		return nil, err
+// validAccountNumber reports whether accountNumber is either a legacy number,
+// made of the account type and five digits, or a number whose last digit is
+// the Luhn check digit of the account sequence before it.
+func validAccountNumber(accountNumber string) bool {
+	i := strings.IndexFunc(accountNumber, unicode.IsDigit)
+	if i <= 0 {
+		return false
+	}
+	if digits := accountNumber[i:]; len(digits) == 5 && isDigits(digits) {
+		return true
 	}
-	return &account, nil
+	n := len(accountNumber) - accountSequenceDigits - 1
+	return n >= i && validLuhn(accountNumber[n:])
 }
 
-func generateAccountNumber(ctx context.Context, client *firestore.Client, accountType string) (string, error) {
-	var accountNumber string
-	var unique bool
-	for !unique {
-		accountNumber = accountType
-		rand.Seed(time.Now().UTC().UnixNano())
-		for i := 0; i < 5; i++ {
-			accountNumber += strconv.Itoa(rand.Intn(10))
-		}
-		_, err := client.Doc("account/" + accountNumber).Get(ctx)
-		if err != nil {
-			unique = true
-		}
+// accountNumbersPerBranch reports whether account numbers are allocated from
+// a separate sequence per branch.
+func accountNumbersPerBranch() bool {
+	return os.Getenv("ACCOUNT_NUMBER_PER_BRANCH") == "true"
+}
+
+// generateAccountNumber allocates the next account number of the type, e.g.
+// SB00001234 where the final 4 is the check digit of the sequence 0000123.
+// When numbers are allocated per branch the branch prefix follows the type.
+func generateAccountNumber(ctx context.Context, store Store, accountType, branchID string) (string, error) {
+	if accountType == "" || strings.IndexFunc(accountType, func(c rune) bool { return !unicode.IsLetter(c) }) >= 0 {
+		return "", errors.Errorf("invalid account type %q", accountType)
+	}
+	prefix := accountType
+	if accountNumbersPerBranch() {
+		prefix += receiptPrefix(branchID)
 	}
-	return accountNumber, nil
+	seq, err := store.NextSequence(ctx, "account-"+prefix)
+	if err != nil {
+		return "", err
+	}
+	digits := fmt.Sprintf("%0*d", accountSequenceDigits, seq)
+	return fmt.Sprintf("%s%s%d", prefix, digits, luhnCheckDigit(digits)), nil
 }
 
 // Customer represents a workflow.
@@ -502,9 +467,13 @@ type Customer struct {
 	BranchID    string `json:"branch_id" truss:"api-read"`
 	SalesRep    string `json:"sales_rep" truss:"api-read"`
 	Branch      string `json:"branch" truss:"api-read"`
-	CreatedAt   int64  `json:"created_at" truss:"api-read"`
-	UpdatedAt   int64  `json:"updated_at" truss:"api-read"`
-	ArchivedAt  int64  `json:"archived_at,omitempty" truss:"api-hide"`
+	// Language is the language of the messages to the customer, English
+	// when empty.
+	Language      string                  `json:"language,omitempty" truss:"api-read"`
+	Notifications NotificationPreferences `json:"notifications" truss:"api-read"`
+	CreatedAt     int64                   `json:"created_at" truss:"api-read"`
+	UpdatedAt     int64                   `json:"updated_at" truss:"api-read"`
+	ArchivedAt    int64                   `json:"archived_at,omitempty" truss:"api-hide"`
 }
 
 // FindRequest defines the possible options to search for customers. By default
@@ -515,26 +484,32 @@ type FindCustomerRequest struct {
 	Order      []string      `json:"order" example:"created_at desc"`
 	Limit      int           `json:"limit" example:"10"`
 	Offset     int           `json:"offset" example:"20"`
+	// IncludeArchived lists the archived customers as well.
+	IncludeArchived bool `json:"include_archived"`
+	// Delivery lists only the customers with this notification delivery.
+	Delivery string `json:"delivery"`
 }
 
 // Account represents a customer account.
 type Account struct {
-	Number             string  `json:"number"  validate:"required" example:"Rocket Launch"`
-	CustomerID         string  `json:"customer_id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
-	Type               string  `json:"type" truss:"api-read"`
-	Balance            float64 `json:"balance" truss:"api-read"`
-	Target             float64 `json:"target" truss:"api-read"`
-	TargetInfo         string  `json:"target_info" truss:"api-read"`
-	SalesRepID         string  `json:"sales_rep_id" truss:"api-read"`
-	BranchID           string  `json:"branch_id" truss:"api-read"`
-	LastPaymentDate    int64   `json:"last_payment_date"`
-	LastCommissionDate int64   `json:"last_commission"`
-	CreatedAt          int64   `json:"created_at" truss:"api-read"`
-	UpdatedAt          int64   `json:"updated_at" truss:"api-read"`
-	ArchivedAt         int64   `json:"archived_at,omitempty" truss:"api-hide"`
-	SalesRep           string  `json:"sales_rep" truss:"api-read"`
-	Branch             string  `json:"branch" truss:"api-read"`
-	Customer           string  `json:"customer"`
+	Number             string `json:"number"  validate:"required" example:"Rocket Launch"`
+	CustomerID         string `json:"customer_id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Type               string `json:"type" truss:"api-read"`
+	Balance            Money  `json:"balance" truss:"api-read"`
+	Target             Money  `json:"target" truss:"api-read"`
+	TargetInfo         string `json:"target_info" truss:"api-read"`
+	SalesRepID         string `json:"sales_rep_id" truss:"api-read"`
+	BranchID           string `json:"branch_id" truss:"api-read"`
+	LastPaymentDate    int64  `json:"last_payment_date"`
+	LastCommissionDate int64  `json:"last_commission"`
+	// CurrentCycleID is the DS cycle receiving the contributions of the account.
+	CurrentCycleID string `json:"current_cycle_id,omitempty" truss:"api-read"`
+	CreatedAt      int64  `json:"created_at" truss:"api-read"`
+	UpdatedAt      int64  `json:"updated_at" truss:"api-read"`
+	ArchivedAt     int64  `json:"archived_at,omitempty" truss:"api-hide"`
+	SalesRep       string `json:"sales_rep" truss:"api-read"`
+	Branch         string `json:"branch" truss:"api-read"`
+	Customer       string `json:"customer"`
 
 	RecentTransactions []Transaction
 }
@@ -551,7 +526,10 @@ type CreateCustomerRequest struct {
 	SalesRep    string `json:"sales_rep" truss:"api-read"`
 	Branch      string `json:"branch" truss:"api-read"`
 
-	Type       string  `json:"type" validate:"required"`
-	Target     float64 `json:"target"`
-	TargetInfo string  `json:"target_info"`
+	Language      string                  `json:"language"`
+	Notifications NotificationPreferences `json:"notifications"`
+
+	Type       string `json:"type" validate:"required"`
+	Target     Money  `json:"target"`
+	TargetInfo string `json:"target_info"`
 }
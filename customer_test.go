@@ -0,0 +1,39 @@
+package surebankltd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGenerateAccountNumber(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	for _, want := range []string{"SB00000018", "SB00000026", "SB00000034"} {
+		number, err := generateAccountNumber(ctx, store, AccountTypeSB, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number != want || !validAccountNumber(number) {
+			t.Errorf("generateAccountNumber = %s, want %s", number, want)
+		}
+	}
+	if number, err := generateAccountNumber(ctx, store, AccountTypeDS, ""); err != nil || number != "DS00000018" {
+		t.Errorf("first DS number = %s, %v, want DS00000018", number, err)
+	}
+	if _, err := generateAccountNumber(ctx, store, "S1", ""); err == nil {
+		t.Error("an account type with a digit was accepted")
+	}
+
+	for number, want := range map[string]bool{
+		"SB00012":    true,
+		"SB00000018": true,
+		"SB00000019": false,
+		"SB0000018":  false,
+		"00000018":   false,
+	} {
+		if got := validAccountNumber(number); got != want {
+			t.Errorf("validAccountNumber(%q) = %v, want %v", number, got, want)
+		}
+	}
+}
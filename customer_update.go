@@ -0,0 +1,400 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// customerRenamePageSize is the number of transactions renamed per batch
+// when the name of a customer changes.
+const customerRenamePageSize = 400
+
+// CustomerChange records a change made to a customer, one FieldChange per
+// field that changed.
+type CustomerChange struct {
+	ID         string        `json:"id" truss:"api-read"`
+	CustomerID string        `json:"customer_id"`
+	Changes    []FieldChange `json:"changes"`
+	ChangedBy  string        `json:"changed_by"`
+	Reason     string        `json:"reason,omitempty"`
+	CreatedAt  int64         `json:"created_at" truss:"api-read"`
+}
+
+// FieldChange is the value of a customer field before and after a change.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// customerChanges returns the changes from the details of old to those of
+// updated, named after their JSON fields.
+func customerChanges(old, updated Customer) []FieldChange {
+	fields := []struct {
+		name     string
+		old, new string
+	}{
+		{"name", old.Name, updated.Name},
+		{"short_name", old.ShortName, updated.ShortName},
+		{"email", old.Email, updated.Email},
+		{"phone_number", old.PhoneNumber, updated.PhoneNumber},
+		{"address", old.Address, updated.Address},
+		{"sales_rep_id", old.SalesRepID, updated.SalesRepID},
+		{"sales_rep", old.SalesRep, updated.SalesRep},
+		{"branch_id", old.BranchID, updated.BranchID},
+		{"branch", old.Branch, updated.Branch},
+		{"archived_at", archivedAt(old), archivedAt(updated)},
+	}
+	var changes []FieldChange
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, FieldChange{Field: f.name, From: f.old, To: f.new})
+		}
+	}
+	return changes
+}
+
+func archivedAt(customer Customer) string {
+	if customer.ArchivedAt == 0 {
+		return ""
+	}
+	return strconv.FormatInt(customer.ArchivedAt, 10)
+}
+
+// UpdateCustomerRequest holds the new details of a customer. Every detail is
+// replaced, so the current values must be sent for the fields that do not
+// change.
+type UpdateCustomerRequest struct {
+	ID          string `json:"id" validate:"required"`
+	Name        string `json:"name" validate:"required"`
+	ShortName   string `json:"short_name"`
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Address     string `json:"address"`
+	SalesRepID  string `json:"sales_rep_id"`
+	SalesRep    string `json:"sales_rep"`
+	BranchID    string `json:"branch_id"`
+	Branch      string `json:"branch"`
+	UpdatedBy   string `json:"updated_by" validate:"required"`
+}
+
+// UpdateCustomerHTTP is an HTTP Cloud Function that updates the details of
+// a customer. A new name is copied to the accounts and the transactions of
+// the customer.
+func UpdateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).UpdateCustomerHTTP)
+}
+
+func (h *Handler) UpdateCustomerHTTP(w http.ResponseWriter, r *http.Request) {
+	var req UpdateCustomerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	customer, err := updateCustomer(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot update customer, %s", err.Error())
+		return
+	}
+	sendResponse(w, customer)
+}
+
+func updateCustomer(ctx context.Context, req UpdateCustomerRequest, currentDate time.Time, store Store) (*Customer, error) {
+	switch {
+	case req.Name == "":
+		return nil, errors.New("name is required")
+	case req.PhoneNumber == "":
+		return nil, errors.New("phone number is required")
+	case req.UpdatedBy == "":
+		return nil, errors.New("the updating user is required")
+	}
+
+	var customer *Customer
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		old, err := tx.GetCustomer(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read customer, please check the ID")
+		}
+		if old.ArchivedAt > 0 {
+			return errors.New("the customer is archived")
+		}
+		accounts, err := tx.ListAccounts(ctx, AccountQuery{CustomerID: old.ID})
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read the accounts of the customer")
+		}
+
+		updated := *old
+		updated.Name = req.Name
+		updated.ShortName = req.ShortName
+		updated.Email = req.Email
+		updated.PhoneNumber = req.PhoneNumber
+		updated.Address = req.Address
+		updated.SalesRepID = req.SalesRepID
+		updated.SalesRep = req.SalesRep
+		updated.BranchID = req.BranchID
+		updated.Branch = req.Branch
+		customer = &updated
+
+		changes := customerChanges(*old, updated)
+		if len(changes) == 0 {
+			return nil
+		}
+		customer.UpdatedAt = currentDate.Unix()
+		tx.UpdateCustomer(*customer)
+		tx.CreateCustomerChange(CustomerChange{
+			ID:         uuid.NewRandom().String(),
+			CustomerID: customer.ID,
+			Changes:    changes,
+			ChangedBy:  req.UpdatedBy,
+			CreatedAt:  currentDate.Unix(),
+		})
+		tx.SetCustomerSearchEntry(customerSearchEntry(*customer, accountNumbers(accounts)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The name is copied even when it did not change, so that sending the
+	// update again completes a copy that failed.
+	if err = renameCustomer(ctx, store, *customer); err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+// renameCustomer copies the name of customer to the accounts of the customer
+// whose name differs and to their transactions. An account is renamed after
+// its transactions, so that an account with the new name has nothing left to
+// rename.
+func renameCustomer(ctx context.Context, store Store, customer Customer) error {
+	accounts, err := store.ListAccounts(ctx, AccountQuery{CustomerID: customer.ID})
+	if err != nil {
+		log.Println(err)
+		return errors.New("the customer was updated but its accounts cannot be read")
+	}
+	for _, account := range accounts {
+		if account.Customer == customer.Name {
+			continue
+		}
+		transactions, err := store.ListTransactions(ctx, TransactionQuery{AccountNumber: account.Number})
+		if err != nil {
+			log.Println(err)
+			return errors.Errorf("the customer was updated but the transactions of %s cannot be read", account.Number)
+		}
+
+		var receipts []string
+		for _, t := range transactions {
+			if t.CustomerName != customer.Name {
+				receipts = append(receipts, t.ReceiptNo)
+			}
+		}
+		for len(receipts) > 0 {
+			n := len(receipts)
+			if n > customerRenamePageSize {
+				n = customerRenamePageSize
+			}
+			batch := store.Batch()
+			for _, receiptNo := range receipts[:n] {
+				batch.RenameTransactionCustomer(receiptNo, customer.Name)
+			}
+			if err = batch.Commit(ctx); err != nil {
+				log.Println(err)
+				return errors.Errorf("the customer was updated but the transactions of %s cannot be renamed", account.Number)
+			}
+			receipts = receipts[n:]
+		}
+
+		account.Customer = customer.Name
+		for i := range account.RecentTransactions {
+			account.RecentTransactions[i].CustomerName = customer.Name
+		}
+		batch := store.Batch()
+		batch.RenameAccountCustomer(account)
+		if err = batch.Commit(ctx); err != nil {
+			log.Println(err)
+			return errors.Errorf("the customer was updated but account %s cannot be renamed", account.Number)
+		}
+	}
+	return nil
+}
+
+// ArchiveCustomerRequest defines the customer to archive or restore and why.
+type ArchiveCustomerRequest struct {
+	ID         string `json:"id" validate:"required"`
+	ArchivedBy string `json:"archived_by" validate:"required"`
+	Reason     string `json:"reason" validate:"required"`
+}
+
+// ArchiveCustomerHTTP is an HTTP Cloud Function that archives a customer
+// whose accounts are all empty. Archived customers are left out of the
+// customer list and cannot receive deposits.
+func ArchiveCustomerHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ArchiveCustomerHTTP)
+}
+
+func (h *Handler) ArchiveCustomerHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ArchiveCustomerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	customer, err := archiveCustomer(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot archive customer, %s", err.Error())
+		return
+	}
+	sendResponse(w, customer)
+}
+
+func archiveCustomer(ctx context.Context, req ArchiveCustomerRequest, currentDate time.Time, store Store) (*Customer, error) {
+	if req.ArchivedBy == "" || req.Reason == "" {
+		return nil, errors.New("the archiving user and reason are required")
+	}
+
+	var customer *Customer
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		customer, err = tx.GetCustomer(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read customer, please check the ID")
+		}
+		if customer.ArchivedAt > 0 {
+			return errors.New("the customer is already archived")
+		}
+		// The accounts are read in the transaction so that a deposit made
+		// meanwhile makes it retry.
+		accounts, err := tx.ListAccounts(ctx, AccountQuery{CustomerID: customer.ID})
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read the accounts of the customer")
+		}
+		for _, account := range accounts {
+			if account.Balance != 0 {
+				return errors.Errorf("account %s has a balance of %s", account.Number, account.Balance)
+			}
+		}
+
+		setCustomerArchived(tx, customer, accounts, currentDate.Unix(), req, currentDate)
+		tx.IncrementCount("stats/customer", -1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+// RestoreCustomerHTTP is an HTTP Cloud Function that brings an archived
+// customer back.
+func RestoreCustomerHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).RestoreCustomerHTTP)
+}
+
+func (h *Handler) RestoreCustomerHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ArchiveCustomerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	customer, err := restoreCustomer(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot restore customer, %s", err.Error())
+		return
+	}
+	sendResponse(w, customer)
+}
+
+func restoreCustomer(ctx context.Context, req ArchiveCustomerRequest, currentDate time.Time, store Store) (*Customer, error) {
+	if req.ArchivedBy == "" || req.Reason == "" {
+		return nil, errors.New("the restoring user and reason are required")
+	}
+
+	var customer *Customer
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		customer, err = tx.GetCustomer(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read customer, please check the ID")
+		}
+		if customer.ArchivedAt == 0 {
+			return errors.New("the customer is not archived")
+		}
+		accounts, err := tx.ListAccounts(ctx, AccountQuery{CustomerID: customer.ID})
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read the accounts of the customer")
+		}
+
+		setCustomerArchived(tx, customer, accounts, 0, req, currentDate)
+		tx.IncrementCount("stats/customer", 1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+// setCustomerArchived sets the archiving time of customer, zero restoring it,
+// and records the change.
+func setCustomerArchived(tx Tx, customer *Customer, accounts []Account, archivedAt int64,
+	req ArchiveCustomerRequest, currentDate time.Time) {
+
+	old := *customer
+	customer.ArchivedAt = archivedAt
+	customer.UpdatedAt = currentDate.Unix()
+	tx.UpdateCustomer(*customer)
+	tx.CreateCustomerChange(CustomerChange{
+		ID:         uuid.NewRandom().String(),
+		CustomerID: customer.ID,
+		Changes:    customerChanges(old, *customer),
+		ChangedBy:  req.ArchivedBy,
+		Reason:     req.Reason,
+		CreatedAt:  currentDate.Unix(),
+	})
+	tx.SetCustomerSearchEntry(customerSearchEntry(*customer, accountNumbers(accounts)))
+}
+
+// CustomerHistoryHTTP is an HTTP Cloud Function that lists the changes made
+// to a customer, newest first.
+func CustomerHistoryHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).CustomerHistoryHTTP)
+}
+
+func (h *Handler) CustomerHistoryHTTP(w http.ResponseWriter, r *http.Request) {
+	var req FindByIdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	changes, err := h.store.ListCustomerChanges(r.Context(), req.ID)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read the changes of the customer")
+		return
+	}
+	if changes == nil {
+		changes = []CustomerChange{}
+	}
+	sendResponse(w, changes)
+}
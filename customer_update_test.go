@@ -0,0 +1,247 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveAndRestoreCustomer(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00030", AccountTypeSB, 100*Naira)
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	req := ArchiveCustomerRequest{ID: "customer-SB00030", ArchivedBy: "manager", Reason: "left"}
+
+	if _, err := archiveCustomer(ctx, req, day, store); err == nil {
+		t.Fatal("a customer with money on an account was archived")
+	}
+	if _, err := makeDeduction(ctx, MakeDeductionRequest{
+		AccountNumber: "SB00030",
+		Amount:        100 * Naira,
+		PaymentMethod: PaymentMethod_Cash,
+	}, day, store); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, err := archiveCustomer(ctx, req, day.Add(time.Hour), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived.ArchivedAt != day.Add(time.Hour).Unix() {
+		t.Errorf("archived at %d, want %d", archived.ArchivedAt, day.Add(time.Hour).Unix())
+	}
+	if _, err = archiveCustomer(ctx, req, day.Add(time.Hour), store); err == nil {
+		t.Error("an archived customer was archived again")
+	}
+	listed := func() bool {
+		t.Helper()
+		customers, err := store.ListCustomers(ctx, FindCustomerRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range customers {
+			if c.ID == req.ID {
+				return true
+			}
+		}
+		return false
+	}
+	if listed() {
+		t.Error("an archived customer is listed")
+	}
+	if _, err = updateCustomer(ctx, UpdateCustomerRequest{
+		ID:          req.ID,
+		Name:        "New Name",
+		PhoneNumber: "08030000000",
+		UpdatedBy:   "manager",
+	}, day.Add(time.Hour), store); err == nil {
+		t.Error("an archived customer was updated")
+	}
+
+	restored, err := restoreCustomer(ctx, req, day.Add(2*time.Hour), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ArchivedAt != 0 || !listed() {
+		t.Errorf("restored customer %+v is not listed", restored)
+	}
+	if _, err = restoreCustomer(ctx, req, day.Add(2*time.Hour), store); err == nil {
+		t.Error("a customer that is not archived was restored")
+	}
+}
+
+func TestCustomerHistory(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00031", AccountTypeSB, 0)
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+
+	update := UpdateCustomerRequest{
+		ID:          "customer-SB00031",
+		Name:        "Test Customer",
+		PhoneNumber: "08031111111",
+		Address:     "12 Marina",
+		UpdatedBy:   "clerk",
+	}
+	if _, err := updateCustomer(ctx, update, day, store); err != nil {
+		t.Fatal(err)
+	}
+	// Sending the same details again records nothing.
+	if _, err := updateCustomer(ctx, update, day.Add(time.Minute), store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archiveCustomer(ctx, ArchiveCustomerRequest{
+		ID:         update.ID,
+		ArchivedBy: "manager",
+		Reason:     "duplicate",
+	}, day.Add(time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	NewHandler(store).CustomerHistoryHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"id":"customer-SB00031"}`)))
+	var resp struct {
+		Success bool
+		Data    []CustomerChange
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success {
+		t.Fatalf("got response %s, %v", w.Body, err)
+	}
+	if len(resp.Data) != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", len(resp.Data), resp.Data)
+	}
+
+	archive := resp.Data[0]
+	want := FieldChange{Field: "archived_at", To: fmt.Sprint(day.Add(time.Hour).Unix())}
+	if len(archive.Changes) != 1 || archive.Changes[0] != want || archive.ChangedBy != "manager" || archive.Reason != "duplicate" {
+		t.Errorf("got archive change %+v", archive)
+	}
+	edit := resp.Data[1]
+	wantEdit := []FieldChange{
+		{Field: "phone_number", From: "08030000000", To: "08031111111"},
+		{Field: "address", To: "12 Marina"},
+	}
+	if len(edit.Changes) != len(wantEdit) || edit.ChangedBy != "clerk" || edit.CreatedAt != day.Unix() {
+		t.Fatalf("got edit change %+v", edit)
+	}
+	for i, c := range wantEdit {
+		if edit.Changes[i] != c {
+			t.Errorf("change %d = %+v, want %+v", i, edit.Changes[i], c)
+		}
+	}
+}
+
+// renameCountingStore records the number of transactions renamed by each
+// batch.
+type renameCountingStore struct {
+	*MemoryStore
+	batches []int
+}
+
+func (s *renameCountingStore) Batch() Batch {
+	return &renameCountingBatch{Batch: s.MemoryStore.Batch(), store: s}
+}
+
+type renameCountingBatch struct {
+	Batch
+	store   *renameCountingStore
+	renames int
+}
+
+func (b *renameCountingBatch) RenameTransactionCustomer(receiptNo, customerName string) {
+	b.renames++
+	b.Batch.RenameTransactionCustomer(receiptNo, customerName)
+}
+
+func (b *renameCountingBatch) Commit(ctx context.Context) error {
+	if b.renames > 0 {
+		b.store.batches = append(b.store.batches, b.renames)
+	}
+	return b.Batch.Commit(ctx)
+}
+
+func TestRenameCustomer(t *testing.T) {
+	ctx := context.Background()
+	store := &renameCountingStore{MemoryStore: NewMemoryStore()}
+	newTestAccount(t, store, "SB00032", AccountTypeSB, 0)
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+
+	const deposits = customerRenamePageSize + 50
+	for i := 0; i < deposits; i += 50 {
+		batch := store.MemoryStore.Batch()
+		for j := i; j < i+50; j++ {
+			batch.CreateTransaction(Transaction{
+				ReceiptNo:     fmt.Sprintf("TX%06d", j),
+				Type:          TransactionType_Deposit,
+				AccountNumber: "SB00032",
+				CustomerID:    "customer-SB00032",
+				CustomerName:  "Test Customer",
+				Amount:        10 * Naira,
+				CreatedAt:     day.Add(time.Duration(j) * time.Second).Unix(),
+			})
+		}
+		if err := batch.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := create(ctx, Transaction{
+		AccountNumber: "SB00032",
+		Type:          TransactionType_Deposit,
+		Amount:        10 * Naira,
+		PaymentMethod: PaymentMethod_Cash,
+	}, day.Add(time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+	store.batches = nil
+
+	if _, err := updateCustomer(ctx, UpdateCustomerRequest{
+		ID:          "customer-SB00032",
+		Name:        "Renamed Customer",
+		PhoneNumber: "08030000000",
+		UpdatedBy:   "clerk",
+	}, day.Add(2*time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 2 || store.batches[0] != customerRenamePageSize || store.batches[1] != deposits+1-customerRenamePageSize {
+		t.Errorf("renamed transactions in batches of %v", store.batches)
+	}
+
+	transactions, err := store.ListTransactions(ctx, TransactionQuery{AccountNumber: "SB00032"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != deposits+1 {
+		t.Fatalf("got %d transactions, want %d", len(transactions), deposits+1)
+	}
+	for _, tx := range transactions {
+		if tx.CustomerName != "Renamed Customer" {
+			t.Fatalf("transaction %s still names %q", tx.ReceiptNo, tx.CustomerName)
+		}
+	}
+	account, err := store.GetAccount(ctx, "SB00032")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Customer != "Renamed Customer" || len(account.RecentTransactions) == 0 {
+		t.Fatalf("got account %+v", account)
+	}
+	for _, tx := range account.RecentTransactions {
+		if tx.CustomerName != "Renamed Customer" {
+			t.Errorf("recent transaction %s still names %q", tx.ReceiptNo, tx.CustomerName)
+		}
+	}
+
+	// Nothing is left to rename once the account has the new name.
+	store.batches = nil
+	if err = renameCustomer(ctx, store, Customer{ID: "customer-SB00032", Name: "Renamed Customer"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.batches) != 0 {
+		t.Errorf("renaming again renamed transactions in batches of %v", store.batches)
+	}
+}
@@ -0,0 +1,339 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pkg/errors"
+)
+
+// DailySummary is the activity of a branch on a day. Postings add to the
+// summary of the day they are made on; closing the day sets its cash
+// position and stops further postings to it.
+type DailySummary struct {
+	BranchID string `json:"branch_id"`
+	Date     int64  `boil:"date" json:"date" toml:"date" yaml:"date"`
+	// Income is the total of the deposits.
+	Income       Money `boil:"income" json:"income" toml:"income" yaml:"income"`
+	DepositCount int64 `json:"deposit_count"`
+	// Withdrawals excludes the DS fees, which are in Fees.
+	Withdrawals     Money `json:"withdrawals"`
+	WithdrawalCount int64 `json:"withdrawal_count"`
+	Fees            Money `json:"fees"`
+	// Expenditure is the total of the expenses paid by the branch.
+	Expenditure Money `boil:"expenditure" json:"expenditure" toml:"expenditure" yaml:"expenditure"`
+	// BankDeposit is the cash lodged at the bank on the day and Lodged the
+	// part of the cash collected on the day that has been lodged since.
+	BankDeposit Money `boil:"bank_deposit" json:"bank_deposit" toml:"bank_deposit" yaml:"bank_deposit"`
+	Lodged      Money `json:"lodged"`
+	// CashIn and CashOut are the deposits received and the withdrawals paid in cash.
+	CashIn      Money  `json:"cash_in"`
+	CashOut     Money  `json:"cash_out"`
+	OpeningCash Money  `json:"opening_cash"`
+	ClosingCash Money  `json:"closing_cash"`
+	Closed      bool   `json:"closed"`
+	ClosedAt    int64  `json:"closed_at,omitempty"`
+	ClosedBy    string `json:"closed_by,omitempty"`
+}
+
+// cashInHand returns the cash held by the branch at the end of the day.
+func (s DailySummary) cashInHand() Money {
+	return s.OpeningCash + s.CashIn - s.CashOut - s.Expenditure - s.BankDeposit
+}
+
+// unlodgedCash returns the cash kept from the collections of the day that
+// has not been lodged yet.
+func (s DailySummary) unlodgedCash() Money {
+	return s.CashIn - s.CashOut - s.Expenditure - s.Lodged
+}
+
+// DayLock records the last day closed at a branch. Postings to that day and
+// to earlier days are rejected.
+type DayLock struct {
+	BranchID      string `json:"branch_id"`
+	ClosedThrough int64  `json:"closed_through"`
+	ClosingCash   Money  `json:"closing_cash"`
+	ClosedAt      int64  `json:"closed_at"`
+}
+
+// branchKey returns the key of a branch in document IDs.
+func branchKey(branchID string) string {
+	if branchID == "" {
+		return "unassigned"
+	}
+	return branchID
+}
+
+// dailySummaryID returns the document ID of the summary of a branch on a day.
+func dailySummaryID(branchID string, day int64) string {
+	return fmt.Sprintf("%s_%d", branchKey(branchID), day)
+}
+
+// transactionSummary returns the change n times t makes to a daily summary.
+func transactionSummary(t Transaction, n int64) DailySummary {
+	var delta DailySummary
+	amount := t.Amount * Money(n)
+	cash := t.PaymentMethod != PaymentMethod_Bank
+	switch {
+	case t.Kind == TransactionKind_Fee:
+		delta.Fees = amount
+	case t.Type == TransactionType_Withdrawal:
+		delta.Withdrawals = amount
+		delta.WithdrawalCount = n
+		if cash {
+			delta.CashOut = amount
+		}
+	default:
+		delta.Income = amount
+		delta.DepositCount = n
+		if cash {
+			delta.CashIn = amount
+		}
+	}
+	return delta
+}
+
+// recordDailySummary adds t to the summary of the branch on the day it is
+// posted, or takes it out when n is -1.
+func recordDailySummary(w Writer, branchID string, postedAt time.Time, t Transaction, n int64) {
+	w.IncrementDailySummary(branchID, now.New(postedAt).BeginningOfDay().Unix(), transactionSummary(t, n))
+}
+
+// checkDayOpen returns an error when the day of postedAt has been closed at
+// the branch. It reads from tx so it must be called before the first write.
+func checkDayOpen(ctx context.Context, tx Tx, branchID string, postedAt time.Time) error {
+	lock, err := tx.GetDayLock(ctx, branchID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		return errors.New("cannot read the day close of the branch")
+	}
+	if now.New(postedAt).BeginningOfDay().Unix() <= lock.ClosedThrough {
+		return errors.Errorf("the day has been closed, postings are accepted from %s",
+			time.Unix(lock.ClosedThrough, 0).Add(24*time.Hour).Format("2006-01-02"))
+	}
+	return nil
+}
+
+// getDayLock returns the day lock of a branch, an empty lock when no day has
+// been closed yet.
+func getDayLock(ctx context.Context, store Store, branchID string) (*DayLock, error) {
+	lock, err := store.GetDayLock(ctx, branchID)
+	if err == ErrNotFound {
+		return &DayLock{BranchID: branchID}, nil
+	}
+	return lock, err
+}
+
+// CloseDayRequest selects the day to close at a branch. Date defaults to today.
+type CloseDayRequest struct {
+	BranchID string `json:"branch_id"`
+	Date     int64  `json:"date"`
+	ClosedBy string `json:"closed_by" validate:"required"`
+}
+
+// CloseDayHTTP is an HTTP Cloud Function that finalizes the summary of a day
+// at a branch and locks the day against further postings.
+func CloseDayHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).CloseDayHTTP)
+}
+
+func (h *Handler) CloseDayHTTP(w http.ResponseWriter, r *http.Request) {
+	var req CloseDayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.ClosedBy == "" {
+		sendError(w, "closed by is required")
+		return
+	}
+
+	summary, err := closeDay(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot close the day, %s", err.Error())
+		return
+	}
+	sendResponse(w, summary)
+}
+
+// closeDay closes a day at a branch. Days are closed in order so that the
+// closing cash of a day is the opening cash of the next; days without
+// postings need not be closed.
+func closeDay(ctx context.Context, req CloseDayRequest, currentDate time.Time, store Store) (*DailySummary, error) {
+	today := now.New(currentDate).BeginningOfDay()
+	day := today
+	if req.Date > 0 {
+		day = now.New(time.Unix(req.Date, 0)).BeginningOfDay()
+	}
+	if day.After(today) {
+		return nil, errors.New("a day cannot be closed before it starts")
+	}
+
+	lock, err := getDayLock(ctx, store, req.BranchID)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read the day close of the branch")
+	}
+	if day.Unix() <= lock.ClosedThrough {
+		return nil, errors.Errorf("%s is already closed", day.Format("2006-01-02"))
+	}
+	pending, err := store.ListDailySummaries(ctx, DailySummaryQuery{
+		BranchID: req.BranchID,
+		From:     lock.ClosedThrough + 1,
+		To:       day.Unix() - 1,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read the daily summaries of the branch")
+	}
+	if len(pending) > 0 {
+		// Summaries are listed newest first.
+		first := pending[len(pending)-1]
+		return nil, errors.Errorf("please close %s first", time.Unix(first.Date, 0).Format("2006-01-02"))
+	}
+
+	var summary DailySummary
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		lock, err := tx.GetDayLock(ctx, req.BranchID)
+		if err == ErrNotFound {
+			lock, err = &DayLock{BranchID: req.BranchID}, nil
+		}
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read the day close of the branch")
+		}
+		if day.Unix() <= lock.ClosedThrough {
+			return errors.Errorf("%s is already closed", day.Format("2006-01-02"))
+		}
+
+		s, err := tx.GetDailySummary(ctx, req.BranchID, day.Unix())
+		if err == ErrNotFound {
+			s, err = &DailySummary{}, nil
+		}
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read the summary of the day")
+		}
+
+		summary = *s
+		summary.BranchID = req.BranchID
+		summary.Date = day.Unix()
+		summary.OpeningCash = lock.ClosingCash
+		summary.ClosingCash = summary.cashInHand()
+		summary.Closed = true
+		summary.ClosedAt = currentDate.Unix()
+		summary.ClosedBy = req.ClosedBy
+		tx.CloseDailySummary(summary)
+		tx.SetDayLock(DayLock{
+			BranchID:      req.BranchID,
+			ClosedThrough: day.Unix(),
+			ClosingCash:   summary.ClosingCash,
+			ClosedAt:      currentDate.Unix(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// DailySummaryRequest selects the summary of a day at a branch. Date defaults to today.
+type DailySummaryRequest struct {
+	BranchID string `json:"branch_id"`
+	Date     int64  `json:"date"`
+}
+
+// DailySummaryHTTP is an HTTP Cloud Function that returns the summary of a
+// day at a branch. The cash position of a day that is not closed yet is
+// worked out from the last closed day.
+func DailySummaryHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).DailySummaryHTTP)
+}
+
+func (h *Handler) DailySummaryHTTP(w http.ResponseWriter, r *http.Request) {
+	var req DailySummaryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	day := now.New(timeNow()).BeginningOfDay()
+	if req.Date > 0 {
+		day = now.New(time.Unix(req.Date, 0)).BeginningOfDay()
+	}
+
+	summary, err := h.store.GetDailySummary(r.Context(), req.BranchID, day.Unix())
+	if err == ErrNotFound {
+		summary, err = &DailySummary{BranchID: req.BranchID, Date: day.Unix()}, nil
+	}
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read the summary of the day")
+		return
+	}
+	if !summary.Closed {
+		lock, err := getDayLock(r.Context(), h.store, req.BranchID)
+		if err != nil {
+			log.Println(err)
+			sendError(w, "cannot read the day close of the branch")
+			return
+		}
+		if lock.ClosedThrough < summary.Date {
+			summary.OpeningCash = lock.ClosingCash
+		}
+		summary.ClosingCash = summary.cashInHand()
+	}
+	sendResponse(w, summary)
+}
+
+// ListDailySummariesRequest defines the options to filter and page the
+// summaries of a branch.
+type ListDailySummariesRequest struct {
+	BranchID string `json:"branch_id"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	Limit    int    `json:"limit" example:"10"`
+	Offset   int    `json:"offset" example:"20"`
+}
+
+// ListDailySummariesHTTP is an HTTP Cloud Function that lists the summaries
+// of the days of a branch, newest first.
+func ListDailySummariesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListDailySummariesHTTP)
+}
+
+func (h *Handler) ListDailySummariesHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ListDailySummariesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	summaries, err := h.store.ListDailySummaries(r.Context(), DailySummaryQuery{
+		BranchID: req.BranchID,
+		From:     req.From,
+		To:       req.To,
+		Limit:    req.Limit,
+		Offset:   req.Offset,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read daily summaries")
+		return
+	}
+	if summaries == nil {
+		summaries = []DailySummary{}
+	}
+	sendResponse(w, summaries)
+}
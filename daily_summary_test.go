@@ -0,0 +1,122 @@
+package surebankltd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransactionSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   Transaction
+		n    int64
+		want DailySummary
+	}{
+		{
+			name: "cash deposit",
+			tx:   Transaction{Type: TransactionType_Deposit, Amount: 100 * Naira, PaymentMethod: PaymentMethod_Cash},
+			n:    1,
+			want: DailySummary{Income: 100 * Naira, DepositCount: 1, CashIn: 100 * Naira},
+		},
+		{
+			name: "bank deposit",
+			tx:   Transaction{Type: TransactionType_Deposit, Amount: 100 * Naira, PaymentMethod: PaymentMethod_Bank},
+			n:    1,
+			want: DailySummary{Income: 100 * Naira, DepositCount: 1},
+		},
+		{
+			name: "reversed cash withdrawal",
+			tx:   Transaction{Type: TransactionType_Withdrawal, Amount: 40 * Naira, PaymentMethod: PaymentMethod_Cash},
+			n:    -1,
+			want: DailySummary{Withdrawals: -40 * Naira, WithdrawalCount: -1, CashOut: -40 * Naira},
+		},
+		{
+			name: "fee",
+			tx:   Transaction{Type: TransactionType_Withdrawal, Kind: TransactionKind_Fee, Amount: 5 * Naira},
+			n:    1,
+			want: DailySummary{Fees: 5 * Naira},
+		},
+	}
+	for _, tt := range tests {
+		if got := transactionSummary(tt.tx, tt.n); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloseDayInOrderCarriesCash(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00030", AccountTypeSB, 0)
+	day1 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	post := func(txType TransactionType, amount Money, at time.Time) error {
+		if txType == TransactionType_Withdrawal {
+			_, err := makeDeduction(ctx, MakeDeductionRequest{AccountNumber: "SB00030", Amount: amount, PaymentMethod: PaymentMethod_Cash}, at, store)
+			return err
+		}
+		_, err := create(ctx, Transaction{AccountNumber: "SB00030", Type: txType, Amount: amount, PaymentMethod: PaymentMethod_Cash}, at, store)
+		return err
+	}
+	for _, p := range []struct {
+		txType TransactionType
+		amount Money
+		at     time.Time
+	}{
+		{TransactionType_Deposit, 1000 * Naira, day1},
+		{TransactionType_Withdrawal, 300 * Naira, day1.Add(time.Hour)},
+		{TransactionType_Deposit, 500 * Naira, day2},
+	} {
+		if err := post(p.txType, p.amount, p.at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := recordExpense(ctx, Expense{Category: "fuel", Amount: 50 * Naira, Payee: "station", ApprovedBy: "manager"}, day2, store); err != nil {
+		t.Fatal(err)
+	}
+
+	closeAt := func(day time.Time) (*DailySummary, error) {
+		return closeDay(ctx, CloseDayRequest{Date: day.Unix(), ClosedBy: "manager"}, day3, store)
+	}
+	if _, err := closeAt(day2); err == nil || !strings.Contains(err.Error(), "close "+day1.Format("2006-01-02")+" first") {
+		t.Fatalf("closing day 2 before day 1: got error %v", err)
+	}
+
+	first, err := closeAt(day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.OpeningCash != 0 || first.ClosingCash != 700*Naira || !first.Closed {
+		t.Fatalf("got day 1 summary %+v", first)
+	}
+	if _, err = closeAt(day1); err == nil {
+		t.Error("day 1 was closed twice")
+	}
+	if err = post(TransactionType_Deposit, 10*Naira, day1.Add(2*time.Hour)); err == nil {
+		t.Error("a posting to a closed day was accepted")
+	}
+
+	second, err := closeAt(day2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.OpeningCash != 700*Naira || second.ClosingCash != 1150*Naira {
+		t.Fatalf("got day 2 summary %+v, want opening 700 and closing 1,150", second)
+	}
+
+	// A day without activity carries the cash over.
+	third, err := closeAt(day3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.OpeningCash != 1150*Naira || third.ClosingCash != 1150*Naira {
+		t.Fatalf("got day 3 summary %+v", third)
+	}
+	if _, err = closeDay(ctx, CloseDayRequest{Date: day3.AddDate(0, 0, 1).Unix(), ClosedBy: "manager"}, day3, store); err == nil {
+		t.Error("a future day was closed")
+	}
+}
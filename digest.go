@@ -0,0 +1,160 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ademuanthony/surebankltd/notify"
+	"github.com/jinzhu/now"
+	"github.com/pkg/errors"
+)
+
+// DailyDigestsRequest selects the day of the digests, today when zero.
+type DailyDigestsRequest struct {
+	Date int64 `json:"date"`
+}
+
+// DailyDigestsReport counts the digests queued for a day.
+type DailyDigestsReport struct {
+	Date   int64 `json:"date"`
+	Queued int   `json:"queued"`
+}
+
+// SendDailyDigestsHTTP is an HTTP Cloud Function, run on a schedule in the
+// evening, that queues one message per customer in digest mode for the DS
+// contributions of the day. Running it again for a day only queues the
+// digests that were missed.
+func SendDailyDigestsHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).SendDailyDigestsHTTP)
+}
+
+func (h *Handler) SendDailyDigestsHTTP(w http.ResponseWriter, r *http.Request) {
+	var req DailyDigestsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	currentDate := timeNow()
+	day := currentDate
+	if req.Date > 0 {
+		day = time.Unix(req.Date, 0)
+	}
+	report, err := queueDailyDigests(r.Context(), h.store, day, currentDate)
+	if err != nil {
+		sendErrorf(w, "cannot queue digests, %s", err.Error())
+		return
+	}
+	sendResponse(w, report)
+}
+
+// digestPageSize is the number of digest customers read at a time.
+const digestPageSize = 200
+
+func queueDailyDigests(ctx context.Context, store Store, day, currentDate time.Time) (*DailyDigestsReport, error) {
+	start := now.New(day).BeginningOfDay()
+	report := &DailyDigestsReport{Date: start.Unix()}
+	for offset := 0; ; offset += digestPageSize {
+		customers, err := store.ListCustomers(ctx, FindCustomerRequest{
+			Delivery: NotificationDeliveryDigest,
+			Limit:    digestPageSize,
+			Offset:   offset,
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, errors.New("cannot read the customers in digest mode")
+		}
+		for i := range customers {
+			queued, err := queueDailyDigest(ctx, store, &customers[i], start, currentDate)
+			if err != nil {
+				return nil, err
+			}
+			report.Queued += queued
+		}
+		if len(customers) < digestPageSize {
+			return report, nil
+		}
+	}
+}
+
+// queueDailyDigest queues the digest of the DS contributions a customer made
+// on the day starting at start and returns the number of messages queued.
+func queueDailyDigest(ctx context.Context, store Store, customer *Customer, start, currentDate time.Time) (int, error) {
+	accounts, err := store.ListAccounts(ctx, AccountQuery{CustomerID: customer.ID, Type: AccountTypeDS})
+	if err != nil {
+		log.Println(err)
+		return 0, errors.Errorf("cannot read the accounts of %s", customer.Name)
+	}
+
+	payload := notify.DSDigestPayload{Name: customer.Name, Date: start.Format("02/01/2006")}
+	var total Money
+	for _, account := range accounts {
+		transactions, err := store.ListTransactions(ctx, TransactionQuery{
+			AccountNumber: account.Number,
+			From:          start.Unix(),
+			To:            start.AddDate(0, 0, 1).Unix() - 1,
+		})
+		if err != nil {
+			log.Println(err)
+			return 0, errors.Errorf("cannot read the transactions of account %s", account.Number)
+		}
+
+		// Only the contributions still standing are part of the digest.
+		var days int
+		var amount Money
+		for _, t := range transactions {
+			if t.Type != TransactionType_Deposit || t.ReversedBy != "" || t.ReversalOf != "" {
+				continue
+			}
+			days++
+			amount += t.Amount
+		}
+		if days == 0 {
+			continue
+		}
+		total += amount
+		// Transactions are listed newest first, the first one leaves the
+		// balance of the end of the day.
+		payload.Accounts = append(payload.Accounts, notify.DSDigestAccount{
+			AccountNumber: account.Number,
+			Days:          days,
+			Amount:        amount.String(),
+			Balance:       transactions[0].Balance.String(),
+		})
+	}
+	if len(payload.Accounts) == 0 {
+		return 0, nil
+	}
+	sort.Slice(payload.Accounts, func(i, j int) bool {
+		return payload.Accounts[i].AccountNumber < payload.Accounts[j].AccountNumber
+	})
+	payload.Amount = total.String()
+
+	var queued int
+	for _, n := range notifications(
+		newNotification(customer, notify.SMSDSDigest, payload, currentDate),
+		newEmailNotification(customer, notify.EmailDSDigest, payload, currentDate),
+	) {
+		n.ID = fmt.Sprintf("digest_%s_%s_%s", n.Channel, customer.ID, start.Format("20060102"))
+		if _, err := store.GetNotification(ctx, n.ID); err == nil {
+			continue
+		} else if err != ErrNotFound {
+			log.Println(err)
+			return 0, errors.New("cannot read notification")
+		}
+		batch := store.Batch()
+		batch.CreateNotification(n)
+		if err := batch.Commit(ctx); err != nil {
+			log.Println(err)
+			return 0, errors.Errorf("cannot queue the digest of %s", customer.Name)
+		}
+		queued++
+	}
+	return queued, nil
+}
@@ -0,0 +1,100 @@
+package surebankltd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademuanthony/surebankltd/notify"
+)
+
+func TestQueueDailyDigests(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	batch := store.Batch()
+	batch.CreateCustomer(Customer{
+		ID:            "customer-DS00001",
+		Name:          "Digest Customer",
+		PhoneNumber:   "08030000001",
+		Notifications: NotificationPreferences{Delivery: NotificationDeliveryDigest},
+	})
+	batch.CreateAccount(Account{Number: "DS00001", CustomerID: "customer-DS00001", Type: AccountTypeDS, Target: 500 * Naira})
+	batch.CreateCustomer(Customer{ID: "customer-DS00002", Name: "Other Customer", PhoneNumber: "08030000002"})
+	batch.CreateAccount(Account{Number: "DS00002", CustomerID: "customer-DS00002", Type: AccountTypeDS, Target: 500 * Naira})
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	deposit := func(number string, at time.Time) *Transaction {
+		t.Helper()
+		tx, err := create(ctx, Transaction{
+			AccountNumber: number,
+			Type:          TransactionType_Deposit,
+			Amount:        500 * Naira,
+			PaymentMethod: PaymentMethod_Cash,
+		}, at, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	deposit("DS00001", day)
+	deposit("DS00001", day.Add(time.Hour))
+	reversed := deposit("DS00001", day.Add(2*time.Hour))
+	if _, err := reverseTransaction(ctx, ReverseTransactionRequest{
+		ID:         reversed.ReceiptNo,
+		Reason:     "wrong account",
+		ApprovedBy: "manager",
+	}, day.Add(3*time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+	deposit("DS00002", day)
+	account, err := store.GetAccount(ctx, "DS00001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endOfDay := account.Balance
+	// A contribution of the next day changes the balance but not the digest.
+	deposit("DS00001", day.AddDate(0, 0, 1))
+
+	report, err := queueDailyDigests(ctx, store, day, day.Add(10*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Queued != 1 {
+		t.Fatalf("queued %d digests, want 1", report.Queued)
+	}
+	list, err := store.ListNotifications(ctx, NotificationQuery{CustomerID: "customer-DS00001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var digest *Notification
+	for i, n := range list {
+		if n.Template == notify.SMSDSDigest {
+			digest = &list[i]
+		}
+	}
+	if digest == nil {
+		t.Fatal("no digest was queued")
+	}
+	want := "DS00001: 2 day(s), balance " + endOfDay.String() + "."
+	if !strings.Contains(digest.Body, want) || !strings.Contains(digest.Body, (1000*Naira).String()) {
+		t.Errorf("digest %q does not contain %q", digest.Body, want)
+	}
+
+	others, err := store.ListNotifications(ctx, NotificationQuery{CustomerID: "customer-DS00002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range others {
+		if n.Template == notify.SMSDSDigest {
+			t.Errorf("a customer notified of every transaction got digest %q", n.Body)
+		}
+	}
+
+	if report, err = queueDailyDigests(ctx, store, day, day.Add(11*time.Hour)); err != nil || report.Queued != 0 {
+		t.Errorf("running the digests again queued %+v, %v", report, err)
+	}
+}
@@ -1,12 +1,91 @@
 package surebankltd
 
+import (
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
 // DsCommission represents a Commission that is returned for display.
 type DSCommission struct {
-	ID            string  `json:"id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86" truss:"api-read"`
-	AccountNumber string  `json:"account_number" example:"SB10003001" truss:"api-read"`
-	CustomerID    string  `json:"customer_id" truss:"api-read"`
-	CustomerName  string  `json:customer_name" truss:"api-read"`
-	Amount        float64 `json:"amount" truss:"api-read"`
-	Date          int64   `json:"date" truss:"api-read"`
-	EffectiveDate int64   `json:"effective_date" truss:"api-read"`
+	ID            string `json:"id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86" truss:"api-read"`
+	AccountNumber string `json:"account_number" example:"SB10003001" truss:"api-read"`
+	CustomerID    string `json:"customer_id" truss:"api-read"`
+	CustomerName  string `json:"customer_name" truss:"api-read"`
+	Amount        Money  `json:"amount" truss:"api-read"`
+	Date          int64  `json:"date" truss:"api-read"`
+	EffectiveDate int64  `json:"effective_date" truss:"api-read"`
+	// ReceiptNo is the fee transaction and DepositReceiptNo the deposit that
+	// triggered it.
+	ReceiptNo        string `json:"receipt_no,omitempty" truss:"api-read"`
+	DepositReceiptNo string `json:"deposit_receipt_no,omitempty" truss:"api-read"`
+	// PreviousCommissionDate is the LastCommissionDate of the account before
+	// the commission, restored when it is reversed.
+	PreviousCommissionDate int64 `json:"previous_commission_date,omitempty" truss:"api-read"`
+	ReversedAt             int64 `json:"reversed_at,omitempty" truss:"api-read"`
+	// PolicyID, PolicyVersion and Method record the commission policy that
+	// set the fee.
+	PolicyID      string `json:"policy_id,omitempty" truss:"api-read"`
+	PolicyVersion int64  `json:"policy_version,omitempty" truss:"api-read"`
+	Method        string `json:"method,omitempty" truss:"api-read"`
+}
+
+// newDSFee returns the fee transaction and the commission taking amount from
+// cycle under policy, and records the commission on the cycle. source is the
+// deposit that made the fee due, or the payout details of the cycle.
+func newDSFee(account *Account, cycle *DSCycle, policy CommissionPolicy, amount Money, receiptNo string,
+	source Transaction, currentDate time.Time) (Transaction, DSCommission) {
+
+	fee := Transaction{
+		ReceiptNo:     receiptNo,
+		AccountNumber: account.Number,
+		Amount:        amount,
+		Narration:     dsFeeNarration,
+		Kind:          TransactionKind_Fee,
+		Type:          TransactionType_Withdrawal,
+		SalesRepID:    source.SalesRepID,
+		SalesRep:      source.SalesRep,
+		CustomerID:    account.CustomerID,
+		CustomerName:  source.CustomerName,
+		CycleID:       cycle.ID,
+		EffectiveDate: source.EffectiveDate,
+		CreatedAt:     currentDate.Add(2 * time.Second).Unix(),
+		UpdatedAt:     currentDate.Unix(),
+	}
+	commission := DSCommission{
+		ID:                     uuid.NewRandom().String(),
+		AccountNumber:          account.Number,
+		CustomerID:             account.CustomerID,
+		CustomerName:           source.CustomerName,
+		Amount:                 amount,
+		Date:                   currentDate.Unix(),
+		EffectiveDate:          source.EffectiveDate,
+		ReceiptNo:              fee.ReceiptNo,
+		DepositReceiptNo:       source.ReceiptNo,
+		PreviousCommissionDate: account.LastCommissionDate,
+		PolicyID:               policy.ID,
+		PolicyVersion:          policy.Version,
+		Method:                 policy.Method,
+	}
+	fee.CommissionID = commission.ID
+
+	cycle.FeeTaken += amount
+	cycle.CommissionID = commission.ID
+	cycle.AmountPayable = cycle.AmountPaid - cycle.FeeTaken
+	return fee, commission
+}
+
+// postDSFee writes the fee transaction and the commission of newDSFee and
+// takes the fee from the account balance.
+func postDSFee(tx Tx, account *Account, fee Transaction, commission DSCommission, currentDate time.Time) {
+	account.Balance -= fee.Amount
+	fee.Balance = account.Balance
+	tx.CreateTransaction(fee)
+	tx.PostJournal(transactionJournal(fee, account.Type, currentDate))
+	recordDailySummary(tx, account.BranchID, currentDate, fee, 1)
+	// global balance
+	tx.IncrementTotal(statGlobalBalancePath(account.Type), -fee.Amount)
+
+	tx.CreateCommission(commission)
+	recordCommissionStats(tx, commission, 1)
 }
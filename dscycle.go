@@ -0,0 +1,395 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dsCycleDays is the number of daily contributions in a DS cycle.
+	dsCycleDays = 31
+	// dsPayoutNarration is the narration of the withdrawal paying out a cycle.
+	dsPayoutNarration = "DS cycle payout"
+)
+
+// DS cycle statuses.
+const (
+	// DSCycleStatusOpen is the cycle receiving the contributions of the account.
+	DSCycleStatusOpen = "open"
+	// DSCycleStatusCompleted is a cycle followed by a newer one and not yet paid out.
+	DSCycleStatusCompleted = "completed"
+	// DSCycleStatusClosed is a paid out cycle.
+	DSCycleStatusClosed = "closed"
+	// DSCycleStatusCancelled is a cycle whose contributions were all reversed.
+	DSCycleStatusCancelled = "cancelled"
+)
+
+// DSCycle is a run of daily contributions to a DS account. The DS fee of a
+// cycle is set by the commission policy recorded when it started.
+type DSCycle struct {
+	ID            string `json:"id" truss:"api-read"`
+	AccountNumber string `json:"account_number" truss:"api-read"`
+	CustomerID    string `json:"customer_id" truss:"api-read"`
+	CustomerName  string `json:"customer_name" truss:"api-read"`
+	StartDate     int64  `json:"start_date" truss:"api-read"`
+	// PaidThrough is the last day covered by a contribution.
+	PaidThrough   int64  `json:"paid_through" truss:"api-read"`
+	DailyRate     Money  `json:"daily_rate" truss:"api-read"`
+	DaysPaid      int64  `json:"days_paid" truss:"api-read"`
+	AmountPaid    Money  `json:"amount_paid" truss:"api-read"`
+	FeeTaken      Money  `json:"fee_taken" truss:"api-read"`
+	AmountPayable Money  `json:"amount_payable" truss:"api-read"`
+	PaidOut       Money  `json:"paid_out" truss:"api-read"`
+	CommissionID  string `json:"commission_id" truss:"api-read"`
+	// PolicyID is the commission policy in force when the cycle started.
+	PolicyID        string `json:"policy_id" truss:"api-read"`
+	PayoutReceiptNo string `json:"payout_receipt_no,omitempty" truss:"api-read"`
+	Status          string `json:"status" truss:"api-read"`
+	ClosedAt        int64  `json:"closed_at,omitempty" truss:"api-read"`
+	CreatedAt       int64  `json:"created_at" truss:"api-read"`
+	UpdatedAt       int64  `json:"updated_at" truss:"api-read"`
+}
+
+// contribute adds a contribution for the given day to the cycle.
+func (c *DSCycle) contribute(amount Money, day int64) {
+	c.DaysPaid++
+	c.AmountPaid += amount
+	if day > c.PaidThrough {
+		c.PaidThrough = day
+	}
+	c.AmountPayable = c.AmountPaid - c.FeeTaken
+}
+
+// reverse removes a contribution for the given day from the cycle.
+func (c *DSCycle) reverse(amount Money, day int64) {
+	c.DaysPaid--
+	c.AmountPaid -= amount
+	if day == c.PaidThrough {
+		c.PaidThrough = time.Unix(day, 0).Add(-24 * time.Hour).Unix()
+	}
+	c.AmountPayable = c.AmountPaid - c.FeeTaken
+	if c.DaysPaid <= 0 {
+		c.Status = DSCycleStatusCancelled
+	}
+}
+
+// nextContributionDate returns the day covered by the next contribution to a
+// DS account. Contributions follow each other within the open cycle while the
+// first contribution after a payout is for today. Accounts opened before
+// cycles were recorded carry on from their latest contribution.
+func nextContributionDate(account *Account, cycle *DSCycle, today time.Time) time.Time {
+	if cycle != nil {
+		return now.New(time.Unix(cycle.PaidThrough, 0)).Time.Add(24 * time.Hour)
+	}
+	if account.LastCommissionDate == 0 {
+		return today
+	}
+	for _, t := range account.RecentTransactions {
+		if t.Type == TransactionType_Deposit && t.EffectiveDate > 0 {
+			return now.New(time.Unix(t.EffectiveDate, 0)).Time.Add(24 * time.Hour)
+		}
+	}
+	return today
+}
+
+// ListDSCyclesRequest selects the account whose cycles are listed.
+type ListDSCyclesRequest struct {
+	AccountNumber string `json:"account_number" validate:"required"`
+}
+
+// ListDSCyclesHTTP is an HTTP Cloud Function that lists the DS cycles of an account.
+func ListDSCyclesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListDSCyclesHTTP)
+}
+
+func (h *Handler) ListDSCyclesHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ListDSCyclesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if _, err := getAccountByNumber(r.Context(), req.AccountNumber, h.store); err != nil {
+		log.Println(err)
+		sendError(w, "Invalid account number")
+		return
+	}
+
+	cycles, err := h.store.ListCycles(r.Context(), req.AccountNumber)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read DS cycles")
+		return
+	}
+	if cycles == nil {
+		cycles = []DSCycle{}
+	}
+	sendResponse(w, cycles)
+}
+
+// DSCycleRequest selects a DS cycle.
+type DSCycleRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// DSCycleDay lists the receipts covering a day of a cycle.
+type DSCycleDay struct {
+	Date     int64    `json:"date"`
+	Fee      bool     `json:"fee"`
+	Receipts []string `json:"receipts"`
+}
+
+// DSCycleStatement shows which days of a cycle are covered by which receipts.
+type DSCycleStatement struct {
+	Cycle DSCycle      `json:"cycle"`
+	Days  []DSCycleDay `json:"days"`
+}
+
+// DSCycleStatementHTTP is an HTTP Cloud Function that returns the statement of a DS cycle.
+func DSCycleStatementHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).DSCycleStatementHTTP)
+}
+
+func (h *Handler) DSCycleStatementHTTP(w http.ResponseWriter, r *http.Request) {
+	var req DSCycleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	cycle, err := h.store.GetCycle(r.Context(), req.ID)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read DS cycle, please check the ID")
+		return
+	}
+	transactions, err := h.store.ListTransactions(r.Context(), TransactionQuery{
+		AccountNumber: cycle.AccountNumber,
+		CycleID:       cycle.ID,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read the transactions of the cycle")
+		return
+	}
+
+	sendResponse(w, cycleStatement(*cycle, transactions))
+}
+
+// cycleStatement lays the contributions of a cycle out over its days.
+func cycleStatement(cycle DSCycle, transactions []Transaction) DSCycleStatement {
+	statement := DSCycleStatement{Cycle: cycle}
+	index := map[int64]int{}
+	start := time.Unix(cycle.StartDate, 0)
+	for i := 0; i < dsCycleDays; i++ {
+		day := start.Add(time.Duration(i) * 24 * time.Hour).Unix()
+		index[day] = i
+		statement.Days = append(statement.Days, DSCycleDay{Date: day, Receipts: []string{}})
+	}
+
+	// Transactions are listed newest first.
+	for i := len(transactions) - 1; i >= 0; i-- {
+		t := transactions[i]
+		if t.Type != TransactionType_Deposit || t.ReversedBy != "" || t.ReversalOf != "" {
+			continue
+		}
+		day := now.New(time.Unix(t.EffectiveDate, 0)).BeginningOfDay().Unix()
+		d, ok := index[day]
+		if !ok {
+			continue
+		}
+		statement.Days[d].Receipts = append(statement.Days[d].Receipts, t.ReceiptNo)
+		if t.CommissionID != "" && t.CommissionID == cycle.CommissionID {
+			statement.Days[d].Fee = true
+		}
+	}
+	return statement
+}
+
+// CloseDSCycleRequest defines the information needed to pay out a DS cycle.
+type CloseDSCycleRequest struct {
+	ID            string `json:"id" validate:"required"`
+	PaymentMethod string `json:"payment_method"`
+	SalesRepID    string `json:"sales_rep_id"`
+	SalesRep      string `json:"sales_rep"`
+	// IdempotencyKey may be sent instead of the Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// CloseDSCycleHTTP is an HTTP Cloud Function that closes a DS cycle and
+// withdraws its net amount. Requests carrying an idempotency key are only
+// applied once.
+func CloseDSCycleHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).CloseDSCycleHTTP)
+}
+
+func (h *Handler) CloseDSCycleHTTP(w http.ResponseWriter, r *http.Request) {
+	h.withIdempotency(w, r, "ds-payout", h.closeDSCycle)
+}
+
+func (h *Handler) closeDSCycle(w http.ResponseWriter, r *http.Request) {
+	var req CloseDSCycleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.PaymentMethod != PaymentMethod_Bank {
+		req.PaymentMethod = PaymentMethod_Cash
+	}
+
+	cycle, err := closeCycle(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		log.Println(err)
+		sendErrorf(w, "cannot close DS cycle, %s", err.Error())
+		return
+	}
+	sendResponse(w, cycle)
+}
+
+// closeCycle takes the fee the cycle owes on payout, marks the cycle as
+// closed and withdraws its net amount from the account. The next
+// contribution to the account starts a new cycle.
+func closeCycle(ctx context.Context, req CloseDSCycleRequest, currentDate time.Time, store Store) (*DSCycle, error) {
+	cycle, err := store.GetCycle(ctx, req.ID)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read DS cycle, please check the ID")
+	}
+	account, err := getAccountByNumber(ctx, cycle.AccountNumber, store)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot map account data")
+	}
+	receiptNo, err := generateReceiptNumber(ctx, store, account.BranchID)
+	if err != nil {
+		log.Println(err)
+		return nil, fmt.Errorf("error in generating receipt number, %s", err.Error())
+	}
+	// A cycle without a fee may owe one on payout.
+	var feeReceiptNo string
+	allocateFeeReceipt := func() error {
+		if feeReceiptNo, err = generateReceiptNumber(ctx, store, account.BranchID); err != nil {
+			log.Println(err)
+			return fmt.Errorf("error in generating receipt number, %s", err.Error())
+		}
+		return nil
+	}
+	if cycle.CommissionID == "" {
+		if err = allocateFeeReceipt(); err != nil {
+			return nil, err
+		}
+	}
+
+	today := now.New(currentDate).BeginningOfDay()
+	payout := func(ctx context.Context, tx Tx) error {
+		cycle, err = tx.GetCycle(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read DS cycle, please check the ID")
+		}
+		switch cycle.Status {
+		case DSCycleStatusClosed:
+			return errors.Errorf("the cycle has been paid out with %s", cycle.PayoutReceiptNo)
+		case DSCycleStatusCancelled:
+			return errors.New("the cycle has been cancelled")
+		}
+		account, err := tx.GetAccount(ctx, cycle.AccountNumber)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot map account data")
+		}
+		if err = checkDayOpen(ctx, tx, account.BranchID, currentDate); err != nil {
+			return err
+		}
+		policy, err := cyclePolicy(ctx, tx, cycle)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read the commission policy of the DS cycle")
+		}
+
+		if amount := policy.fee(*cycle, 0, true); amount > 0 {
+			if amount > account.Balance {
+				amount = account.Balance
+			}
+			if feeReceiptNo == "" {
+				return errFeeReceiptRequired
+			}
+			fee, commission := newDSFee(account, cycle, policy, amount, feeReceiptNo, Transaction{
+				SalesRepID:    req.SalesRepID,
+				SalesRep:      req.SalesRep,
+				CustomerName:  account.Customer,
+				EffectiveDate: today.Unix(),
+			}, currentDate)
+			postDSFee(tx, account, fee, commission, currentDate)
+		}
+
+		cycle.Status = DSCycleStatusClosed
+		cycle.ClosedAt = currentDate.Unix()
+		cycle.UpdatedAt = currentDate.Unix()
+		if account.CurrentCycleID == cycle.ID {
+			account.CurrentCycleID = ""
+			account.LastCommissionDate = 0
+		}
+
+		if cycle.AmountPayable > 0 {
+			if account.Balance < cycle.AmountPayable {
+				return errors.New("insufficient fund")
+			}
+			m := Transaction{
+				ReceiptNo:     receiptNo,
+				Type:          TransactionType_Withdrawal,
+				AccountNumber: account.Number,
+				CustomerID:    account.CustomerID,
+				CustomerName:  account.Customer,
+				Amount:        cycle.AmountPayable,
+				Narration:     dsPayoutNarration,
+				Kind:          TransactionKind_Payout,
+				PaymentMethod: req.PaymentMethod,
+				SalesRepID:    req.SalesRepID,
+				SalesRep:      req.SalesRep,
+				CycleID:       cycle.ID,
+				EffectiveDate: today.Unix(),
+				CreatedAt:     currentDate.Add(4 * time.Second).Unix(),
+				UpdatedAt:     currentDate.Unix(),
+			}
+			account.Balance -= m.Amount
+			m.Balance = account.Balance
+			tx.CreateTransaction(m)
+			tx.PostJournal(transactionJournal(m, account.Type, currentDate))
+			recordTransactionStats(tx, m, 1)
+			recordDailySummary(tx, account.BranchID, currentDate, m, 1)
+			if len(account.RecentTransactions) >= maxRecentTransactions {
+				account.RecentTransactions = account.RecentTransactions[:len(account.RecentTransactions)-1]
+			}
+			account.RecentTransactions = append([]Transaction{m}, account.RecentTransactions...)
+			cycle.PaidOut = m.Amount
+			cycle.PayoutReceiptNo = m.ReceiptNo
+			// global balance
+			tx.IncrementTotal(statGlobalBalancePath(account.Type), m.Amount*-1)
+		}
+
+		tx.UpdateCycle(*cycle)
+		tx.UpdateAccount(*account)
+		return nil
+	}
+	err = store.RunTransaction(ctx, payout)
+	if err == errFeeReceiptRequired {
+		if err = allocateFeeReceipt(); err != nil {
+			return nil, err
+		}
+		err = store.RunTransaction(ctx, payout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cycle, nil
+}
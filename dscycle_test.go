@@ -0,0 +1,168 @@
+package surebankltd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDSCycleContributeAndReverse(t *testing.T) {
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+	next := day.Add(24 * time.Hour)
+	cycle := DSCycle{Status: DSCycleStatusOpen, StartDate: day.Unix()}
+
+	cycle.contribute(500*Naira, day.Unix())
+	cycle.contribute(500*Naira, next.Unix())
+	if cycle.DaysPaid != 2 || cycle.AmountPaid != 1000*Naira || cycle.PaidThrough != next.Unix() {
+		t.Fatalf("got cycle %+v after two contributions", cycle)
+	}
+	cycle.FeeTaken = 500 * Naira
+	cycle.contribute(500*Naira, day.Unix())
+	if cycle.PaidThrough != next.Unix() || cycle.AmountPayable != 1000*Naira {
+		t.Fatalf("got cycle %+v after a contribution for an earlier day", cycle)
+	}
+
+	cycle.reverse(500*Naira, next.Unix())
+	if cycle.PaidThrough != day.Unix() || cycle.Status != DSCycleStatusOpen || cycle.AmountPayable != 500*Naira {
+		t.Fatalf("got cycle %+v after reversing the last day", cycle)
+	}
+	cycle.reverse(500*Naira, day.Unix())
+	cycle.reverse(500*Naira, day.Unix())
+	if cycle.DaysPaid != 0 || cycle.Status != DSCycleStatusCancelled {
+		t.Fatalf("got cycle %+v after reversing every contribution", cycle)
+	}
+}
+
+func TestDSCycleLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	batch := store.Batch()
+	batch.CreateCustomer(Customer{ID: "customer-DS00001", Name: "Test Customer", PhoneNumber: "08030000000"})
+	batch.CreateAccount(Account{Number: "DS00001", CustomerID: "customer-DS00001", Type: AccountTypeDS, Target: 500 * Naira})
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	currentDate := time.Date(2026, 3, 1, 9, 0, 0, 0, time.Local)
+	deposit := func() *Transaction {
+		t.Helper()
+		tx, err := create(ctx, Transaction{
+			AccountNumber: "DS00001",
+			Type:          TransactionType_Deposit,
+			Amount:        500 * Naira,
+			PaymentMethod: PaymentMethod_Cash,
+		}, currentDate, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	currentCycle := func() *DSCycle {
+		t.Helper()
+		account, err := store.GetAccount(ctx, "DS00001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.CurrentCycleID == "" {
+			return nil
+		}
+		cycle, err := store.GetCycle(ctx, account.CurrentCycleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cycle
+	}
+
+	first := deposit()
+	cycle := currentCycle()
+	if cycle == nil || cycle.Status != DSCycleStatusOpen || first.CycleID != cycle.ID || first.FeeReceiptNo == "" {
+		t.Fatalf("first contribution %+v opened cycle %+v", first, cycle)
+	}
+	if cycle.FeeTaken != 500*Naira || cycle.AmountPayable != 0 {
+		t.Fatalf("got fee %s payable %s, want the first day as fee", cycle.FeeTaken, cycle.AmountPayable)
+	}
+	for i := 1; i < dsCycleDays; i++ {
+		deposit()
+	}
+	full := currentCycle()
+	if full.ID != cycle.ID || full.DaysPaid != dsCycleDays || full.AmountPayable != (dsCycleDays-1)*500*Naira {
+		t.Fatalf("got cycle %+v after %d contributions", full, dsCycleDays)
+	}
+
+	// The next contribution covers the day after the cycle and starts a new
+	// one, leaving the full cycle completed until it is paid out.
+	deposit()
+	second := currentCycle()
+	if second.ID == full.ID || second.Status != DSCycleStatusOpen || second.DaysPaid != 1 {
+		t.Fatalf("got cycle %+v after the cycle was full", second)
+	}
+	completed, err := store.GetCycle(ctx, full.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.Status != DSCycleStatusCompleted {
+		t.Fatalf("full cycle status = %s, want %s", completed.Status, DSCycleStatusCompleted)
+	}
+
+	closed, err := closeCycle(ctx, CloseDSCycleRequest{ID: full.ID, PaymentMethod: PaymentMethod_Cash}, currentDate, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Status != DSCycleStatusClosed || closed.PaidOut != (dsCycleDays-1)*500*Naira || closed.PayoutReceiptNo == "" {
+		t.Fatalf("got closed cycle %+v", closed)
+	}
+	payout, err := store.GetTransaction(ctx, closed.PayoutReceiptNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payout.Kind != TransactionKind_Payout || payout.Type != TransactionType_Withdrawal {
+		t.Errorf("got payout %+v", payout)
+	}
+	if _, err = closeCycle(ctx, CloseDSCycleRequest{ID: full.ID}, currentDate, store); err == nil {
+		t.Error("a closed cycle was paid out twice")
+	}
+	if c := currentCycle(); c == nil || c.ID != second.ID {
+		t.Errorf("paying out a completed cycle changed the current cycle to %+v", c)
+	}
+
+	// Reversing the only contribution of the open cycle cancels it.
+	txs, err := store.ListTransactions(ctx, TransactionQuery{CycleID: second.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range txs {
+		if tx.Type == TransactionType_Deposit && tx.Kind == "" {
+			if _, err = reverseTransaction(ctx, ReverseTransactionRequest{
+				ID:         tx.ReceiptNo,
+				Reason:     "test",
+				ApprovedBy: "test",
+			}, currentDate, store); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cancelled, err := store.GetCycle(ctx, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cancelled.Status != DSCycleStatusCancelled {
+		t.Errorf("cycle status = %s after reversing its contributions, want %s", cancelled.Status, DSCycleStatusCancelled)
+	}
+
+	// Fees, payouts and reversals move the global balance like the account.
+	account, err := store.GetAccount(ctx, "DS00001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, err := store.Total(ctx, statGlobalBalancePath(AccountTypeDS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := store.ListLedgerAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global != account.Balance || customerBalances(ledger)[AccountTypeDS] != account.Balance {
+		t.Errorf("global balance %s and ledger balance %s, want the account balance %s",
+			global, customerBalances(ledger)[AccountTypeDS], account.Balance)
+	}
+}
@@ -0,0 +1,229 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// Expense is money paid out of the cash of a branch on a day. Expenses add
+// to the Expenditure of the daily summary of their day. An archived expense
+// is kept with who archived it and why, and no longer counts.
+type Expense struct {
+	ID               string `json:"id" truss:"api-read"`
+	BranchID         string `json:"branch_id"`
+	Category         string `json:"category" validate:"required"`
+	Amount           Money  `json:"amount" validate:"required,gt=0"`
+	Payee            string `json:"payee" validate:"required"`
+	ReceiptReference string `json:"receipt_reference"`
+	Description      string `json:"description"`
+	ApprovedBy       string `json:"approved_by" validate:"required"`
+	RecordedBy       string `json:"recorded_by"`
+	Date             int64  `json:"date"`
+	CreatedAt        int64  `json:"created_at" truss:"api-read"`
+	ArchivedAt       int64  `json:"archived_at,omitempty" truss:"api-read"`
+	ArchivedBy       string `json:"archived_by,omitempty" truss:"api-read"`
+	ArchiveReason    string `json:"archive_reason,omitempty" truss:"api-read"`
+}
+
+// RecordExpenseHTTP is an HTTP Cloud Function that records an expense of a
+// branch. Date is the day the expense was paid and defaults to today.
+func RecordExpenseHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).RecordExpenseHTTP)
+}
+
+func (h *Handler) RecordExpenseHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Expense
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	expense, err := recordExpense(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot record expense, %s", err.Error())
+		return
+	}
+	sendResponse(w, expense)
+}
+
+// recordExpense saves an expense and adds it to the summary of its day.
+func recordExpense(ctx context.Context, req Expense, currentDate time.Time, store Store) (*Expense, error) {
+	req.Category = strings.ToLower(strings.TrimSpace(req.Category))
+	switch {
+	case req.Category == "":
+		return nil, errors.New("category is required")
+	case !validExpenseCategory(req.Category):
+		return nil, errors.New("category must only have letters, digits, - and _")
+	case req.Amount <= 0:
+		return nil, errors.New("amount must be greater than zero")
+	case req.Payee == "":
+		return nil, errors.New("payee is required")
+	case req.ApprovedBy == "":
+		return nil, errors.New("approved by is required")
+	}
+
+	today := now.New(currentDate).BeginningOfDay()
+	day := today
+	if req.Date > 0 {
+		day = now.New(time.Unix(req.Date, 0)).BeginningOfDay()
+	}
+	if day.After(today) {
+		return nil, errors.New("an expense cannot be recorded before it is paid")
+	}
+
+	expense := req
+	expense.ID = uuid.NewRandom().String()
+	expense.Date = day.Unix()
+	expense.CreatedAt = currentDate.Unix()
+	expense.ArchivedAt, expense.ArchivedBy, expense.ArchiveReason = 0, "", ""
+
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		if err := checkDayOpen(ctx, tx, expense.BranchID, day); err != nil {
+			return err
+		}
+		tx.CreateExpense(expense)
+		tx.PostJournal(expenseJournal(expense, currentDate))
+		tx.IncrementDailySummary(expense.BranchID, expense.Date, DailySummary{Expenditure: expense.Amount})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &expense, nil
+}
+
+// validExpenseCategory reports whether category is made of a-z, 0-9, - and
+// _ only. Categories are part of the paths of the expense ledger counters.
+func validExpenseCategory(category string) bool {
+	if category == "" || len(category) > 40 {
+		return false
+	}
+	for _, r := range category {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// ArchiveExpenseRequest defines the expense to archive and why.
+type ArchiveExpenseRequest struct {
+	ID         string `json:"id" validate:"required"`
+	ArchivedBy string `json:"archived_by" validate:"required"`
+	Reason     string `json:"reason" validate:"required"`
+}
+
+// ArchiveExpenseHTTP is an HTTP Cloud Function that archives an expense
+// recorded in error. The expense is taken out of the summary of its day,
+// which must not be closed.
+func ArchiveExpenseHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ArchiveExpenseHTTP)
+}
+
+func (h *Handler) ArchiveExpenseHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ArchiveExpenseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	expense, err := archiveExpense(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot archive expense, %s", err.Error())
+		return
+	}
+	sendResponse(w, expense)
+}
+
+func archiveExpense(ctx context.Context, req ArchiveExpenseRequest, currentDate time.Time, store Store) (*Expense, error) {
+	if req.ArchivedBy == "" || req.Reason == "" {
+		return nil, errors.New("the archiving user and reason are required")
+	}
+
+	var expense *Expense
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		expense, err = tx.GetExpense(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read expense, please check the ID")
+		}
+		if expense.ArchivedAt > 0 {
+			return errors.Errorf("the expense was archived by %s", expense.ArchivedBy)
+		}
+		if err = checkDayOpen(ctx, tx, expense.BranchID, time.Unix(expense.Date, 0)); err != nil {
+			return err
+		}
+
+		expense.ArchivedAt = currentDate.Unix()
+		expense.ArchivedBy = req.ArchivedBy
+		expense.ArchiveReason = req.Reason
+		tx.ArchiveExpense(*expense)
+		// The reversal is dated on the day of the expense, like the change to
+		// its summary.
+		reversal := newJournal("", "Archived expense: "+req.Reason, currentDate)
+		reversal.Date = expense.Date
+		reversal.post(branchCashLedger(expense.BranchID), expenseLedger(expense.Category), expense.Amount)
+		tx.PostJournal(reversal)
+		tx.IncrementDailySummary(expense.BranchID, expense.Date, DailySummary{Expenditure: -1 * expense.Amount})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expense, nil
+}
+
+// ListExpensesRequest defines the options to filter and page expenses.
+type ListExpensesRequest struct {
+	BranchID        string `json:"branch_id"`
+	Category        string `json:"category"`
+	From            int64  `json:"from"`
+	To              int64  `json:"to"`
+	IncludeArchived bool   `json:"include_archived"`
+	Limit           int    `json:"limit" example:"10"`
+	Offset          int    `json:"offset" example:"20"`
+}
+
+// ListExpensesHTTP is an HTTP Cloud Function that lists expenses, newest first.
+func ListExpensesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListExpensesHTTP)
+}
+
+func (h *Handler) ListExpensesHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ListExpensesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	expenses, err := h.store.ListExpenses(r.Context(), ExpenseQuery{
+		BranchID:        req.BranchID,
+		Category:        strings.ToLower(strings.TrimSpace(req.Category)),
+		From:            req.From,
+		To:              req.To,
+		IncludeArchived: req.IncludeArchived,
+		Limit:           req.Limit,
+		Offset:          req.Offset,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read expenses")
+		return
+	}
+	if expenses == nil {
+		expenses = []Expense{}
+	}
+	sendResponse(w, expenses)
+}
@@ -0,0 +1,180 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidExpenseCategory(t *testing.T) {
+	for category, want := range map[string]bool{
+		"fuel":                  true,
+		"office_supplies":       true,
+		"generator-repair-2":    true,
+		"":                      false,
+		"office supplies":       false,
+		"fuel/../ledger":        false,
+		"fuel:diesel":           false,
+		"transport.":            false,
+		"ọkọ":                   false,
+		strings.Repeat("a", 41): false,
+	} {
+		if got := validExpenseCategory(category); got != want {
+			t.Errorf("validExpenseCategory(%q) = %v, want %v", category, got, want)
+		}
+	}
+}
+
+func TestRecordExpense(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	expense := Expense{
+		BranchID:   "lagos",
+		Category:   " Fuel ",
+		Amount:     50 * Naira,
+		Payee:      "Filling station",
+		ApprovedBy: "manager",
+	}
+
+	invalid := []func(e *Expense){
+		func(e *Expense) { e.Category = "" },
+		func(e *Expense) { e.Category = "fuel/diesel" },
+		func(e *Expense) { e.Amount = 0 },
+		func(e *Expense) { e.Payee = "" },
+		func(e *Expense) { e.ApprovedBy = "" },
+		func(e *Expense) { e.Date = day.AddDate(0, 0, 1).Unix() },
+	}
+	for i, change := range invalid {
+		e := expense
+		change(&e)
+		if _, err := recordExpense(ctx, e, day, store); err == nil {
+			t.Errorf("invalid expense %d was recorded: %+v", i, e)
+		}
+	}
+
+	recorded, err := recordExpense(ctx, expense, day, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local).Unix()
+	if recorded.Category != "fuel" || recorded.Date != today || recorded.ID == "" {
+		t.Errorf("got expense %+v", recorded)
+	}
+	summary, err := store.GetDailySummary(ctx, "lagos", today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Expenditure != 50*Naira {
+		t.Errorf("expenditure = %s, want %s", summary.Expenditure, 50*Naira)
+	}
+}
+
+func TestListAndArchiveExpenses(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	record := func(branch, category string, amount Money, date time.Time) *Expense {
+		t.Helper()
+		e, err := recordExpense(ctx, Expense{
+			BranchID:   branch,
+			Category:   category,
+			Amount:     amount,
+			Payee:      "Payee",
+			ApprovedBy: "manager",
+			Date:       date.Unix(),
+		}, day, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	fuel := record("lagos", "fuel", 50*Naira, day)
+	record("lagos", "stationery", 20*Naira, day)
+	record("lagos", "fuel", 30*Naira, day.AddDate(0, 0, -1))
+	record("abuja", "fuel", 40*Naira, day)
+
+	h := NewHandler(store)
+	list := func(body string) []Expense {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ListExpensesHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		var resp struct {
+			Success bool
+			Data    []Expense
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success {
+			t.Fatalf("got response %s, %v", w.Body, err)
+		}
+		return resp.Data
+	}
+	total := func(expenses []Expense) Money {
+		var sum Money
+		for _, e := range expenses {
+			sum += e.Amount
+		}
+		return sum
+	}
+
+	dayStart := time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local).Unix()
+	tests := []struct {
+		body string
+		want Money
+	}{
+		{`{}`, 140 * Naira},
+		{`{"branch_id":"lagos"}`, 100 * Naira},
+		{`{"branch_id":"lagos","category":" FUEL "}`, 80 * Naira},
+		{fmt.Sprintf(`{"branch_id":"lagos","from":%d}`, dayStart), 70 * Naira},
+		{fmt.Sprintf(`{"to":%d}`, dayStart-1), 30 * Naira},
+	}
+	for _, tt := range tests {
+		if got := total(list(tt.body)); got != tt.want {
+			t.Errorf("expenses of %s add up to %s, want %s", tt.body, got, tt.want)
+		}
+	}
+
+	if _, err := archiveExpense(ctx, ArchiveExpenseRequest{ID: fuel.ID, ArchivedBy: "auditor"}, day, store); err == nil {
+		t.Error("an expense was archived without a reason")
+	}
+	archived, err := archiveExpense(ctx, ArchiveExpenseRequest{
+		ID:         fuel.ID,
+		ArchivedBy: "auditor",
+		Reason:     "recorded twice",
+	}, day.Add(time.Hour), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archived.ArchivedBy != "auditor" || archived.ArchiveReason != "recorded twice" || archived.ArchivedAt != day.Add(time.Hour).Unix() {
+		t.Errorf("got archived expense %+v", archived)
+	}
+	if _, err = archiveExpense(ctx, ArchiveExpenseRequest{ID: fuel.ID, ArchivedBy: "auditor", Reason: "again"}, day, store); err == nil {
+		t.Error("an expense was archived twice")
+	}
+	if got := total(list(`{"branch_id":"lagos"}`)); got != 50*Naira {
+		t.Errorf("expenses add up to %s after archiving, want %s", got, 50*Naira)
+	}
+	if got := total(list(`{"branch_id":"lagos","include_archived":true}`)); got != 100*Naira {
+		t.Errorf("expenses with the archived ones add up to %s, want %s", got, 100*Naira)
+	}
+
+	summary, err := store.GetDailySummary(ctx, "lagos", dayStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Expenditure != 20*Naira {
+		t.Errorf("expenditure = %s after archiving, want %s", summary.Expenditure, 20*Naira)
+	}
+	accounts, err := store.ListLedgerAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range accounts {
+		if a.Code == expenseLedger("fuel") && a.Balance() != 70*Naira {
+			t.Errorf("fuel ledger balance = %s, want %s", a.Balance(), 70*Naira)
+		}
+	}
+}
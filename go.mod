@@ -1,6 +1,6 @@
 module github.com/ademuanthony/surebankltd
 
-go 1.13
+go 1.16
 
 require (
 	cloud.google.com/go/firestore v1.3.0
@@ -14,6 +14,8 @@ require (
 	github.com/spf13/cast v1.3.1 // indirect
 	github.com/volatiletech/inflect v0.0.1 // indirect
 	github.com/volatiletech/sqlboiler v3.7.1+incompatible
+	golang.org/x/text v0.3.3
 	google.golang.org/api v0.29.0
+	google.golang.org/grpc v1.30.0
 	gopkg.in/DataDog/dd-trace-go.v1 v1.26.0
 )
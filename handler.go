@@ -0,0 +1,64 @@
+package surebankltd
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// Handler implements the HTTP functions on top of a Store.
+type Handler struct {
+	store          Store
+	idempotencyTTL time.Duration
+}
+
+// NewHandler returns a Handler that reads and writes through store.
+func NewHandler(store Store) *Handler {
+	return &Handler{
+		store:          store,
+		idempotencyTTL: idempotencyKeyTTL(),
+	}
+}
+
+var (
+	defaultHandlerMu sync.Mutex
+	defaultHandler   *Handler
+)
+
+// getDefaultHandler returns the Handler shared by the Cloud Function entry
+// points. It uses Firestore unless SUREBANK_STORE is set to "memory".
+func getDefaultHandler(ctx context.Context) (*Handler, error) {
+	defaultHandlerMu.Lock()
+	defer defaultHandlerMu.Unlock()
+	if defaultHandler != nil {
+		return defaultHandler, nil
+	}
+
+	if os.Getenv("SUREBANK_STORE") == "memory" {
+		defaultHandler = NewHandler(NewMemoryStore())
+		return defaultHandler, nil
+	}
+
+	client, err := firestore.NewClient(ctx, "surebank")
+	if err != nil {
+		return nil, err
+	}
+	defaultHandler = NewHandler(NewFirestoreStore(client))
+	return defaultHandler, nil
+}
+
+// serve runs fn with the default Handler.
+func serve(w http.ResponseWriter, r *http.Request, fn func(*Handler, http.ResponseWriter, *http.Request)) {
+	h, err := getDefaultHandler(context.Background())
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot establish database connection")
+		return
+	}
+	fn(h, w, r)
+}
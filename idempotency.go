@@ -0,0 +1,236 @@
+package surebankltd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header carrying the idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// defaultIdempotencyTTL is how long a key is remembered when
+	// IDEMPOTENCY_KEY_TTL is not set.
+	defaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a pending key is reported as in
+	// progress. A key still pending after it belongs to a request that
+	// stopped or could not save its response, which may have moved money.
+	idempotencyLockTimeout = 2 * time.Minute
+)
+
+// Idempotency record states.
+const (
+	IdempotencyStatusPending   = "pending"
+	IdempotencyStatusCompleted = "completed"
+	IdempotencyStatusFailed    = "failed"
+)
+
+// IdempotencyRecord remembers the outcome of a request made with an
+// idempotency key so that repeats can be answered without running it again.
+type IdempotencyRecord struct {
+	ID          string `json:"id"`
+	Scope       string `json:"scope"`
+	Key         string `json:"key"`
+	RequestHash string `json:"request_hash"`
+	Status      string `json:"status"`
+	Response    []byte `json:"response"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+// idempotencyKeyTTL returns the configured key expiry window.
+func idempotencyKeyTTL() time.Duration {
+	if v := os.Getenv("IDEMPOTENCY_KEY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("invalid IDEMPOTENCY_KEY_TTL %q, using %s", v, defaultIdempotencyTTL)
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotencyRecordID returns the document ID of a key. Keys are chosen by
+// clients so they are hashed to get a valid and bounded ID.
+func idempotencyRecordID(scope, key string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	errIdempotencyConflict   = errors.New("the idempotency key has already been used with a different request")
+	errIdempotencyInProgress = errors.New("a request with this idempotency key is still being processed")
+	errIdempotencyUnknown    = errors.New("the outcome of the request with this idempotency key is unknown, " +
+		"please check the account before retrying with a new key")
+)
+
+// withIdempotency runs next at most once per idempotency key within the
+// expiry window. The key is read from the Idempotency-Key header or the
+// idempotency_key field of the JSON body. A repeat with the same body gets
+// the stored response of the first request, failed or not, while a repeat
+// with a different body is rejected. A failed request may have posted part
+// of its transactions, so it is retried with a new key.
+func (h *Handler) withIdempotency(w http.ResponseWriter, r *http.Request, scope string, next http.HandlerFunc) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read client request")
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		var req struct {
+			IdempotencyKey string `json:"idempotency_key"`
+		}
+		// Decoding errors are reported by next.
+		_ = json.Unmarshal(body, &req)
+		key = req.IdempotencyKey
+	}
+	if key == "" {
+		next(w, r)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	currentDate := timeNow()
+	rec := IdempotencyRecord{
+		ID:          idempotencyRecordID(scope, key),
+		Scope:       scope,
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash[:]),
+		Status:      IdempotencyStatusPending,
+		CreatedAt:   currentDate.Unix(),
+		ExpiresAt:   currentDate.Add(h.idempotencyTTL).Unix(),
+	}
+
+	var replay []byte
+	err = h.store.RunTransaction(r.Context(), func(ctx context.Context, tx Tx) error {
+		replay = nil
+		existing, err := tx.GetIdempotencyRecord(ctx, rec.ID)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if existing != nil && existing.ExpiresAt > currentDate.Unix() {
+			if existing.RequestHash != rec.RequestHash {
+				return errIdempotencyConflict
+			}
+			if existing.Status != IdempotencyStatusPending {
+				replay = existing.Response
+				return nil
+			}
+			if currentDate.Unix()-existing.CreatedAt < int64(idempotencyLockTimeout/time.Second) {
+				return errIdempotencyInProgress
+			}
+			return errIdempotencyUnknown
+		}
+		tx.SetIdempotencyRecord(rec)
+		return nil
+	})
+	if err != nil {
+		sendError(w, err.Error())
+		return
+	}
+	if replay != nil {
+		w.Header().Set("Content-Type", MIMEApplicationJSONCharsetUTF8)
+		w.Header().Set("Idempotent-Replayed", "true")
+		if _, err := w.Write(replay); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	rw := &responseRecorder{header: http.Header{}}
+	next(rw, r)
+
+	// A key whose response cannot be saved stays pending, so that repeats
+	// are refused rather than run again.
+	var result response
+	rec.Status = IdempotencyStatusFailed
+	if err := json.Unmarshal(rw.body.Bytes(), &result); err == nil && result.Success {
+		rec.Status = IdempotencyStatusCompleted
+	}
+	rec.Response = rw.body.Bytes()
+	batch := h.store.Batch()
+	batch.SetIdempotencyRecord(rec)
+	if err := batch.Commit(r.Context()); err != nil {
+		log.Println(errors.WithMessagef(err, "cannot save idempotency key %s", key))
+	}
+
+	for k, v := range rw.header {
+		w.Header()[k] = v
+	}
+	if rw.status != 0 {
+		w.WriteHeader(rw.status)
+	}
+	if _, err := w.Write(rw.body.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
+
+// responseRecorder captures a response so that it can be stored before it
+// is sent.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *responseRecorder) Header() http.Header {
+	return rw.header
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	return rw.body.Write(b)
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	rw.status = status
+}
+
+// PurgeIdempotencyKeysHTTP is an HTTP Cloud Function that deletes expired
+// idempotency keys. It is meant to be called by a scheduler.
+func PurgeIdempotencyKeysHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).PurgeIdempotencyKeysHTTP)
+}
+
+func (h *Handler) PurgeIdempotencyKeysHTTP(w http.ResponseWriter, r *http.Request) {
+	const pageSize = 400
+	var purged int
+	for {
+		records, err := h.store.ListExpiredIdempotencyRecords(r.Context(), timeNow().Unix(), pageSize)
+		if err != nil {
+			log.Println(err)
+			sendError(w, "cannot read idempotency keys")
+			return
+		}
+		if len(records) == 0 {
+			break
+		}
+		batch := h.store.Batch()
+		for _, rec := range records {
+			batch.DeleteIdempotencyRecord(rec.ID)
+		}
+		if err := batch.Commit(r.Context()); err != nil {
+			log.Println(err)
+			sendError(w, "cannot delete idempotency keys")
+			return
+		}
+		purged += len(records)
+		if len(records) < pageSize {
+			break
+		}
+	}
+	sendResponse(w, purged)
+}
@@ -0,0 +1,182 @@
+package surebankltd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotentCall sends body with key to the deposit endpoint of h.
+func idempotentCall(h *Handler, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set(IdempotencyKeyHeader, key)
+	h.Deposit(w, r)
+	return w
+}
+
+func getIdempotencyRecord(t *testing.T, store Store, scope, key string) *IdempotencyRecord {
+	t.Helper()
+	var rec *IdempotencyRecord
+	err := store.RunTransaction(context.Background(), func(ctx context.Context, tx Tx) error {
+		var err error
+		rec, err = tx.GetIdempotencyRecord(ctx, idempotencyRecordID(scope, key))
+		if err == ErrNotFound {
+			rec, err = nil, nil
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestIdempotentDepositIsReplayed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00040", AccountTypeSB, 0)
+	h := NewHandler(store)
+	body := `{"account_number":"SB00040","tx_type":"deposit","amount":"250","payment_method":"cash"}`
+
+	first := idempotentCall(h, "key-1", body)
+	if !strings.Contains(first.Body.String(), `"success":true`) {
+		t.Fatalf("got response %s", first.Body)
+	}
+	second := idempotentCall(h, "key-1", body)
+	if second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("repeat got %s, want the replay of %s", second.Body, first.Body)
+	}
+	account, err := store.GetAccount(ctx, "SB00040")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 250*Naira {
+		t.Errorf("balance = %s after a repeated deposit, want %s", account.Balance, 250*Naira)
+	}
+
+	conflict := idempotentCall(h, "key-1", strings.Replace(body, "250", "300", 1))
+	if !strings.Contains(conflict.Body.String(), errIdempotencyConflict.Error()) {
+		t.Errorf("a key reused with another body got %s", conflict.Body)
+	}
+
+	// The key may also come in the body.
+	inBody := strings.Replace(body, "{", `{"idempotency_key":"key-2",`, 1)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		h.Deposit(w, httptest.NewRequest("POST", "/", strings.NewReader(inBody)))
+	}
+	if account, err = store.GetAccount(ctx, "SB00040"); err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 500*Naira {
+		t.Errorf("balance = %s after a deposit repeated with the key in the body, want %s", account.Balance, 500*Naira)
+	}
+}
+
+func TestIdempotentFailureIsKept(t *testing.T) {
+	store := NewMemoryStore()
+	h := NewHandler(store)
+	var calls int
+	fail := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		sendError(w, "cannot post every day")
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		r.Header.Set(IdempotencyKeyHeader, "key-1")
+		h.withIdempotency(w, r, "deposit", fail)
+		if !strings.Contains(w.Body.String(), "cannot post every day") {
+			t.Errorf("call %d got %s", i, w.Body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("a failed request ran %d times, want once", calls)
+	}
+	if rec := getIdempotencyRecord(t, store, "deposit", "key-1"); rec == nil || rec.Status != IdempotencyStatusFailed {
+		t.Errorf("got record %+v, want a failed one", rec)
+	}
+}
+
+func TestIdempotencyPendingAndExpiredKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00042", AccountTypeSB, 0)
+	h := NewHandler(store)
+	body := `{"account_number":"SB00042","tx_type":"deposit","amount":"100","payment_method":"cash"}`
+	currentDate := timeNow()
+
+	set := func(key, status string, createdAt, expiresAt time.Time) {
+		t.Helper()
+		hash := sha256.Sum256([]byte(body))
+		batch := store.Batch()
+		batch.SetIdempotencyRecord(IdempotencyRecord{
+			ID:          idempotencyRecordID("deposit", key),
+			Scope:       "deposit",
+			Key:         key,
+			RequestHash: hex.EncodeToString(hash[:]),
+			Status:      status,
+			CreatedAt:   createdAt.Unix(),
+			ExpiresAt:   expiresAt.Unix(),
+		})
+		if err := batch.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	balance := func() Money {
+		t.Helper()
+		account, err := store.GetAccount(ctx, "SB00042")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return account.Balance
+	}
+
+	set("running", IdempotencyStatusPending, currentDate, currentDate.Add(time.Hour))
+	set("stopped", IdempotencyStatusPending, currentDate.Add(-time.Hour), currentDate.Add(time.Hour))
+	set("expired", IdempotencyStatusCompleted, currentDate.Add(-48*time.Hour), currentDate.Add(-time.Hour))
+	before := balance()
+
+	if w := idempotentCall(h, "running", body); !strings.Contains(w.Body.String(), errIdempotencyInProgress.Error()) {
+		t.Errorf("a key in progress got %s", w.Body)
+	}
+	if w := idempotentCall(h, "stopped", body); !strings.Contains(w.Body.String(), "outcome") {
+		t.Errorf("an abandoned key got %s", w.Body)
+	}
+	if balance() != before {
+		t.Fatalf("a pending key posted the request again")
+	}
+	if w := idempotentCall(h, "expired", body); !strings.Contains(w.Body.String(), `"success":true`) {
+		t.Errorf("an expired key got %s", w.Body)
+	}
+	if balance() != before+100*Naira {
+		t.Errorf("balance = %s, want the request of the expired key posted", balance())
+	}
+
+	set("old", IdempotencyStatusCompleted, currentDate.Add(-72*time.Hour), currentDate.Add(-48*time.Hour))
+	w := httptest.NewRecorder()
+	h.PurgeIdempotencyKeysHTTP(w, httptest.NewRequest("POST", "/", nil))
+	var resp struct {
+		Success bool
+		Data    int
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success || resp.Data != 1 {
+		t.Fatalf("got purge response %+v, %v", resp, err)
+	}
+	if getIdempotencyRecord(t, store, "deposit", "old") != nil {
+		t.Error("an expired key was not purged")
+	}
+	for _, key := range []string{"running", "stopped", "expired"} {
+		if getIdempotencyRecord(t, store, "deposit", key) == nil {
+			t.Errorf("key %s was purged before it expired", key)
+		}
+	}
+}
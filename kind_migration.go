@@ -0,0 +1,71 @@
+package surebankltd
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+// kindMigrator is implemented by stores that may hold DS fees posted before
+// transactions had a kind.
+type kindMigrator interface {
+	MigrateTransactionKinds(ctx context.Context) (int, error)
+}
+
+// MigrateTransactionKinds sets the kind of the DS fees posted before
+// transactions had one and returns the number of fees updated. Those fees can
+// only be told apart by their narration.
+func (s *firestoreStore) MigrateTransactionKinds(ctx context.Context) (int, error) {
+	iter := s.client.Collection("transaction").
+		Where("Narration", "==", dsFeeNarration).
+		Where("Type", "==", TransactionType_Withdrawal).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var migrated int
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return migrated, err
+		}
+		if kind, _ := doc.Data()["Kind"].(string); kind != "" {
+			continue
+		}
+		_, err = doc.Ref.Update(ctx, []firestore.Update{{Path: "Kind", Value: TransactionKind_Fee}},
+			firestore.LastUpdateTime(doc.UpdateTime))
+		if err != nil {
+			return migrated, errors.WithMessagef(err, "cannot set the kind of %s", doc.Ref.ID)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// MigrateTransactionKindsHTTP is an HTTP Cloud Function that marks the DS fees
+// posted before transactions had a kind. Run it once after deploying, before
+// reports and reversals rely on the kind. It is safe to run more than once.
+func MigrateTransactionKindsHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).MigrateTransactionKindsHTTP)
+}
+
+func (h *Handler) MigrateTransactionKindsHTTP(w http.ResponseWriter, r *http.Request) {
+	migrator, ok := h.store.(kindMigrator)
+	if !ok {
+		sendResponse(w, 0)
+		return
+	}
+	migrated, err := migrator.MigrateTransactionKinds(r.Context())
+	if err != nil {
+		log.Println(err)
+		sendErrorf(w, "cannot migrate transaction kinds, %s", err.Error())
+		return
+	}
+	sendResponse(w, migrated)
+}
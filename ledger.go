@@ -0,0 +1,473 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// Ledger account categories. Customer ledgers hold what the company owes its
+// customers, cash ledgers hold the cash in hand of each sales rep and branch,
+// expense ledgers the branch spending per category and equity absorbs the
+// balances that existed before the ledger was introduced.
+const (
+	LedgerCategoryCustomer = "customer"
+	LedgerCategoryCash     = "cash"
+	LedgerCategoryBank     = "bank"
+	LedgerCategoryIncome   = "income"
+	LedgerCategoryExpense  = "expense"
+	LedgerCategoryEquity   = "equity"
+)
+
+const (
+	// bankLedger is the ledger of money held at the bank.
+	bankLedger = LedgerCategoryBank
+	// commissionIncomeLedger is the ledger of DS fees earned.
+	commissionIncomeLedger = LedgerCategoryIncome + ":commission"
+	// openingBalanceLedger is the counterpart of the opening balances of
+	// accounts created before the ledger.
+	openingBalanceLedger = LedgerCategoryEquity + ":opening-balance"
+
+	dsFeeNarration = "DS fee deduction"
+)
+
+// customerLedger returns the ledger code of a customer account.
+func customerLedger(accountType, accountNumber string) string {
+	return LedgerCategoryCustomer + ":" + accountType + ":" + accountNumber
+}
+
+// cashLedger returns the ledger code of the cash in hand of a sales rep.
+func cashLedger(salesRepID string) string {
+	if salesRepID == "" {
+		salesRepID = "unassigned"
+	}
+	return LedgerCategoryCash + ":" + salesRepID
+}
+
+// branchCashLedger returns the ledger code of the cash held by a branch. It
+// receives the remittances of the sales reps and pays expenses and lodgements.
+func branchCashLedger(branchID string) string {
+	return LedgerCategoryCash + ":branch:" + branchKey(branchID)
+}
+
+// expenseLedger returns the ledger code of an expense category.
+func expenseLedger(category string) string {
+	return LedgerCategoryExpense + ":" + category
+}
+
+// paymentLedger returns the ledger that receives or pays out money for the
+// given payment method.
+func paymentLedger(paymentMethod, salesRepID string) string {
+	if paymentMethod == PaymentMethod_Bank {
+		return bankLedger
+	}
+	return cashLedger(salesRepID)
+}
+
+// ledgerCategory returns the category part of a ledger code.
+func ledgerCategory(code string) string {
+	return strings.SplitN(code, ":", 2)[0]
+}
+
+// ledgerAccountType returns the customer account type of a customer ledger
+// code and an empty string for every other ledger.
+func ledgerAccountType(code string) string {
+	parts := strings.SplitN(code, ":", 3)
+	if len(parts) != 3 || parts[0] != LedgerCategoryCustomer {
+		return ""
+	}
+	return parts[1]
+}
+
+// LedgerAccount is an account of the general ledger with its running totals.
+type LedgerAccount struct {
+	Code        string `json:"code"`
+	Category    string `json:"category"`
+	AccountType string `json:"account_type,omitempty"`
+	Debit       Money  `json:"debit"`
+	Credit      Money  `json:"credit"`
+}
+
+// newLedgerAccount returns the ledger account of code with no totals.
+func newLedgerAccount(code string) LedgerAccount {
+	return LedgerAccount{
+		Code:        code,
+		Category:    ledgerCategory(code),
+		AccountType: ledgerAccountType(code),
+	}
+}
+
+// Balance returns the debit balance of the account. Customer and income
+// ledgers normally have a credit balance and so return a negative value.
+func (a LedgerAccount) Balance() Money {
+	return a.Debit - a.Credit
+}
+
+// LedgerEntry is one side of a journal posted to a ledger account.
+type LedgerEntry struct {
+	ID        string `json:"id"`
+	JournalID string `json:"journal_id"`
+	ReceiptNo string `json:"receipt_no"`
+	Ledger    string `json:"ledger"`
+	Debit     Money  `json:"debit"`
+	Credit    Money  `json:"credit"`
+	Narration string `json:"narration"`
+	Date      int64  `json:"date"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Journal is a group of ledger entries whose debits equal their credits.
+type Journal struct {
+	ID        string
+	ReceiptNo string
+	Narration string
+	Date      int64
+	CreatedAt int64
+	Entries   []LedgerEntry
+}
+
+func newJournal(receiptNo, narration string, at time.Time) Journal {
+	return Journal{
+		ID:        uuid.NewRandom().String(),
+		ReceiptNo: receiptNo,
+		Narration: narration,
+		Date:      now.New(at).BeginningOfDay().Unix(),
+		CreatedAt: at.Unix(),
+	}
+}
+
+// post adds a debit to one ledger and the matching credit to another.
+func (j *Journal) post(debitLedger, creditLedger string, amount Money) {
+	j.add(debitLedger, amount, 0)
+	j.add(creditLedger, 0, amount)
+}
+
+func (j *Journal) add(ledger string, debit, credit Money) {
+	j.Entries = append(j.Entries, LedgerEntry{
+		ID:        uuid.NewRandom().String(),
+		JournalID: j.ID,
+		ReceiptNo: j.ReceiptNo,
+		Ledger:    ledger,
+		Debit:     debit,
+		Credit:    credit,
+		Narration: j.Narration,
+		Date:      j.Date,
+		CreatedAt: j.CreatedAt,
+	})
+}
+
+// validate checks that the journal has entries and that it balances.
+func (j Journal) validate() error {
+	if len(j.Entries) < 2 {
+		return errors.Errorf("journal %s must have at least two entries", j.ID)
+	}
+	var debit, credit Money
+	for _, e := range j.Entries {
+		if e.Debit < 0 || e.Credit < 0 {
+			return errors.Errorf("journal %s has a negative entry on %s", j.ID, e.Ledger)
+		}
+		debit += e.Debit
+		credit += e.Credit
+	}
+	if debit != credit {
+		return errors.Errorf("journal %s does not balance, debit %s credit %s", j.ID, debit, credit)
+	}
+	return nil
+}
+
+// reverse returns a journal that cancels j.
+func (j Journal) reverse(receiptNo, narration string, at time.Time) Journal {
+	r := newJournal(receiptNo, narration, at)
+	for _, e := range j.Entries {
+		r.add(e.Ledger, e.Credit, e.Debit)
+	}
+	return r
+}
+
+// transactionJournal returns the journal of a customer transaction. Deposits
+// move money from the rep's cash or the bank into the customer ledger,
+// withdrawals pay it back out and the DS fee moves it to commission income.
+func transactionJournal(tx Transaction, accountType string, at time.Time) Journal {
+	j := newJournal(tx.ReceiptNo, tx.Narration, at)
+	customer := customerLedger(accountType, tx.AccountNumber)
+	switch {
+	case tx.Kind == TransactionKind_Fee:
+		j.post(customer, commissionIncomeLedger, tx.Amount)
+	case tx.Type == TransactionType_Withdrawal:
+		j.post(customer, paymentLedger(tx.PaymentMethod, tx.SalesRepID), tx.Amount)
+	default:
+		j.post(paymentLedger(tx.PaymentMethod, tx.SalesRepID), customer, tx.Amount)
+	}
+	return j
+}
+
+// expenseJournal returns the journal of an expense paid from the cash of its
+// branch on the day of the expense.
+func expenseJournal(expense Expense, at time.Time) Journal {
+	j := newJournal("", "Expense paid to "+expense.Payee, at)
+	j.Date = expense.Date
+	j.post(expenseLedger(expense.Category), branchCashLedger(expense.BranchID), expense.Amount)
+	return j
+}
+
+// lodgementJournal returns the journal of a lodgement moving branch cash to
+// the bank on the day of the lodgement.
+func lodgementJournal(lodgement Lodgement, at time.Time) Journal {
+	j := newJournal("", "Lodgement at "+lodgement.Bank+", teller "+lodgement.TellerNumber, at)
+	j.Date = lodgement.Date
+	j.post(bankLedger, branchCashLedger(lodgement.BranchID), lodgement.Amount)
+	return j
+}
+
+// remittanceJournal returns the journal of a confirmed remittance moving the
+// cash received from the sales rep to the branch. A shortage stays on the
+// cash ledger of the rep.
+func remittanceJournal(remittance Remittance, at time.Time) Journal {
+	j := newJournal("", "Remittance from "+remittance.SalesRepID, at)
+	j.post(branchCashLedger(remittance.BranchID), cashLedger(remittance.SalesRepID), remittance.ReceivedAmount)
+	return j
+}
+
+// LedgerQuery defines the options to filter ledger entries. Date bounds are
+// inclusive and ignored when zero.
+type LedgerQuery struct {
+	Ledger    string
+	ReceiptNo string
+	From      int64
+	To        int64
+}
+
+// summarizeLedger derives the daily summary figures from ledger entries. In
+// journals of customer transactions, money received into cash or bank is
+// income and money paid out of them is withdrawn, the cash share of both
+// being the cash in and out. The other journals move branch money: expenses
+// are expenditure and what reaches the bank is the bank deposit.
+func summarizeLedger(entries []LedgerEntry) DailySummary {
+	customerJournals := map[string]bool{}
+	for _, e := range entries {
+		if ledgerCategory(e.Ledger) == LedgerCategoryCustomer {
+			customerJournals[e.JournalID] = true
+		}
+	}
+
+	var summary DailySummary
+	for _, e := range entries {
+		category := ledgerCategory(e.Ledger)
+		if !customerJournals[e.JournalID] {
+			switch category {
+			case LedgerCategoryExpense:
+				summary.Expenditure += e.Debit - e.Credit
+			case LedgerCategoryBank:
+				summary.BankDeposit += e.Debit - e.Credit
+			}
+			continue
+		}
+		switch category {
+		case LedgerCategoryCash:
+			summary.Income += e.Debit
+			summary.Withdrawals += e.Credit
+			summary.CashIn += e.Debit
+			summary.CashOut += e.Credit
+		case LedgerCategoryBank:
+			summary.Income += e.Debit
+			summary.Withdrawals += e.Credit
+		}
+	}
+	return summary
+}
+
+// customerBalances derives the total customer balance per account type from
+// the ledger accounts.
+func customerBalances(accounts []LedgerAccount) map[string]Money {
+	balances := map[string]Money{}
+	for _, a := range accounts {
+		if a.Category == LedgerCategoryCustomer {
+			balances[a.AccountType] += a.Credit - a.Debit
+		}
+	}
+	return balances
+}
+
+// TrialBalanceRequest defines the period whose ledger entries are summarized.
+type TrialBalanceRequest struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// TrialBalance lists the totals of every ledger account and proves that the
+// books balance.
+type TrialBalance struct {
+	Accounts       []LedgerAccount  `json:"accounts"`
+	TotalDebit     Money            `json:"total_debit"`
+	TotalCredit    Money            `json:"total_credit"`
+	Balanced       bool             `json:"balanced"`
+	GlobalBalances map[string]Money `json:"global_balances"`
+	Summary        DailySummary     `json:"summary"`
+}
+
+// TrialBalanceHTTP is an HTTP Cloud Function that returns the trial balance.
+func TrialBalanceHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).TrialBalanceHTTP)
+}
+
+func (h *Handler) TrialBalanceHTTP(w http.ResponseWriter, r *http.Request) {
+	var req TrialBalanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	accounts, err := h.store.ListLedgerAccounts(r.Context())
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read ledger accounts")
+		return
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Code < accounts[j].Code })
+
+	tb := TrialBalance{
+		Accounts:       accounts,
+		GlobalBalances: customerBalances(accounts),
+	}
+	for _, a := range accounts {
+		tb.TotalDebit += a.Debit
+		tb.TotalCredit += a.Credit
+	}
+	tb.Balanced = tb.TotalDebit == tb.TotalCredit
+
+	if req.From > 0 || req.To > 0 {
+		entries, err := h.store.ListLedgerEntries(r.Context(), LedgerQuery{From: req.From, To: req.To})
+		if err != nil {
+			log.Println(err)
+			sendError(w, "cannot read ledger entries")
+			return
+		}
+		tb.Summary = summarizeLedger(entries)
+		tb.Summary.Date = req.From
+	}
+
+	sendResponse(w, tb)
+}
+
+// AccountLedger is the ledger history of a customer account.
+type AccountLedger struct {
+	AccountNumber string        `json:"account_number"`
+	Entries       []LedgerEntry `json:"entries"`
+	LedgerBalance Money         `json:"ledger_balance"`
+	Balance       Money         `json:"balance"`
+	InSync        bool          `json:"in_sync"`
+}
+
+// AccountLedgerHTTP is an HTTP Cloud Function that returns the ledger entries
+// of an account and compares the balance derived from them with the stored one.
+func AccountLedgerHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).AccountLedgerHTTP)
+}
+
+func (h *Handler) AccountLedgerHTTP(w http.ResponseWriter, r *http.Request) {
+	var req FindByIdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	account, err := getAccountByNumber(r.Context(), req.ID, h.store)
+	if err != nil {
+		log.Println(err)
+		sendError(w, "Cannot read account by the specified number")
+		return
+	}
+
+	entries, err := h.store.ListLedgerEntries(r.Context(), LedgerQuery{
+		Ledger: customerLedger(account.Type, account.Number),
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read ledger entries")
+		return
+	}
+
+	result := AccountLedger{
+		AccountNumber: account.Number,
+		Entries:       entries,
+		Balance:       account.Balance,
+	}
+	for _, e := range entries {
+		result.LedgerBalance += e.Credit - e.Debit
+	}
+	result.InSync = result.LedgerBalance == result.Balance
+
+	sendResponse(w, result)
+}
+
+// OpenLedgerBalancesHTTP is an HTTP Cloud Function that posts an opening
+// balance journal for every account whose balance is not yet explained by its
+// ledger entries, such as accounts created before the ledger. It is safe to
+// run more than once.
+func OpenLedgerBalancesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).OpenLedgerBalancesHTTP)
+}
+
+func (h *Handler) OpenLedgerBalancesHTTP(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.store.ListAccounts(r.Context(), AccountQuery{})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read account data")
+		return
+	}
+
+	var opened int
+	for _, account := range accounts {
+		var posted bool
+		err := h.store.RunTransaction(r.Context(), func(ctx context.Context, tx Tx) error {
+			posted = false
+			current, err := tx.GetAccount(ctx, account.Number)
+			if err != nil {
+				return err
+			}
+			ledger := customerLedger(current.Type, current.Number)
+			entries, err := h.store.ListLedgerEntries(ctx, LedgerQuery{Ledger: ledger})
+			if err != nil {
+				return err
+			}
+			var balance Money
+			for _, e := range entries {
+				balance += e.Credit - e.Debit
+			}
+			diff := current.Balance - balance
+			if diff == 0 {
+				return nil
+			}
+
+			j := newJournal("", "Opening balance", timeNow())
+			if diff > 0 {
+				j.post(openingBalanceLedger, ledger, diff)
+			} else {
+				j.post(ledger, openingBalanceLedger, -diff)
+			}
+			tx.PostJournal(j)
+			posted = true
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			sendErrorf(w, "cannot open the ledger balance of %s, %s", account.Number, err.Error())
+			return
+		}
+		if posted {
+			opened++
+		}
+	}
+
+	sendResponse(w, opened)
+}
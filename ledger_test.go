@@ -0,0 +1,203 @@
+package surebankltd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJournalValidate(t *testing.T) {
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	balanced := newJournal("TX1", "deposit", at)
+	balanced.post(cashLedger("rep1"), customerLedger(AccountTypeSB, "SB1"), 500*Naira)
+	if err := balanced.validate(); err != nil {
+		t.Errorf("balanced journal: %v", err)
+	}
+
+	split := newJournal("TX2", "split", at)
+	split.add(bankLedger, 300*Naira, 0)
+	split.add(cashLedger("rep1"), 200*Naira, 0)
+	split.add(customerLedger(AccountTypeSB, "SB1"), 0, 500*Naira)
+	if err := split.validate(); err != nil {
+		t.Errorf("split journal: %v", err)
+	}
+
+	unbalanced := newJournal("TX3", "unbalanced", at)
+	unbalanced.add(bankLedger, 300*Naira, 0)
+	unbalanced.add(customerLedger(AccountTypeSB, "SB1"), 0, 200*Naira)
+	if err := unbalanced.validate(); err == nil {
+		t.Error("an unbalanced journal was accepted")
+	}
+
+	single := newJournal("TX4", "single", at)
+	single.add(bankLedger, 0, 0)
+	if err := single.validate(); err == nil {
+		t.Error("a journal with one entry was accepted")
+	}
+
+	negative := newJournal("TX5", "negative", at)
+	negative.add(bankLedger, -100*Naira, 0)
+	negative.add(cashLedger("rep1"), -100*Naira, 0)
+	negative.add(customerLedger(AccountTypeSB, "SB1"), 0, -200*Naira)
+	if err := negative.validate(); err == nil {
+		t.Error("a journal with negative entries was accepted")
+	}
+}
+
+func TestJournalReverse(t *testing.T) {
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	j := newJournal("TX1", "deposit", at)
+	j.post(cashLedger("rep1"), customerLedger(AccountTypeSB, "SB1"), 500*Naira)
+
+	r := j.reverse("TX2", "reversal", at.AddDate(0, 0, 1))
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+	totals := map[string]Money{}
+	for _, e := range append(j.Entries, r.Entries...) {
+		totals[e.Ledger] += e.Debit - e.Credit
+	}
+	for ledger, total := range totals {
+		if total != 0 {
+			t.Errorf("%s is left with %s", ledger, total)
+		}
+	}
+	if r.ReceiptNo != "TX2" || r.Entries[0].ReceiptNo != "TX2" || r.Date == j.Date {
+		t.Errorf("got reversal %+v", r)
+	}
+}
+
+func TestTransactionJournal(t *testing.T) {
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	customer := customerLedger(AccountTypeDS, "DS1")
+	tests := []struct {
+		name          string
+		tx            Transaction
+		debit, credit string
+	}{
+		{
+			name:   "cash deposit",
+			tx:     Transaction{Type: TransactionType_Deposit, PaymentMethod: PaymentMethod_Cash, SalesRepID: "rep1"},
+			debit:  cashLedger("rep1"),
+			credit: customer,
+		},
+		{
+			name:   "bank deposit",
+			tx:     Transaction{Type: TransactionType_Deposit, PaymentMethod: PaymentMethod_Bank, SalesRepID: "rep1"},
+			debit:  bankLedger,
+			credit: customer,
+		},
+		{
+			name:   "cash withdrawal",
+			tx:     Transaction{Type: TransactionType_Withdrawal, PaymentMethod: PaymentMethod_Cash},
+			debit:  customer,
+			credit: cashLedger(""),
+		},
+		{
+			name:   "fee",
+			tx:     Transaction{Type: TransactionType_Withdrawal, Kind: TransactionKind_Fee, SalesRepID: "rep1"},
+			debit:  customer,
+			credit: commissionIncomeLedger,
+		},
+		{
+			name:   "withdrawal narrated like a fee",
+			tx:     Transaction{Type: TransactionType_Withdrawal, Narration: dsFeeNarration, PaymentMethod: PaymentMethod_Cash, SalesRepID: "rep1"},
+			debit:  customer,
+			credit: cashLedger("rep1"),
+		},
+	}
+	for _, tt := range tests {
+		tt.tx.AccountNumber, tt.tx.Amount = "DS1", 200*Naira
+		j := transactionJournal(tt.tx, AccountTypeDS, at)
+		if err := j.validate(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(j.Entries) != 2 || j.Entries[0].Ledger != tt.debit || j.Entries[1].Ledger != tt.credit ||
+			j.Entries[0].Debit != 200*Naira || j.Entries[1].Credit != 200*Naira {
+			t.Errorf("%s: got entries %+v, want debit %s credit %s", tt.name, j.Entries, tt.debit, tt.credit)
+		}
+	}
+}
+
+func TestLedgerTotals(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00010", AccountTypeSB, 0)
+	day := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+
+	postings := []Transaction{
+		{Type: TransactionType_Deposit, Amount: 1000 * Naira, PaymentMethod: PaymentMethod_Cash, SalesRepID: "rep1"},
+		{Type: TransactionType_Deposit, Amount: 400 * Naira, PaymentMethod: PaymentMethod_Bank, SalesRepID: "rep1"},
+	}
+	for i, p := range postings {
+		p.AccountNumber = "SB00010"
+		if _, err := create(ctx, p, day.Add(time.Duration(i)*time.Minute), store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := makeDeduction(ctx, MakeDeductionRequest{
+		AccountNumber: "SB00010",
+		Amount:        300 * Naira,
+		PaymentMethod: PaymentMethod_Cash,
+	}, day.Add(time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recordExpense(ctx, Expense{
+		Category:   "fuel",
+		Amount:     50 * Naira,
+		Payee:      "Filling station",
+		ApprovedBy: "manager",
+	}, day.Add(2*time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recordLodgement(ctx, Lodgement{
+		Bank:         "First Bank",
+		TellerNumber: "T1",
+		Amount:       600 * Naira,
+	}, day.Add(3*time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := store.ListLedgerAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balances := map[string]Money{}
+	var debit, credit Money
+	for _, a := range accounts {
+		balances[a.Code] = a.Balance()
+		debit += a.Debit
+		credit += a.Credit
+	}
+	if debit != credit {
+		t.Errorf("debits %s and credits %s do not balance", debit, credit)
+	}
+	want := map[string]Money{
+		customerLedger(AccountTypeSB, "SB00010"): -1100 * Naira,
+		cashLedger("rep1"):                       1000 * Naira,
+		cashLedger(""):                           -300 * Naira,
+		bankLedger:                               1000 * Naira,
+		expenseLedger("fuel"):                    50 * Naira,
+		branchCashLedger(""):                     -650 * Naira,
+	}
+	for code, balance := range want {
+		if balances[code] != balance {
+			t.Errorf("%s balance = %s, want %s", code, balances[code], balance)
+		}
+	}
+	if got := customerBalances(accounts)[AccountTypeSB]; got != 1100*Naira {
+		t.Errorf("customer balance = %s, want %s", got, 1100*Naira)
+	}
+
+	entries, err := store.ListLedgerEntries(ctx, LedgerQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := summarizeLedger(entries)
+	if summary.Income != 1400*Naira || summary.Withdrawals != 300*Naira || summary.CashIn != 1000*Naira ||
+		summary.CashOut != 300*Naira || summary.Expenditure != 50*Naira || summary.BankDeposit != 600*Naira {
+		t.Errorf("got summary %+v", summary)
+	}
+}
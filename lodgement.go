@@ -0,0 +1,317 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// Lodgement is cash of a branch paid into the bank. Its allocations link it
+// to the days whose cash collections it lodges.
+type Lodgement struct {
+	ID           string `json:"id" truss:"api-read"`
+	BranchID     string `json:"branch_id"`
+	Bank         string `json:"bank" validate:"required"`
+	TellerNumber string `json:"teller_number" validate:"required"`
+	Amount       Money  `json:"amount" validate:"required,gt=0"`
+	// Date is the day the cash was paid into the bank.
+	Date        int64                 `json:"date"`
+	Allocations []LodgementAllocation `json:"allocations"`
+	RecordedBy  string                `json:"recorded_by"`
+	CreatedAt   int64                 `json:"created_at" truss:"api-read"`
+}
+
+// LodgementAllocation is the part of a lodgement taken from the cash
+// collected on a day.
+type LodgementAllocation struct {
+	Date   int64 `json:"date"`
+	Amount Money `json:"amount"`
+}
+
+// RecordLodgementHTTP is an HTTP Cloud Function that records a bank
+// lodgement. Without allocations the lodgement is taken from the oldest
+// unlodged collections of the branch.
+func RecordLodgementHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).RecordLodgementHTTP)
+}
+
+func (h *Handler) RecordLodgementHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Lodgement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	lodgement, err := recordLodgement(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot record lodgement, %s", err.Error())
+		return
+	}
+	sendResponse(w, lodgement)
+}
+
+// recordLodgement saves a lodgement, adds it to the bank deposit of its day
+// and marks the cash of the allocated days as lodged.
+func recordLodgement(ctx context.Context, req Lodgement, currentDate time.Time, store Store) (*Lodgement, error) {
+	switch {
+	case req.Bank == "":
+		return nil, errors.New("bank is required")
+	case req.TellerNumber == "":
+		return nil, errors.New("teller number is required")
+	case req.Amount <= 0:
+		return nil, errors.New("amount must be greater than zero")
+	}
+
+	today := now.New(currentDate).BeginningOfDay()
+	day := today
+	if req.Date > 0 {
+		day = now.New(time.Unix(req.Date, 0)).BeginningOfDay()
+	}
+	if day.After(today) {
+		return nil, errors.New("a lodgement cannot be recorded before it is made")
+	}
+
+	lodgement := req
+	lodgement.ID = uuid.NewRandom().String()
+	lodgement.Date = day.Unix()
+	lodgement.CreatedAt = currentDate.Unix()
+
+	var err error
+	if len(req.Allocations) == 0 {
+		lodgement.Allocations, err = allocateLodgement(ctx, store, req.BranchID, req.Amount, day)
+	} else {
+		lodgement.Allocations, err = mergeAllocations(req.Allocations, req.Amount, day)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		if err := checkDayOpen(ctx, tx, lodgement.BranchID, day); err != nil {
+			return err
+		}
+		// The summaries of the days the cash was collected on change too.
+		for _, a := range lodgement.Allocations {
+			if err := checkDayOpen(ctx, tx, lodgement.BranchID, time.Unix(a.Date, 0)); err != nil {
+				return errors.Errorf("cannot lodge the cash collected on %s, %s",
+					time.Unix(a.Date, 0).Format("2006-01-02"), err.Error())
+			}
+		}
+		for _, a := range lodgement.Allocations {
+			summary, err := tx.GetDailySummary(ctx, lodgement.BranchID, a.Date)
+			if err != nil && err != ErrNotFound {
+				log.Println(err)
+				return errors.New("cannot read the summary of the day")
+			}
+			var unlodged Money
+			if summary != nil {
+				unlodged = summary.unlodgedCash()
+			}
+			if a.Amount > unlodged {
+				return errors.Errorf("only %s of the cash collected on %s is unlodged",
+					unlodged, time.Unix(a.Date, 0).Format("2006-01-02"))
+			}
+		}
+
+		tx.CreateLodgement(lodgement)
+		tx.PostJournal(lodgementJournal(lodgement, currentDate))
+		// A lodgement of the cash of its own day updates one summary once.
+		deltas := map[int64]DailySummary{lodgement.Date: {BankDeposit: lodgement.Amount}}
+		for _, a := range lodgement.Allocations {
+			delta := deltas[a.Date]
+			delta.Lodged += a.Amount
+			deltas[a.Date] = delta
+		}
+		for date, delta := range deltas {
+			tx.IncrementDailySummary(lodgement.BranchID, date, delta)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lodgement, nil
+}
+
+// mergeAllocations validates the allocations of a lodgement made on day and
+// adds up those of the same day.
+func mergeAllocations(allocations []LodgementAllocation, amount Money, day time.Time) ([]LodgementAllocation, error) {
+	byDate := map[int64]Money{}
+	var total Money
+	for _, a := range allocations {
+		if a.Amount <= 0 {
+			return nil, errors.New("allocated amounts must be greater than zero")
+		}
+		date := now.New(time.Unix(a.Date, 0)).BeginningOfDay()
+		if date.After(day) {
+			return nil, errors.New("cash cannot be lodged before it is collected")
+		}
+		byDate[date.Unix()] += a.Amount
+		total += a.Amount
+	}
+	if total != amount {
+		return nil, errors.Errorf("the allocations add up to %s instead of %s", total, amount)
+	}
+
+	merged := make([]LodgementAllocation, 0, len(byDate))
+	for date, amount := range byDate {
+		merged = append(merged, LodgementAllocation{Date: date, Amount: amount})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Date < merged[j].Date })
+	return merged, nil
+}
+
+// allocateLodgement takes amount from the oldest unlodged cash collections
+// of the branch up to day. Closed days are left out since their summaries
+// can no longer change.
+func allocateLodgement(ctx context.Context, store Store, branchID string, amount Money, day time.Time) ([]LodgementAllocation, error) {
+	lock, err := getDayLock(ctx, store, branchID)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read the day close of the branch")
+	}
+	summaries, err := store.ListDailySummaries(ctx, DailySummaryQuery{
+		BranchID: branchID,
+		From:     lock.ClosedThrough + 1,
+		To:       day.Unix(),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read the daily summaries of the branch")
+	}
+
+	allocations := []LodgementAllocation{}
+	remaining := amount
+	// Summaries are listed newest first.
+	for i := len(summaries) - 1; i >= 0 && remaining > 0; i-- {
+		unlodged := summaries[i].unlodgedCash()
+		if unlodged <= 0 {
+			continue
+		}
+		if unlodged > remaining {
+			unlodged = remaining
+		}
+		allocations = append(allocations, LodgementAllocation{Date: summaries[i].Date, Amount: unlodged})
+		remaining -= unlodged
+	}
+	if remaining > 0 {
+		return nil, errors.Errorf("only %s of the collected cash is unlodged", amount-remaining)
+	}
+	return allocations, nil
+}
+
+// ListLodgementsRequest defines the options to filter and page lodgements.
+type ListLodgementsRequest struct {
+	BranchID string `json:"branch_id"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	Limit    int    `json:"limit" example:"10"`
+	Offset   int    `json:"offset" example:"20"`
+}
+
+// ListLodgementsHTTP is an HTTP Cloud Function that lists lodgements, newest first.
+func ListLodgementsHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListLodgementsHTTP)
+}
+
+func (h *Handler) ListLodgementsHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ListLodgementsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	lodgements, err := h.store.ListLodgements(r.Context(), LodgementQuery{
+		BranchID: req.BranchID,
+		From:     req.From,
+		To:       req.To,
+		Limit:    req.Limit,
+		Offset:   req.Offset,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read lodgements")
+		return
+	}
+	if lodgements == nil {
+		lodgements = []Lodgement{}
+	}
+	sendResponse(w, lodgements)
+}
+
+// UnlodgedCashRequest selects the branch and days of the unlodged cash report.
+type UnlodgedCashRequest struct {
+	BranchID string `json:"branch_id"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+}
+
+// UnlodgedCashDay is the cash kept from the collections of a day.
+type UnlodgedCashDay struct {
+	Date      int64 `json:"date"`
+	Collected Money `json:"collected"`
+	// PaidOut is the cash paid for withdrawals and expenses.
+	PaidOut  Money `json:"paid_out"`
+	Lodged   Money `json:"lodged"`
+	Unlodged Money `json:"unlodged"`
+}
+
+// UnlodgedCash lists the days of a branch with cash not yet lodged.
+type UnlodgedCash struct {
+	BranchID string            `json:"branch_id"`
+	Days     []UnlodgedCashDay `json:"days"`
+	Total    Money             `json:"total"`
+}
+
+// UnlodgedCashHTTP is an HTTP Cloud Function that reports the collected cash
+// of a branch that has not been lodged yet, oldest day first.
+func UnlodgedCashHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).UnlodgedCashHTTP)
+}
+
+func (h *Handler) UnlodgedCashHTTP(w http.ResponseWriter, r *http.Request) {
+	var req UnlodgedCashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	summaries, err := h.store.ListDailySummaries(r.Context(), DailySummaryQuery{
+		BranchID: req.BranchID,
+		From:     req.From,
+		To:       req.To,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read the daily summaries of the branch")
+		return
+	}
+
+	report := UnlodgedCash{BranchID: req.BranchID, Days: []UnlodgedCashDay{}}
+	for i := len(summaries) - 1; i >= 0; i-- {
+		s := summaries[i]
+		unlodged := s.unlodgedCash()
+		if unlodged == 0 {
+			continue
+		}
+		report.Days = append(report.Days, UnlodgedCashDay{
+			Date:      s.Date,
+			Collected: s.CashIn,
+			PaidOut:   s.CashOut + s.Expenditure,
+			Lodged:    s.Lodged,
+			Unlodged:  unlodged,
+		})
+		report.Total += unlodged
+	}
+	sendResponse(w, report)
+}
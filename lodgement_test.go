@@ -0,0 +1,68 @@
+package surebankltd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+func TestLodgementRespectsClosedDays(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00050", AccountTypeSB, 0)
+	day1 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	for _, p := range []struct {
+		amount Money
+		at     time.Time
+	}{{1000 * Naira, day1}, {500 * Naira, day2}} {
+		if _, err := create(ctx, Transaction{
+			AccountNumber: "SB00050",
+			Type:          TransactionType_Deposit,
+			Amount:        p.amount,
+			PaymentMethod: PaymentMethod_Cash,
+		}, p.at, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := closeDay(ctx, CloseDayRequest{Date: day1.Unix(), ClosedBy: "manager"}, day2, store); err != nil {
+		t.Fatal(err)
+	}
+
+	lodgement := Lodgement{Bank: "First Bank", TellerNumber: "T1", Amount: 300 * Naira}
+	explicit := lodgement
+	explicit.Allocations = []LodgementAllocation{{Date: day1.Unix(), Amount: 300 * Naira}}
+	if _, err := recordLodgement(ctx, explicit, day2, store); err == nil || !strings.Contains(err.Error(), day1.Format("2006-01-02")) {
+		t.Errorf("lodging the cash of a closed day: got error %v", err)
+	}
+	backdated := lodgement
+	backdated.Date = day1.Unix()
+	if _, err := recordLodgement(ctx, backdated, day2, store); err == nil {
+		t.Error("a lodgement dated on a closed day was accepted")
+	}
+
+	tooMuch := lodgement
+	tooMuch.Amount = 600 * Naira
+	if _, err := recordLodgement(ctx, tooMuch, day2, store); err == nil {
+		t.Error("the cash of a closed day was allocated")
+	}
+	recorded, err := recordLodgement(ctx, lodgement, day2, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := now.New(day2).BeginningOfDay().Unix()
+	if len(recorded.Allocations) != 1 || recorded.Allocations[0] != (LodgementAllocation{Date: today, Amount: 300 * Naira}) {
+		t.Errorf("got allocations %+v, want all of it from the open day", recorded.Allocations)
+	}
+
+	closed, err := store.GetDailySummary(ctx, "", now.New(day1).BeginningOfDay().Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed.Lodged != 0 || closed.BankDeposit != 0 {
+		t.Errorf("the closed summary changed: %+v", closed)
+	}
+}
@@ -0,0 +1,166 @@
+package surebankltd
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Money is an amount of naira stored as an integer number of kobo.
+//
+// Amounts are exchanged with clients in naira: a Money marshals to a JSON
+// number such as 1500.5 and unmarshals from either a JSON number or a
+// string, so existing clients that send and read float amounts keep working.
+type Money int64
+
+const (
+	// Kobo is the minor unit of the naira.
+	Kobo Money = 1
+	// Naira is one hundred kobo.
+	Naira Money = 100
+)
+
+// MoneyFromNaira converts a float naira amount, as stored by earlier versions,
+// to Money rounding to the nearest kobo.
+func MoneyFromNaira(naira float64) Money {
+	return Money(math.Round(naira * float64(Naira)))
+}
+
+// ParseMoney parses a naira amount such as "1500", "1,500.50" or "-20.5".
+// At most two decimal places are accepted.
+func ParseMoney(s string) (Money, error) {
+	str := strings.Replace(strings.TrimSpace(s), ",", "", -1)
+	if str == "" {
+		return 0, errors.Errorf("invalid amount %q", s)
+	}
+	if strings.ContainsAny(str, "eE") {
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return 0, errors.Errorf("invalid amount %q", s)
+		}
+		return MoneyFromNaira(f), nil
+	}
+
+	negative := false
+	switch str[0] {
+	case '-':
+		negative = true
+		str = str[1:]
+	case '+':
+		str = str[1:]
+	}
+
+	whole, frac := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		whole, frac = str[:i], str[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, errors.Errorf("invalid amount %q", s)
+	}
+	if trimmed := strings.TrimRight(frac, "0"); len(trimmed) <= 2 {
+		frac = trimmed
+	} else {
+		return 0, errors.Errorf("invalid amount %q, at most two decimal places are allowed", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || !isDigits(whole) || !isDigits(frac) {
+		return 0, errors.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		n = -n
+	}
+	return Money(n), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Naira returns the amount in naira. It should only be used for display.
+func (m Money) Naira() float64 {
+	return float64(m) / float64(Naira)
+}
+
+// Kobo returns the amount in kobo.
+func (m Money) Kobo() int64 {
+	return int64(m)
+}
+
+// decimal formats m in naira with two decimal places, grouping thousands
+// with sep when it is not empty.
+func (m Money) decimal(sep string) string {
+	n := int64(m)
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	whole := strconv.FormatInt(n/int64(Naira), 10)
+	if sep != "" {
+		var b strings.Builder
+		for i, c := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteString(sep)
+			}
+			b.WriteRune(c)
+		}
+		whole = b.String()
+	}
+	frac := strconv.FormatInt(n%int64(Naira), 10)
+	if len(frac) < 2 {
+		frac = "0" + frac
+	}
+	return sign + whole + "." + frac
+}
+
+// String formats the amount for display, e.g. 1,500.50.
+func (m Money) String() string {
+	return m.decimal(",")
+}
+
+// MarshalJSON encodes the amount as a naira JSON number.
+func (m Money) MarshalJSON() ([]byte, error) {
+	s := m.decimal("")
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return []byte(s), nil
+}
+
+// UnmarshalJSON decodes a naira amount given as a JSON number or string.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		s, err := strconv.Unquote(string(data))
+		if err != nil {
+			return errors.WithMessage(err, "invalid amount")
+		}
+		data = []byte(s)
+		if len(data) == 0 {
+			*m = 0
+			return nil
+		}
+	}
+	v, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
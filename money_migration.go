@@ -0,0 +1,153 @@
+package surebankltd
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+// legacyMoneyFields lists, per collection, the fields that were stored as
+// float64 naira before amounts moved to integer kobo. A float64 value in one
+// of these fields always marks a legacy document because Money is written as
+// an integer.
+var legacyMoneyFields = map[string][]string{
+	"account":      {"Balance", "Target"},
+	"transaction":  {"Amount"},
+	"commission":   {"Amount"},
+	"dailySummary": {"Income", "Expenditure", "BankDeposit"},
+	"shards":       {"Count"},
+}
+
+// legacyMoneyUpdates returns the updates that convert the legacy naira amounts
+// in data, a document of the given collection, to kobo.
+func legacyMoneyUpdates(collection string, data map[string]interface{}) []firestore.Update {
+	var updates []firestore.Update
+	for _, field := range legacyMoneyFields[collection] {
+		if f, ok := data[field].(float64); ok {
+			updates = append(updates, firestore.Update{Path: field, Value: int64(MoneyFromNaira(f))})
+		}
+	}
+
+	if collection != "account" {
+		return updates
+	}
+	recent, ok := data["RecentTransactions"].([]interface{})
+	if !ok {
+		return updates
+	}
+	var changed bool
+	converted := make([]interface{}, len(recent))
+	for i, item := range recent {
+		converted[i] = item
+		tx, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if f, ok := tx["Amount"].(float64); ok {
+			txCopy := make(map[string]interface{}, len(tx))
+			for k, v := range tx {
+				txCopy[k] = v
+			}
+			txCopy["Amount"] = int64(MoneyFromNaira(f))
+			converted[i] = txCopy
+			changed = true
+		}
+	}
+	if changed {
+		updates = append(updates, firestore.Update{Path: "RecentTransactions", Value: converted})
+	}
+	return updates
+}
+
+// hasLegacyMoney reports whether the document still holds amounts in naira.
+func hasLegacyMoney(docSnap *firestore.DocumentSnapshot) bool {
+	return len(legacyMoneyUpdates(docSnap.Ref.Parent.ID, docSnap.Data())) > 0
+}
+
+// migrateLegacyMoney rewrites the legacy amounts of the document, if any, and
+// returns an up to date snapshot. The update is conditioned on the snapshot
+// being current so that a concurrent write is never overwritten.
+func migrateLegacyMoney(ctx context.Context, docSnap *firestore.DocumentSnapshot) (*firestore.DocumentSnapshot, bool, error) {
+	updates := legacyMoneyUpdates(docSnap.Ref.Parent.ID, docSnap.Data())
+	if len(updates) == 0 {
+		return docSnap, false, nil
+	}
+	if _, err := docSnap.Ref.Update(ctx, updates, firestore.LastUpdateTime(docSnap.UpdateTime)); err != nil {
+		return nil, false, errors.WithMessagef(err, "cannot migrate the amounts of %s", docSnap.Ref.Path)
+	}
+	docSnap, err := docSnap.Ref.Get(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return docSnap, true, nil
+}
+
+// moneyMigrator is implemented by stores that may hold amounts written
+// before Money was introduced.
+type moneyMigrator interface {
+	MigrateMoney(ctx context.Context) (map[string]int, error)
+}
+
+// MigrateMoney converts every legacy naira amount to kobo and returns the
+// number of documents migrated per collection.
+func (s *firestoreStore) MigrateMoney(ctx context.Context) (map[string]int, error) {
+	result := map[string]int{}
+	queries := map[string]firestore.Query{
+		"shards": s.client.CollectionGroup("shards").Query,
+	}
+	for collection := range legacyMoneyFields {
+		if collection != "shards" {
+			queries[collection] = s.client.Collection(collection).Query
+		}
+	}
+
+	for collection, query := range queries {
+		iter := query.Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return result, err
+			}
+			_, migrated, err := migrateLegacyMoney(ctx, doc)
+			if err != nil {
+				iter.Stop()
+				return result, err
+			}
+			if migrated {
+				result[collection]++
+			}
+		}
+		iter.Stop()
+	}
+	return result, nil
+}
+
+// MigrateMoneyHTTP is an HTTP Cloud Function that converts amounts stored as
+// float naira to integer kobo. Run it once after deploying: reads refuse
+// legacy documents until then. It is safe to run more than once.
+func MigrateMoneyHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).MigrateMoneyHTTP)
+}
+
+func (h *Handler) MigrateMoneyHTTP(w http.ResponseWriter, r *http.Request) {
+	migrator, ok := h.store.(moneyMigrator)
+	if !ok {
+		sendResponse(w, map[string]int{})
+		return
+	}
+	result, err := migrator.MigrateMoney(r.Context())
+	if err != nil {
+		log.Println(err)
+		sendErrorf(w, "cannot migrate amounts, %s", err.Error())
+		return
+	}
+	sendResponse(w, result)
+}
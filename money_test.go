@@ -0,0 +1,152 @@
+package surebankltd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"1500", 1500 * Naira, true},
+		{"1,500.50", 150050, true},
+		{"-20.5", -2050, true},
+		{"+3", 3 * Naira, true},
+		{".5", 50, true},
+		{"7.", 7 * Naira, true},
+		{"0.10", 10, true},
+		{"2.500", 250, true},
+		{"1e3", 1000 * Naira, true},
+		{"1.005e2", 10050, true},
+		{"0.001", 0, false},
+		{"", 0, false},
+		{".", 0, false},
+		{"1.2.3", 0, false},
+		{"12a", 0, false},
+		{"--1", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseMoney(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d kobo, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyFromNairaRounds(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Money
+	}{
+		{1500, 150000},
+		{0.1 + 0.2, 30},
+		{19.99, 1999},
+		{0.125, 13},
+		{-0.125, -13},
+		{0.004, 0},
+	}
+	for _, tt := range tests {
+		if got := MoneyFromNaira(tt.in); got != tt.want {
+			t.Errorf("MoneyFromNaira(%v) = %d kobo, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		m    Money
+		json string
+	}{
+		{0, "0"},
+		{5, "0.05"},
+		{50, "0.5"},
+		{150000, "1500"},
+		{150050, "1500.5"},
+		{-2050, "-20.5"},
+		{123456789, "1234567.89"},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.json {
+			t.Errorf("Marshal(%d) = %s, want %s", tt.m, data, tt.json)
+		}
+		var got Money
+		if err = json.Unmarshal(data, &got); err != nil || got != tt.m {
+			t.Errorf("Unmarshal(%s) = %d, %v, want %d", data, got, err, tt.m)
+		}
+	}
+
+	var req struct{ Amount Money }
+	for in, want := range map[string]Money{
+		`{"Amount":"1,000.25"}`: 100025,
+		`{"Amount":""}`:         0,
+		`{"Amount":null}`:       0,
+		`{"Amount":12.5}`:       1250,
+	} {
+		req.Amount = 0
+		if err := json.Unmarshal([]byte(in), &req); err != nil || req.Amount != want {
+			t.Errorf("Unmarshal(%s) = %d, %v, want %d", in, req.Amount, err, want)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"Amount":1.234}`), &req); err == nil {
+		t.Error("an amount with three decimal places was accepted")
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	for m, want := range map[Money]string{
+		0:          "0.00",
+		7:          "0.07",
+		100000:     "1,000.00",
+		-123456789: "-1,234,567.89",
+	} {
+		if got := m.String(); got != want {
+			t.Errorf("Money(%d).String() = %s, want %s", m, got, want)
+		}
+	}
+}
+
+func TestLegacyMoneyUpdates(t *testing.T) {
+	data := map[string]interface{}{
+		"Balance": 1500.5,
+		"Target":  int64(20000),
+		"RecentTransactions": []interface{}{
+			map[string]interface{}{"ReceiptNo": "TX1", "Amount": 200.25},
+			map[string]interface{}{"ReceiptNo": "TX2", "Amount": int64(500)},
+		},
+	}
+	updates := legacyMoneyUpdates("account", data)
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want 2: %v", len(updates), updates)
+	}
+	if updates[0].Path != "Balance" || updates[0].Value != int64(150050) {
+		t.Errorf("got balance update %v", updates[0])
+	}
+	recent := updates[1].Value.([]interface{})
+	if updates[1].Path != "RecentTransactions" || recent[0].(map[string]interface{})["Amount"] != int64(20025) {
+		t.Errorf("got recent transactions update %v", updates[1])
+	}
+	if recent[1].(map[string]interface{})["Amount"] != int64(500) {
+		t.Errorf("a converted amount was converted again: %v", recent[1])
+	}
+	if data["RecentTransactions"].([]interface{})[0].(map[string]interface{})["Amount"] != 200.25 {
+		t.Error("the document data was modified")
+	}
+
+	if updates := legacyMoneyUpdates("account", map[string]interface{}{"Balance": int64(5)}); len(updates) != 0 {
+		t.Errorf("got updates %v for a converted document", updates)
+	}
+	if updates := legacyMoneyUpdates("customer", map[string]interface{}{"Balance": 5.0}); len(updates) != 0 {
+		t.Errorf("got updates %v for a collection without amounts", updates)
+	}
+}
@@ -0,0 +1,575 @@
+package surebankltd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ademuanthony/surebankltd/notify"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// Notification statuses.
+const (
+	// NotificationStatusPending is a notification waiting for its next attempt.
+	NotificationStatusPending = "pending"
+	// NotificationStatusSent is a notification accepted by the provider.
+	NotificationStatusSent = "sent"
+	// NotificationStatusDead is a notification that will not be attempted
+	// again unless it is resent.
+	NotificationStatusDead = "dead"
+)
+
+const (
+	// NotificationChannelSMS sends notifications as text messages.
+	NotificationChannelSMS = "sms"
+	// NotificationChannelEmail sends notifications as emails.
+	NotificationChannelEmail = "email"
+
+	// maxNotificationAttempts is the number of attempts after which a
+	// notification is dead.
+	maxNotificationAttempts = 8
+	// notificationRetryDelay is the delay before the second attempt, doubled
+	// after each failed attempt up to maxNotificationRetryDelay.
+	notificationRetryDelay    = time.Minute
+	maxNotificationRetryDelay = 6 * time.Hour
+	// notificationLease is how long an attempt keeps other workers from
+	// picking the notification.
+	notificationLease = 2 * time.Minute
+	// notificationBatchSize is the number of notifications delivered per run
+	// of the worker.
+	notificationBatchSize = 100
+)
+
+// Notification is a message to a customer kept in the outbox. It is written
+// with the change it reports and delivered later by the notification
+// worker, so a message is only sent for a change that was saved.
+type Notification struct {
+	ID            string `json:"id"`
+	Channel       string `json:"channel"`
+	CustomerID    string `json:"customer_id"`
+	AccountNumber string `json:"account_number,omitempty"`
+	ReceiptNo     string `json:"receipt_no,omitempty"`
+	PhoneNumber   string `json:"phone_number,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Template      string `json:"template"`
+	Language      string `json:"language,omitempty"`
+	// Subject and HTMLBody are set for emails, Body being the text part.
+	Subject  string `json:"subject,omitempty"`
+	Body     string `json:"body"`
+	HTMLBody string `json:"html_body,omitempty"`
+	// Pages is the number of SMS pages of a text message.
+	Pages         int    `json:"pages,omitempty"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt int64  `json:"next_attempt_at"`
+	// QuietFrom and QuietTo are the quiet hours of the customer, see
+	// NotificationPreferences.
+	QuietFrom int `json:"quiet_from,omitempty"`
+	QuietTo   int `json:"quiet_to,omitempty"`
+	// Failed is set while the last attempt to send the notification failed
+	// or when the provider reports that the message was not delivered.
+	Failed    bool   `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+	SentAt    int64  `json:"sent_at,omitempty"`
+
+	// The provider result of the last attempt.
+	ProviderMessageID string  `json:"provider_message_id,omitempty"`
+	Cost              float64 `json:"cost,omitempty"`
+	Currency          string  `json:"currency,omitempty"`
+	Units             int     `json:"units,omitempty"`
+	ErrorCode         string  `json:"error_code,omitempty"`
+
+	// DeliveryStatus is the last status received in a delivery report, one
+	// of the DeliveryStatus constants.
+	DeliveryStatus string `json:"delivery_status,omitempty"`
+	DeliveredAt    int64  `json:"delivered_at,omitempty"`
+
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// Delivery statuses of a sent notification.
+const (
+	DeliveryStatusDelivered = "delivered"
+	DeliveryStatusFailed    = "failed"
+	DeliveryStatusPending   = "pending"
+)
+
+// recordResult keeps the provider result of an attempt.
+func (n *Notification) recordResult(result notify.Result) {
+	n.ProviderMessageID = result.MessageID
+	n.Cost = result.Cost
+	n.Currency = result.Currency
+	n.Units = result.Units
+	n.ErrorCode = result.ErrorCode
+}
+
+// newNotification renders the named template for customer. It returns nil
+// when the customer cannot be reached or does not take text messages. A
+// template that cannot be rendered gives a dead notification so that the
+// failure is listed with the others.
+func newNotification(customer *Customer, templateName string, data interface{}, at time.Time) *Notification {
+	if customer == nil || customer.PhoneNumber == "" || !customer.Notifications.channelEnabled(NotificationChannelSMS) {
+		return nil
+	}
+	n := pendingNotification(customer, NotificationChannelSMS, templateName, at)
+	n.PhoneNumber = customer.PhoneNumber
+	body, err := notify.Render(templateName, customer.Language, data)
+	if err != nil {
+		n.kill(err)
+	}
+	n.Body = body
+	// A message longer than a page is charged for every page, translations
+	// with characters outside of the GSM alphabet getting there first.
+	if n.Pages, _ = notify.SMSPages(body); n.Pages > 1 {
+		log.Printf("%s message in %q to customer %s takes %d SMS pages", templateName, n.Language, customer.ID, n.Pages)
+	}
+	return n
+}
+
+// newEmailNotification renders the named email template for customer. It
+// returns nil when the customer has no email address or does not take
+// emails.
+func newEmailNotification(customer *Customer, templateName string, data interface{}, at time.Time) *Notification {
+	if customer == nil || customer.Email == "" || !customer.Notifications.channelEnabled(NotificationChannelEmail) {
+		return nil
+	}
+	n := pendingNotification(customer, NotificationChannelEmail, templateName, at)
+	n.Email = customer.Email
+	email, err := notify.RenderEmail(templateName, customer.Language, data)
+	if err != nil {
+		n.kill(err)
+	}
+	n.Subject, n.Body, n.HTMLBody = email.Subject, email.Text, email.HTML
+	return n
+}
+
+// pendingNotification returns a notification to customer, held back until
+// the end of the quiet hours of the customer.
+func pendingNotification(customer *Customer, channel, templateName string, at time.Time) *Notification {
+	prefs := customer.Notifications
+	return &Notification{
+		ID:            uuid.NewRandom().String(),
+		Channel:       channel,
+		CustomerID:    customer.ID,
+		Template:      templateName,
+		Language:      customer.Language,
+		Status:        NotificationStatusPending,
+		NextAttemptAt: quietUntil(prefs.QuietFrom, prefs.QuietTo, at).Unix(),
+		QuietFrom:     prefs.QuietFrom,
+		QuietTo:       prefs.QuietTo,
+		CreatedAt:     at.Unix(),
+		UpdatedAt:     at.Unix(),
+	}
+}
+
+// kill marks a notification that cannot be sent.
+func (n *Notification) kill(err error) {
+	log.Println(err)
+	n.Status = NotificationStatusDead
+	n.Failed = true
+	n.LastError = err.Error()
+}
+
+// notifications returns the notifications that are not nil.
+func notifications(ns ...*Notification) []Notification {
+	var list []Notification
+	for _, n := range ns {
+		if n != nil {
+			list = append(list, *n)
+		}
+	}
+	return list
+}
+
+// send sends the notification through its channel.
+func (n *Notification) send(ctx context.Context, notifier *notify.Notifier) (notify.Result, error) {
+	if n.Channel == NotificationChannelEmail {
+		return notifier.SendEmail(ctx, n.Email, notify.Email{Subject: n.Subject, Text: n.Body, HTML: n.HTMLBody})
+	}
+	return notifier.SendStr(ctx, n.PhoneNumber, n.Body)
+}
+
+// notificationRetryAt returns when a notification that failed its given
+// attempt is tried again.
+func notificationRetryAt(attempts int, failedAt time.Time) time.Time {
+	delay := notificationRetryDelay
+	for i := 1; i < attempts && delay < maxNotificationRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxNotificationRetryDelay {
+		delay = maxNotificationRetryDelay
+	}
+	return failedAt.Add(delay)
+}
+
+// DeliveryReport counts the outcome of a run of the notification worker.
+type DeliveryReport struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+	Dead   int `json:"dead"`
+}
+
+// DeliverNotificationsHTTP is an HTTP Cloud Function, run on a schedule,
+// that sends the notifications that are due.
+func DeliverNotificationsHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).DeliverNotificationsHTTP)
+}
+
+func (h *Handler) DeliverNotificationsHTTP(w http.ResponseWriter, r *http.Request) {
+	notifier, err := notify.Default()
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot configure the SMS provider")
+		return
+	}
+	report, err := deliverNotifications(r.Context(), h.store, notifier, timeNow())
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot deliver notifications")
+		return
+	}
+	sendResponse(w, report)
+}
+
+// deliverNotifications sends the due notifications. Each notification is
+// claimed for notificationLease before it is sent, so that concurrent
+// workers do not send it twice; a worker stopping after the claim leaves it
+// to be retried once the lease ends.
+func deliverNotifications(ctx context.Context, store Store, notifier *notify.Notifier, currentDate time.Time) (*DeliveryReport, error) {
+	due, err := store.ListNotifications(ctx, NotificationQuery{
+		Status:    NotificationStatusPending,
+		DueBefore: currentDate.Unix(),
+		Limit:     notificationBatchSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DeliveryReport{}
+	for _, n := range due {
+		var claimed *Notification
+		err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+			claimed = nil
+			c, err := tx.GetNotification(ctx, n.ID)
+			if err != nil {
+				return err
+			}
+			if c.Status != NotificationStatusPending || c.NextAttemptAt > currentDate.Unix() {
+				return nil
+			}
+			// A retry falling in the quiet hours waits for them to end.
+			if until := quietUntil(c.QuietFrom, c.QuietTo, currentDate); until.After(currentDate) {
+				c.NextAttemptAt = until.Unix()
+				c.UpdatedAt = currentDate.Unix()
+				tx.UpdateNotification(*c)
+				return nil
+			}
+			c.Attempts++
+			c.NextAttemptAt = currentDate.Add(notificationLease).Unix()
+			c.UpdatedAt = currentDate.Unix()
+			tx.UpdateNotification(*c)
+			claimed = c
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if claimed == nil {
+			continue
+		}
+
+		result, sendErr := claimed.send(ctx, notifier)
+		sentAt := currentDate
+		claimed.UpdatedAt = sentAt.Unix()
+		claimed.recordResult(result)
+		switch {
+		case sendErr == nil:
+			claimed.Status = NotificationStatusSent
+			claimed.SentAt = sentAt.Unix()
+			claimed.DeliveryStatus = DeliveryStatusPending
+			claimed.Failed = false
+			claimed.LastError = ""
+			report.Sent++
+		case claimed.Attempts >= maxNotificationAttempts:
+			claimed.Status = NotificationStatusDead
+			claimed.Failed = true
+			claimed.LastError = sendErr.Error()
+			report.Dead++
+		default:
+			claimed.NextAttemptAt = notificationRetryAt(claimed.Attempts, sentAt).Unix()
+			claimed.Failed = true
+			claimed.LastError = sendErr.Error()
+			report.Failed++
+		}
+
+		batch := store.Batch()
+		batch.UpdateNotification(*claimed)
+		if err := batch.Commit(ctx); err != nil {
+			log.Println(err)
+		}
+	}
+	return report, nil
+}
+
+// ListNotificationsRequest defines the options to filter and page
+// notifications. Failed selects the notifications whose last attempt failed.
+type ListNotificationsRequest struct {
+	Status     string `json:"status"`
+	CustomerID string `json:"customer_id"`
+	Failed     bool   `json:"failed"`
+	Limit      int    `json:"limit" example:"10"`
+	Offset     int    `json:"offset" example:"20"`
+}
+
+// ListNotificationsHTTP is an HTTP Cloud Function that lists notifications,
+// newest first.
+func ListNotificationsHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListNotificationsHTTP)
+}
+
+func (h *Handler) ListNotificationsHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ListNotificationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	notifications, err := h.store.ListNotifications(r.Context(), NotificationQuery{
+		Status:     req.Status,
+		CustomerID: req.CustomerID,
+		Failed:     req.Failed,
+		Limit:      req.Limit,
+		Offset:     req.Offset,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read notifications")
+		return
+	}
+	if notifications == nil {
+		notifications = []Notification{}
+	}
+	sendResponse(w, notifications)
+}
+
+// ResendNotificationHTTP is an HTTP Cloud Function that queues a failed
+// notification for delivery with a fresh set of attempts.
+func ResendNotificationHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ResendNotificationHTTP)
+}
+
+func (h *Handler) ResendNotificationHTTP(w http.ResponseWriter, r *http.Request) {
+	var req FindByIdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	n, err := resendNotification(r.Context(), req.ID, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot resend notification, %s", err.Error())
+		return
+	}
+	sendResponse(w, n)
+}
+
+func resendNotification(ctx context.Context, id string, currentDate time.Time, store Store) (*Notification, error) {
+	var n *Notification
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		n, err = tx.GetNotification(ctx, id)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read notification, please check the ID")
+		}
+		if n.Status == NotificationStatusSent && !n.Failed {
+			return errors.New("the notification has been sent")
+		}
+		if n.Body == "" {
+			return errors.Errorf("the notification cannot be sent, %s", n.LastError)
+		}
+		n.Status = NotificationStatusPending
+		n.Attempts = 0
+		n.DeliveryStatus = ""
+		n.DeliveredAt = 0
+		n.NextAttemptAt = quietUntil(n.QuietFrom, n.QuietTo, currentDate).Unix()
+		n.UpdatedAt = currentDate.Unix()
+		tx.UpdateNotification(*n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// DeliveryStatusRequest is a delivery report of the SMS provider.
+type DeliveryStatusRequest struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	ErrorCode string `json:"error_code"`
+}
+
+// deliveryStatus maps the status reported by a provider to a DeliveryStatus
+// constant. Statuses that are not final are pending.
+func deliveryStatus(status string) string {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "delivered", "delivrd", "success":
+		return DeliveryStatusDelivered
+	case "failed", "undelivered", "undeliv", "rejected", "rejectd", "expired", "deleted":
+		return DeliveryStatusFailed
+	}
+	return DeliveryStatusPending
+}
+
+// SMSDeliveryStatusHTTP is an HTTP Cloud Function receiving the delivery
+// reports of the SMS provider. The provider is configured to call it with
+// the token of SMS_WEBHOOK_TOKEN in the token query parameter, and posts
+// either JSON or a form with message_id, status and error_code.
+func SMSDeliveryStatusHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).SMSDeliveryStatusHTTP)
+}
+
+func (h *Handler) SMSDeliveryStatusHTTP(w http.ResponseWriter, r *http.Request) {
+	token := os.Getenv("SMS_WEBHOOK_TOKEN")
+	if token == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+		sendError(w, "invalid token")
+		return
+	}
+
+	var req DeliveryStatusRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			log.Println(err)
+			sendError(w, "cannot decode client request")
+			return
+		}
+		req = DeliveryStatusRequest{
+			MessageID: r.PostForm.Get("message_id"),
+			Status:    r.PostForm.Get("status"),
+			ErrorCode: r.PostForm.Get("error_code"),
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	n, err := recordDeliveryStatus(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot record delivery status, %s", err.Error())
+		return
+	}
+	sendResponse(w, n)
+}
+
+func recordDeliveryStatus(ctx context.Context, req DeliveryStatusRequest, currentDate time.Time, store Store) (*Notification, error) {
+	if req.MessageID == "" {
+		return nil, errors.New("message ID is required")
+	}
+	found, err := store.ListNotifications(ctx, NotificationQuery{MessageID: req.MessageID, Limit: 1})
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read notification")
+	}
+	if len(found) == 0 {
+		return nil, errors.Errorf("no notification has the message ID %s", req.MessageID)
+	}
+
+	var n *Notification
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		n, err = tx.GetNotification(ctx, found[0].ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read notification")
+		}
+		// A report for an attempt that was replaced by a resend is stale.
+		if n.ProviderMessageID != req.MessageID || n.Status != NotificationStatusSent {
+			return nil
+		}
+		n.DeliveryStatus = deliveryStatus(req.Status)
+		switch n.DeliveryStatus {
+		case DeliveryStatusDelivered:
+			n.DeliveredAt = currentDate.Unix()
+			n.Failed = false
+		case DeliveryStatusFailed:
+			n.Failed = true
+			n.ErrorCode = req.ErrorCode
+			n.LastError = fmt.Sprintf("not delivered, %s", req.Status)
+		}
+		n.UpdatedAt = currentDate.Unix()
+		tx.UpdateNotification(*n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// CustomerMessagesRequest selects the messages of a customer, or of the
+// owner of an account when AccountNumber is set.
+type CustomerMessagesRequest struct {
+	CustomerID    string `json:"customer_id"`
+	AccountNumber string `json:"account_number"`
+	Limit         int    `json:"limit" example:"10"`
+	Offset        int    `json:"offset" example:"20"`
+}
+
+// CustomerMessagesHTTP is an HTTP Cloud Function that lists the messages of a
+// customer, newest first, with their delivery status.
+func CustomerMessagesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).CustomerMessagesHTTP)
+}
+
+func (h *Handler) CustomerMessagesHTTP(w http.ResponseWriter, r *http.Request) {
+	var req CustomerMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	customerID := req.CustomerID
+	if req.AccountNumber != "" {
+		account, err := getAccountByNumber(r.Context(), req.AccountNumber, h.store)
+		if err != nil {
+			log.Println(err)
+			sendError(w, "Cannot read account by the specified number")
+			return
+		}
+		customerID = account.CustomerID
+	}
+	if customerID == "" {
+		sendError(w, "customer ID or account number is required")
+		return
+	}
+
+	notifications, err := h.store.ListNotifications(r.Context(), NotificationQuery{
+		CustomerID: customerID,
+		Limit:      req.Limit,
+		Offset:     req.Offset,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read messages")
+		return
+	}
+	if notifications == nil {
+		notifications = []Notification{}
+	}
+	sendResponse(w, notifications)
+}
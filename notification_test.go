@@ -0,0 +1,295 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ademuanthony/surebankltd/notify"
+)
+
+// failingSender fails the given number of sends and then hands the
+// messages to the memory recorder. A negative number fails every send.
+type failingSender struct {
+	failures int
+}
+
+func (s *failingSender) Send(ctx context.Context, phoneNumber, message string) (notify.Result, error) {
+	if s.failures != 0 {
+		s.failures--
+		return notify.Result{ErrorCode: "1706"}, errors.New("the provider is unavailable")
+	}
+	return notify.MemoryRecorder().Send(ctx, phoneNumber, message)
+}
+
+func newTestNotification(t *testing.T, store Store, id string, at time.Time) {
+	t.Helper()
+	batch := store.Batch()
+	batch.CreateNotification(Notification{
+		ID:            id,
+		Channel:       NotificationChannelSMS,
+		CustomerID:    "customer-1",
+		PhoneNumber:   "08030000000",
+		Template:      notify.SMSDSDigest,
+		Body:          "Message " + id,
+		Status:        NotificationStatusPending,
+		NextAttemptAt: at.Unix(),
+		CreatedAt:     at.Unix(),
+		UpdatedAt:     at.Unix(),
+	})
+	if err := batch.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotificationRetryAt(t *testing.T) {
+	failedAt := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Minute,
+		2:  2 * time.Minute,
+		3:  4 * time.Minute,
+		5:  16 * time.Minute,
+		9:  256 * time.Minute,
+		10: 6 * time.Hour,
+		50: 6 * time.Hour,
+	} {
+		if got := notificationRetryAt(attempts, failedAt); !got.Equal(failedAt.Add(want)) {
+			t.Errorf("retry after attempt %d at %s, want %s later", attempts, got, want)
+		}
+	}
+}
+
+func TestDeliverNotificationsBacksOff(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	t0 := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	newTestNotification(t, store, "n1", t0)
+	sender := &failingSender{failures: 2}
+	notifier := notify.NewNotifier(sender, notify.MemoryRecorder(), nil)
+	sent := len(notify.MemoryRecorder().Messages())
+
+	deliver := func(at time.Time, want DeliveryReport) *Notification {
+		t.Helper()
+		report, err := deliverNotifications(ctx, store, notifier, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *report != want {
+			t.Errorf("report at %s = %+v, want %+v", at.Format("15:04:05"), *report, want)
+		}
+		n, err := store.GetNotification(ctx, "n1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	n := deliver(t0, DeliveryReport{Failed: 1})
+	if n.Attempts != 1 || !n.Failed || n.ErrorCode != "1706" || n.NextAttemptAt != t0.Add(time.Minute).Unix() {
+		t.Fatalf("got notification %+v after the first failure", n)
+	}
+	deliver(t0.Add(59*time.Second), DeliveryReport{})
+	n = deliver(t0.Add(time.Minute), DeliveryReport{Failed: 1})
+	if n.Attempts != 2 || n.NextAttemptAt != t0.Add(3*time.Minute).Unix() {
+		t.Fatalf("got notification %+v after the second failure", n)
+	}
+	n = deliver(t0.Add(3*time.Minute), DeliveryReport{Sent: 1})
+	if n.Status != NotificationStatusSent || n.Failed || n.LastError != "" || n.Attempts != 3 ||
+		n.DeliveryStatus != DeliveryStatusPending || n.ProviderMessageID == "" {
+		t.Fatalf("got notification %+v after it was sent", n)
+	}
+	messages := notify.MemoryRecorder().Messages()
+	if len(messages) != sent+1 || messages[len(messages)-1].Body != "Message n1" {
+		t.Errorf("got messages %+v", messages[sent:])
+	}
+	deliver(t0.Add(time.Hour), DeliveryReport{})
+}
+
+func TestDeadNotificationsAreListedAndResent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	t0 := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	newTestNotification(t, store, "n1", t0)
+	newTestNotification(t, store, "n2", t0.Add(time.Hour))
+	notifier := notify.NewNotifier(&failingSender{failures: -1}, notify.MemoryRecorder(), nil)
+
+	var dead int
+	at := t0
+	for i := 0; i < maxNotificationAttempts; i++ {
+		report, err := deliverNotifications(ctx, store, notifier, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dead += report.Dead
+		n, err := store.GetNotification(ctx, "n1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		at = time.Unix(n.NextAttemptAt, 0)
+	}
+	n, err := store.GetNotification(ctx, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dead != 1 || n.Status != NotificationStatusDead || n.Attempts != maxNotificationAttempts || !n.Failed {
+		t.Fatalf("got notification %+v, %d dead, after %d failures", n, dead, maxNotificationAttempts)
+	}
+	if report, err := deliverNotifications(ctx, store, notifier, at.Add(24*time.Hour)); err != nil || report.Dead != 0 {
+		t.Errorf("a dead notification was attempted again: %+v, %v", report, err)
+	}
+
+	h := NewHandler(store)
+	list := func(body string) []Notification {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ListNotificationsHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		var resp struct {
+			Success bool
+			Data    []Notification
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success {
+			t.Fatalf("got response %s, %v", w.Body, err)
+		}
+		return resp.Data
+	}
+	for _, body := range []string{`{"status":"dead"}`, `{"failed":true,"status":"dead"}`} {
+		if got := list(body); len(got) != 1 || got[0].ID != "n1" {
+			t.Errorf("%s listed %+v, want n1", body, got)
+		}
+	}
+
+	// The other notification failed once on the last run and is retried.
+	failed := list(`{"failed":true}`)
+	if len(failed) != 2 {
+		t.Errorf("failed notifications = %+v, want both", failed)
+	}
+
+	resent, err := resendNotification(ctx, "n1", at, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resent.Status != NotificationStatusPending || resent.Attempts != 0 || resent.NextAttemptAt != at.Unix() {
+		t.Fatalf("got resent notification %+v", resent)
+	}
+	sent := len(notify.MemoryRecorder().Messages())
+	notifier = notify.NewNotifier(&failingSender{}, notify.MemoryRecorder(), nil)
+	if _, err = deliverNotifications(ctx, store, notifier, at); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = store.GetNotification(ctx, "n1"); err != nil || n.Status != NotificationStatusSent {
+		t.Fatalf("got notification %+v, %v after the resend", n, err)
+	}
+	if len(notify.MemoryRecorder().Messages()) <= sent {
+		t.Error("the resent notification was not sent")
+	}
+	if _, err = resendNotification(ctx, "n1", at, store); err == nil {
+		t.Error("a sent notification was resent")
+	}
+}
+
+func TestDeliveryStatus(t *testing.T) {
+	for status, want := range map[string]string{
+		"DELIVRD":     DeliveryStatusDelivered,
+		" delivered ": DeliveryStatusDelivered,
+		"Undelivered": DeliveryStatusFailed,
+		"rejected":    DeliveryStatusFailed,
+		"expired":     DeliveryStatusFailed,
+		"sent":        DeliveryStatusPending,
+		"":            DeliveryStatusPending,
+	} {
+		if got := deliveryStatus(status); got != want {
+			t.Errorf("deliveryStatus(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestSMSDeliveryStatusHTTP(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	t0 := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	newTestNotification(t, store, "n1", t0)
+	newTestNotification(t, store, "n2", t0)
+	notifier := notify.NewNotifier(&failingSender{}, notify.MemoryRecorder(), nil)
+	if _, err := deliverNotifications(ctx, store, notifier, t0); err != nil {
+		t.Fatal(err)
+	}
+	messageID := func(id string) string {
+		n, err := store.GetNotification(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n.ProviderMessageID
+	}
+	n1, n2 := messageID("n1"), messageID("n2")
+
+	old, set := os.LookupEnv("SMS_WEBHOOK_TOKEN")
+	os.Setenv("SMS_WEBHOOK_TOKEN", "secret")
+	defer func() {
+		if set {
+			os.Setenv("SMS_WEBHOOK_TOKEN", old)
+		} else {
+			os.Unsetenv("SMS_WEBHOOK_TOKEN")
+		}
+	}()
+
+	h := NewHandler(store)
+	post := func(token, contentType, body string) (*Notification, string) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/?token="+url.QueryEscape(token), strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.SMSDeliveryStatusHTTP(w, r)
+		var resp struct {
+			Success bool
+			Message string
+			Data    *Notification
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data, resp.Message
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		body := fmt.Sprintf(`{"message_id":%q,"status":"DELIVRD"}`, n1)
+		if n, msg := post(token, "application/json", body); n != nil || msg != "invalid token" {
+			t.Errorf("token %q got %+v, %q", token, n, msg)
+		}
+	}
+	if n, _ := store.GetNotification(ctx, "n1"); n.DeliveryStatus != DeliveryStatusPending {
+		t.Fatalf("a report with an invalid token was recorded: %+v", n)
+	}
+
+	n, msg := post("secret", "application/json", fmt.Sprintf(`{"message_id":%q,"status":"DELIVRD"}`, n1))
+	if n == nil || n.ID != "n1" || n.DeliveryStatus != DeliveryStatusDelivered || n.DeliveredAt == 0 || n.Failed {
+		t.Fatalf("got %+v, %q for a JSON report", n, msg)
+	}
+
+	form := url.Values{"message_id": {n2}, "status": {"UNDELIV"}, "error_code": {"005"}}
+	n, msg = post("secret", "application/x-www-form-urlencoded", form.Encode())
+	if n == nil || n.ID != "n2" || n.DeliveryStatus != DeliveryStatusFailed || !n.Failed || n.ErrorCode != "005" {
+		t.Fatalf("got %+v, %q for a form report", n, msg)
+	}
+
+	if n, msg = post("secret", "application/json", `{"message_id":"unknown","status":"DELIVRD"}`); n != nil || msg == "" {
+		t.Errorf("got %+v, %q for an unknown message ID", n, msg)
+	}
+
+	// A report for the attempt replaced by a resend is ignored.
+	if _, err := resendNotification(ctx, "n2", t0, store); err != nil {
+		t.Fatal(err)
+	}
+	if n, msg = post("secret", "application/json", fmt.Sprintf(`{"message_id":%q,"status":"DELIVRD"}`, n2)); n == nil {
+		t.Fatalf("got %q for a stale report", msg)
+	}
+	if n, _ = store.GetNotification(ctx, "n2"); n.Status != NotificationStatusPending || n.DeliveryStatus != "" {
+		t.Errorf("a stale report changed the notification to %+v", n)
+	}
+}
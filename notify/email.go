@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Email is a rendered email, sent as a multipart message holding both the
+// text and the HTML body.
+type Email struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers an email to an address.
+type Mailer interface {
+	SendEmail(ctx context.Context, to string, email Email) (Result, error)
+}
+
+// LogMailer writes emails to the log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) SendEmail(ctx context.Context, to string, email Email) (Result, error) {
+	log.Printf("email to %s: %s\n%s", to, email.Subject, email.Text)
+	return Result{Status: "logged"}, nil
+}
+
+// SMTPMailer sends emails through an SMTP server, using STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from *mail.Address
+}
+
+// NewSMTPMailer returns an SMTPMailer sending from the address from through
+// the server at addr, host:port. The server is authenticated with when
+// username is not empty.
+func NewSMTPMailer(addr, username, password, from string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid SMTP address")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid email sender")
+	}
+	m := &SMTPMailer{addr: addr, host: host, from: sender}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) SendEmail(ctx context.Context, to string, email Email) (Result, error) {
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return Result{}, errors.WithMessage(err, "invalid email address")
+	}
+	messageID, msg, err := buildEmail(m.from, recipient, email, time.Now())
+	if err != nil {
+		return Result{}, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return Result{}, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return Result{}, err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return Result{}, err
+		}
+	}
+	if m.auth != nil {
+		if err = c.Auth(m.auth); err != nil {
+			return Result{}, err
+		}
+	}
+	if err = c.Mail(m.from.Address); err != nil {
+		return Result{}, err
+	}
+	if err = c.Rcpt(recipient.Address); err != nil {
+		return Result{ErrorCode: smtpCode(err)}, err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return Result{}, err
+	}
+	if err = w.Close(); err != nil {
+		return Result{ErrorCode: smtpCode(err)}, err
+	}
+	if err = c.Quit(); err != nil {
+		log.Println(err)
+	}
+	return Result{MessageID: messageID, Status: "success"}, nil
+}
+
+// smtpCode returns the reply code of an SMTP error.
+func smtpCode(err error) string {
+	if e, ok := err.(*textproto.Error); ok {
+		return fmt.Sprint(e.Code)
+	}
+	return ""
+}
+
+// buildEmail returns the Message-ID and the MIME message of email.
+func buildEmail(from, to *mail.Address, email Email, date time.Time) (string, []byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	messageID := fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain)
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err = qp.Write([]byte(part.content)); err != nil {
+			return "", nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return "", nil, err
+	}
+
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", email.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"Message-ID", messageID},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	} {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return messageID, msg.Bytes(), nil
+}
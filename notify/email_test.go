@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer is a local stand-in for an SMTP server keeping the messages it
+// receives.
+type smtpServer struct {
+	listener net.Listener
+	messages chan smtpMessage
+}
+
+type smtpMessage struct {
+	from, to string
+	data     string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: l, messages: make(chan smtpMessage, 10)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP")
+	var msg smtpMessage
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case cmd == "EHLO" || cmd == "HELO":
+			c.PrintfLine("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			c.PrintfLine("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			msg.to = strings.Trim(line[len("RCPT TO:"):], "<> ")
+			c.PrintfLine("250 OK")
+		case cmd == "DATA":
+			c.PrintfLine("354 send the message")
+			data, err := ioutil.ReadAll(c.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.messages <- msg
+			c.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSendsMultipartEmail(t *testing.T) {
+	server := newSMTPServer(t)
+	mailer, err := NewSMTPMailer(server.listener.Addr().String(), "", "", "Surebank <info@surebankltd.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := NewNotifier(LogSender{}, mailer, nil)
+
+	email, err := notifier.RenderEmail(EmailDeposit, LanguageEnglish, TransactionEmailPayload{
+		Name:          "Ada Obi",
+		AccountNumber: "SB10003001",
+		ReceiptNo:     "HQ-000000001",
+		Date:          "16/10/2026",
+		Amount:        "1,000.00",
+		Balance:       "5,000.00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := notifier.SendEmail(ctx, "ada@example.com", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received smtpMessage
+	select {
+	case received = <-server.messages:
+	case <-ctx.Done():
+		t.Fatal("no message received")
+	}
+	if received.from != "info@surebankltd.com" || received.to != "ada@example.com" {
+		t.Fatalf("got envelope %s -> %s", received.from, received.to)
+	}
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(received.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Message-ID"); got != result.MessageID {
+		t.Errorf("got Message-ID %s, result has %s", got, result.MessageID)
+	}
+	if got := msg.Header.Get("Subject"); got != email.Subject {
+		t.Errorf("got subject %q, want %q", got, email.Subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %s, %v", mediaType, err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+	if parts["text/plain"] != email.Text {
+		t.Errorf("got text part %q, want %q", parts["text/plain"], email.Text)
+	}
+	if parts["text/html"] != email.HTML {
+		t.Errorf("got HTML part %q, want %q", parts["text/html"], email.HTML)
+	}
+	if !strings.Contains(parts["text/plain"], "1,000.00") {
+		t.Errorf("text part does not hold the amount: %q", parts["text/plain"])
+	}
+}
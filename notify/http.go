@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	text "text/template"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPSender sends messages to a provider that accepts a JSON body holding
+// the recipient, the sender ID and the message.
+type HTTPSender struct {
+	url    *text.Template
+	token  string
+	sender string
+	client *http.Client
+}
+
+// httpMessage is the body posted by HTTPSender and the data of its URL template.
+type httpMessage struct {
+	To      string `json:"to"`
+	From    string `json:"from"`
+	Message string `json:"message"`
+	Token   string `json:"-"`
+}
+
+// NewHTTPSender returns an HTTPSender posting to the URL rendered from
+// urlTemplate with the fields To, From, Message and Token, e.g.
+// "https://sms.example.com/send?key={{query .Token}}". The query function
+// escapes a value for a URL. A non-empty token is also sent as a bearer
+// token.
+func NewHTTPSender(urlTemplate, token, sender string, client *http.Client) (*HTTPSender, error) {
+	if urlTemplate == "" {
+		return nil, errors.New("SMS URL template is required.")
+	}
+	tmpl, err := text.New("url").Funcs(text.FuncMap{"query": url.QueryEscape}).Parse(urlTemplate)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid SMS URL template")
+	}
+	return &HTTPSender{url: tmpl, token: token, sender: sender, client: client}, nil
+}
+
+// httpResult is the optional JSON body answered by the provider.
+type httpResult struct {
+	ID        jsonValue `json:"id"`
+	MessageID jsonValue `json:"message_id"`
+	Status    string    `json:"status"`
+	Cost      jsonValue `json:"cost"`
+	Currency  string    `json:"currency"`
+	Units     jsonValue `json:"units"`
+	ErrorCode jsonValue `json:"error_code"`
+	Message   string    `json:"message"`
+}
+
+// Send posts the message. The response may describe the message with the
+// JSON fields message_id (or id), status, cost, currency, units, error_code
+// and message; any other response of a 2xx status is accepted as is.
+func (s *HTTPSender) Send(ctx context.Context, phoneNumber, message string) (Result, error) {
+	msg := httpMessage{To: phoneNumber, From: s.sender, Message: message, Token: s.token}
+	var endpoint bytes.Buffer
+	if err := s.url.Execute(&endpoint, msg); err != nil {
+		return Result{}, errors.WithMessage(err, "cannot render SMS URL")
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return Result{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, errors.WithMessage(err, "cannot read response body")
+	}
+	var r httpResult
+	_ = json.Unmarshal(respBody, &r)
+	result := Result{
+		MessageID:   r.MessageID.String(),
+		Status:      strings.ToLower(r.Status),
+		Cost:        r.Cost.Float(),
+		Currency:    r.Currency,
+		Units:       r.Units.Int(),
+		ErrorCode:   r.ErrorCode.String(),
+		Description: r.Message,
+	}
+	if result.MessageID == "" {
+		result.MessageID = r.ID.String()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if result.ErrorCode == "" {
+			result.ErrorCode = fmt.Sprint(resp.StatusCode)
+		}
+		return result, fmt.Errorf("cannot send message, %s: %s", resp.Status, string(respBody))
+	}
+	if result.ErrorCode != "" {
+		return result, fmt.Errorf("cannot send message, %s: %s", result.ErrorCode, result.Description)
+	}
+	return result, nil
+}
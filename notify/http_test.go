@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSenderSend(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       Result
+		err        string
+	}{
+		{
+			name:       "accepted",
+			statusCode: http.StatusOK,
+			body:       `{"message_id":"m-1","status":"Sent","cost":"4.5","currency":"NGN","units":1}`,
+			want:       Result{MessageID: "m-1", Status: "sent", Cost: 4.5, Currency: "NGN", Units: 1},
+		},
+		{
+			name:       "id instead of message id",
+			statusCode: http.StatusCreated,
+			body:       `{"id":77}`,
+			want:       Result{MessageID: "77"},
+		},
+		{
+			name:       "non-JSON 2xx response",
+			statusCode: http.StatusAccepted,
+			body:       `OK`,
+		},
+		{
+			name:       "error code",
+			statusCode: http.StatusOK,
+			body:       `{"error_code":"E17","message":"Invalid number"}`,
+			want:       Result{ErrorCode: "E17", Description: "Invalid number"},
+			err:        "cannot send message, E17: Invalid number",
+		},
+		{
+			name:       "non-2xx status",
+			statusCode: http.StatusInternalServerError,
+			body:       `upstream failure`,
+			want:       Result{ErrorCode: "500"},
+			err:        "cannot send message, 500 Internal Server Error: upstream failure",
+		},
+		{
+			name:       "non-2xx status with an error code",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error_code":21,"message":"Missing sender"}`,
+			want:       Result{ErrorCode: "21", Description: "Missing sender"},
+			err:        "cannot send message, 400 Bad Request",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got httpMessage
+			var query, auth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query().Get("key")
+				auth = r.Header.Get("Authorization")
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(test.statusCode)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			sender, err := NewHTTPSender(server.URL+"/send?key={{query .Token}}", "a&b", "SureBank", server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := sender.Send(context.Background(), "08030000000", "Hello")
+			if result != test.want {
+				t.Errorf("got result %+v, want %+v", result, test.want)
+			}
+			if test.err == "" && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+			if got != (httpMessage{To: "08030000000", From: "SureBank", Message: "Hello"}) {
+				t.Errorf("posted %+v", got)
+			}
+			if query != "a&b" || auth != "Bearer a&b" {
+				t.Errorf("got key %q and authorization %q", query, auth)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// The characters of the GSM 03.38 alphabet, those of the extension table
+// taking two characters of a message.
+const (
+	gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// SMSPages returns the number of SMS pages message is sent in, and whether
+// it is sent as Unicode, a single character outside of the GSM alphabet,
+// such as the tone marks of Yoruba, cutting a page from 160 to 70
+// characters.
+func SMSPages(message string) (pages int, unicode bool) {
+	length := 0
+	for _, r := range message {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			length++
+		case strings.ContainsRune(gsm7Extended, r):
+			length += 2
+		default:
+			unicode = true
+		}
+	}
+	single, multi := 160, 153
+	if unicode {
+		length = len(utf16.Encode([]rune(message)))
+		single, multi = 70, 67
+	}
+	if length == 0 {
+		return 0, unicode
+	}
+	if length <= single {
+		return 1, unicode
+	}
+	return (length + multi - 1) / multi, unicode
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// LogSender writes messages to the log instead of sending them.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, phoneNumber, message string) (Result, error) {
+	log.Printf("SMS to %s: %s", phoneNumber, message)
+	return Result{Status: "logged"}, nil
+}
+
+// Message is a message kept by a Recorder.
+type Message struct {
+	PhoneNumber string
+	Body        string
+}
+
+// SentEmail is an email kept by a Recorder.
+type SentEmail struct {
+	To string
+	Email
+}
+
+// Recorder keeps the messages and emails sent through it, for tests.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+	emails   []SentEmail
+}
+
+// memoryRecorder is the Sender of the memory provider.
+var memoryRecorder = &Recorder{}
+
+// MemoryRecorder returns the Recorder shared by every Sender of the memory
+// provider, so that local runs and tests can read what was sent.
+func MemoryRecorder() *Recorder {
+	return memoryRecorder
+}
+
+// Send keeps the message, giving it the message ID "recorded-<n>", n
+// counting the messages from 1.
+func (r *Recorder) Send(ctx context.Context, phoneNumber, message string) (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, Message{PhoneNumber: phoneNumber, Body: message})
+	return Result{MessageID: fmt.Sprintf("recorded-%d", len(r.messages)), Status: "success"}, nil
+}
+
+// Messages returns the messages sent so far.
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.messages...)
+}
+
+// SendEmail keeps the email, giving it the message ID "email-<n>".
+func (r *Recorder) SendEmail(ctx context.Context, to string, email Email) (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emails = append(r.emails, SentEmail{To: to, Email: email})
+	return Result{MessageID: fmt.Sprintf("email-%d", len(r.emails)), Status: "success"}, nil
+}
+
+// Emails returns the emails sent so far.
+func (r *Recorder) Emails() []SentEmail {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SentEmail(nil), r.emails...)
+}
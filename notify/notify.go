@@ -0,0 +1,257 @@
+// Package notify sends text messages to customers through a configurable
+// SMS provider, and emails through an SMTP server.
+package notify
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Sender delivers a text message to a phone number. The result reported by
+// the provider is returned even when the message is refused.
+type Sender interface {
+	Send(ctx context.Context, phoneNumber, message string) (Result, error)
+}
+
+// SMS providers selected by Config.Provider.
+const (
+	ProviderBulkSmsNigeria = "bulksmsnigeria"
+	ProviderHTTP           = "http"
+	ProviderLog            = "log"
+	ProviderMemory         = "memory"
+)
+
+const defaultSenderID = "SUREBLTD"
+
+// Config selects and configures the SMS provider.
+type Config struct {
+	// Provider is one of the Provider constants. It defaults to BulkSMS
+	// Nigeria when Token is set and to the log sender otherwise.
+	Provider string
+	Token    string
+	SenderID string
+	// URLTemplate is the endpoint of the HTTP provider, see NewHTTPSender.
+	URLTemplate string
+	// TemplateDir replaces the templates embedded in the package, see
+	// ParseTemplates.
+	TemplateDir string
+
+	// SMTPAddr is the host:port of the SMTP server, emails are logged when
+	// it is empty.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// EmailFrom is the sender of the emails, e.g. "Surebank <info@surebankltd.com>".
+	EmailFrom string
+}
+
+// ConfigFromEnv reads the configuration from SMS_PROVIDER, SMS_Auth_TOKEN,
+// SMS_SENDER_ID, SMS_HTTP_URL, TEMPLATE_DIR, SMTP_ADDR, SMTP_USERNAME,
+// SMTP_PASSWORD and EMAIL_FROM.
+func ConfigFromEnv() Config {
+	return Config{
+		Provider:     os.Getenv("SMS_PROVIDER"),
+		Token:        os.Getenv("SMS_Auth_TOKEN"),
+		SenderID:     os.Getenv("SMS_SENDER_ID"),
+		URLTemplate:  os.Getenv("SMS_HTTP_URL"),
+		TemplateDir:  os.Getenv("TEMPLATE_DIR"),
+		SMTPAddr:     os.Getenv("SMTP_ADDR"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		EmailFrom:    os.Getenv("EMAIL_FROM"),
+	}
+}
+
+// NewMailer returns the SMTP Mailer of the configuration, or a LogMailer
+// when no SMTP server is configured.
+func NewMailer(cfg Config) (Mailer, error) {
+	if cfg.SMTPAddr == "" {
+		return LogMailer{}, nil
+	}
+	if cfg.EmailFrom == "" {
+		return nil, errors.New("email sender is required.")
+	}
+	return NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.EmailFrom)
+}
+
+// NewSender returns the Sender of the configured provider.
+func NewSender(cfg Config, client *http.Client) (Sender, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if cfg.SenderID == "" {
+		cfg.SenderID = defaultSenderID
+	}
+	provider := cfg.Provider
+	if provider == "" {
+		provider = ProviderLog
+		if cfg.Token != "" {
+			provider = ProviderBulkSmsNigeria
+		}
+	}
+
+	switch provider {
+	case ProviderBulkSmsNigeria:
+		return NewBulkSmsNigeria(cfg.Token, cfg.SenderID, client)
+	case ProviderHTTP:
+		return NewHTTPSender(cfg.URLTemplate, cfg.Token, cfg.SenderID, client)
+	case ProviderLog:
+		return LogSender{}, nil
+	case ProviderMemory:
+		return memoryRecorder, nil
+	}
+	return nil, errors.Errorf("unknown SMS provider %q", provider)
+}
+
+// Notifier renders message templates and sends them through a Sender, or a
+// Mailer for emails.
+type Notifier struct {
+	sender    Sender
+	mailer    Mailer
+	templates *Templates
+}
+
+// NewNotifier returns a Notifier sending the messages of templates, the
+// embedded templates when templates is nil. Emails are logged when mailer is
+// nil.
+func NewNotifier(sender Sender, mailer Mailer, templates *Templates) *Notifier {
+	if mailer == nil {
+		mailer = LogMailer{}
+	}
+	return &Notifier{sender: sender, mailer: mailer, templates: templates}
+}
+
+func (n *Notifier) getTemplates() (*Templates, error) {
+	if n.templates != nil {
+		return n.templates, nil
+	}
+	return defaultTemplates, defaultTemplatesErr
+}
+
+// Render returns the message of the named template in lang with data.
+func (n *Notifier) Render(templateName, lang string, data interface{}) (string, error) {
+	templates, err := n.getTemplates()
+	if err != nil {
+		return "", err
+	}
+	return templates.Render(templateName, lang, data)
+}
+
+// RenderEmail returns the email of the named template in lang with data.
+func (n *Notifier) RenderEmail(templateName, lang string, data interface{}) (Email, error) {
+	templates, err := n.getTemplates()
+	if err != nil {
+		return Email{}, err
+	}
+	return templates.RenderEmail(templateName, lang, data)
+}
+
+// SendEmail sends email to the address to.
+func (n *Notifier) SendEmail(ctx context.Context, to string, email Email) (Result, error) {
+	return n.mailer.SendEmail(ctx, to, email)
+}
+
+// Send renders the named template with data and sends it to phoneNumber.
+func (n *Notifier) Send(ctx context.Context, phoneNumber, templateName string, data interface{}) (Result, error) {
+	body, err := n.Render(templateName, LanguageEnglish, data)
+	if err != nil {
+		return Result{}, err
+	}
+	return n.sender.Send(ctx, phoneNumber, body)
+}
+
+// SendStr sends message to phoneNumber as is.
+func (n *Notifier) SendStr(ctx context.Context, phoneNumber, message string) (Result, error) {
+	return n.sender.Send(ctx, phoneNumber, message)
+}
+
+var (
+	defaultMu       sync.Mutex
+	defaultNotifier *Notifier
+)
+
+// Default returns the Notifier used by Send and SendStr, configured from the
+// environment on first use.
+func Default() (*Notifier, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultNotifier != nil {
+		return defaultNotifier, nil
+	}
+
+	cfg := ConfigFromEnv()
+	sender, err := NewSender(cfg, http.DefaultClient)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sender.(LogSender); ok {
+		log.Println("no SMS provider is configured, messages are logged")
+	}
+	mailer, err := NewMailer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := mailer.(LogMailer); ok {
+		log.Println("no SMTP server is configured, emails are logged")
+	}
+	var templates *Templates
+	if cfg.TemplateDir != "" {
+		if templates, err = ParseTemplates(os.DirFS(cfg.TemplateDir)); err != nil {
+			return nil, err
+		}
+	}
+	defaultNotifier = NewNotifier(sender, mailer, templates)
+	return defaultNotifier, nil
+}
+
+// SetDefault replaces the Notifier used by Send and SendStr, e.g. with one
+// sending through a Recorder in tests.
+func SetDefault(n *Notifier) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultNotifier = n
+}
+
+// Send renders the named template with data and sends it to phoneNumber
+// through the default Notifier.
+func Send(ctx context.Context, phoneNumber, templateName string, data interface{}) (Result, error) {
+	n, err := Default()
+	if err != nil {
+		return Result{}, err
+	}
+	return n.Send(ctx, phoneNumber, templateName, data)
+}
+
+// Render returns the message of the named template in lang with data using
+// the default Notifier.
+func Render(templateName, lang string, data interface{}) (string, error) {
+	n, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return n.Render(templateName, lang, data)
+}
+
+// RenderEmail returns the email of the named template in lang with data
+// using the default Notifier.
+func RenderEmail(templateName, lang string, data interface{}) (Email, error) {
+	n, err := Default()
+	if err != nil {
+		return Email{}, err
+	}
+	return n.RenderEmail(templateName, lang, data)
+}
+
+// SendStr sends message to phoneNumber through the default Notifier.
+func SendStr(ctx context.Context, phoneNumber, message string) (Result, error) {
+	n, err := Default()
+	if err != nil {
+		return Result{}, err
+	}
+	return n.SendStr(ctx, phoneNumber, message)
+}
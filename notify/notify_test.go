@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryProviderSharesRecorder(t *testing.T) {
+	ctx := context.Background()
+	first, err := NewSender(Config{Provider: ProviderMemory}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewSender(Config{Provider: ProviderMemory}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(MemoryRecorder().Messages())
+	if _, err = first.Send(ctx, "08030000000", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = second.Send(ctx, "08030000001", "second"); err != nil {
+		t.Fatal(err)
+	}
+	messages := MemoryRecorder().Messages()[before:]
+	if len(messages) != 2 || messages[0].Body != "first" || messages[1].PhoneNumber != "08030000001" {
+		t.Errorf("got messages %+v", messages)
+	}
+}
@@ -0,0 +1,94 @@
+package notify
+
+// DepositSMSPayload is the data of the payment received and payment
+// withdrawn messages. Amounts are formatted in naira.
+type DepositSMSPayload struct {
+	Name    string
+	Amount  string
+	Balance string
+}
+
+// DSDepositSMSPayload is the data of the daily contribution message.
+type DSDepositSMSPayload struct {
+	Name          string
+	EffectiveDate string
+	Amount        string
+	Balance       string
+}
+
+// WelcomeSMSPayload is the data of the welcome message.
+type WelcomeSMSPayload struct {
+	Name          string
+	AccountNumber string
+	Target        string
+}
+
+// TransactionEmailPayload is the data of the deposit and withdrawal emails.
+// Amounts are formatted in naira.
+type TransactionEmailPayload struct {
+	Name          string
+	AccountNumber string
+	ReceiptNo     string
+	Date          string
+	Narration     string
+	Amount        string
+	Balance       string
+}
+
+// StatementEmailPayload is the data of the monthly statement email.
+type StatementEmailPayload struct {
+	Name             string
+	AccountNumber    string
+	Period           string
+	OpeningBalance   string
+	TotalDeposits    string
+	TotalWithdrawals string
+	ClosingBalance   string
+	Lines            []StatementLine
+}
+
+// StatementLine is a transaction of a statement, Credit or Debit being set.
+type StatementLine struct {
+	Date      string
+	ReceiptNo string
+	Narration string
+	Credit    string
+	Debit     string
+	Balance   string
+}
+
+// UserInviteEmailPayload is the data of the email inviting a user.
+type UserInviteEmailPayload struct {
+	FromUser struct {
+		FirstName string
+	}
+	Account struct {
+		Name string
+	}
+	Minutes int
+	Url     string
+}
+
+// UserResetPasswordEmailPayload is the data of the password reset email.
+type UserResetPasswordEmailPayload struct {
+	Name    string
+	Minutes int
+	Url     string
+}
+
+// DSDigestPayload is the data of the daily digest of the DS contributions
+// of a customer.
+type DSDigestPayload struct {
+	Name     string
+	Date     string
+	Amount   string
+	Accounts []DSDigestAccount
+}
+
+// DSDigestAccount is the contributions of the day to an account.
+type DSDigestAccount struct {
+	AccountNumber string
+	Days          int
+	Amount        string
+	Balance       string
+}
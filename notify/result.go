@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Result is what the provider reported about a message it was given.
+type Result struct {
+	// MessageID identifies the message in the delivery reports of the
+	// provider.
+	MessageID string `json:"message_id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	// Cost is charged by the provider in Currency, Units being the number
+	// of SMS units or pages billed.
+	Cost      float64 `json:"cost,omitempty"`
+	Currency  string  `json:"currency,omitempty"`
+	Units     int     `json:"units,omitempty"`
+	ErrorCode string  `json:"error_code,omitempty"`
+	// Description is the message of the provider, if any.
+	Description string `json:"description,omitempty"`
+}
+
+// jsonValue is a JSON scalar that providers send either as a string or as a
+// number.
+type jsonValue string
+
+func (v *jsonValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = jsonValue(s)
+		return nil
+	}
+	if string(b) == "null" {
+		*v = ""
+		return nil
+	}
+	*v = jsonValue(strings.TrimSpace(string(b)))
+	return nil
+}
+
+func (v jsonValue) String() string {
+	return string(v)
+}
+
+func (v jsonValue) Float() float64 {
+	f, _ := strconv.ParseFloat(string(v), 64)
+	return f
+}
+
+func (v jsonValue) Int() int {
+	i, _ := strconv.Atoi(string(v))
+	return i
+}
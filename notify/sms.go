@@ -1,40 +1,25 @@
 package notify
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
-	text "text/template"
 
 	"github.com/pkg/errors"
 )
 
+// BulkSmsNigeria sends messages through the BulkSMS Nigeria API.
 type BulkSmsNigeria struct {
-	token       string
-	sender      string
-	templateDir string
-	client      http.Client
+	token  string
+	sender string
+	client *http.Client
 }
 
-var bulkSmsNigeria *BulkSmsNigeria
-
-func init() {
-	var err error
-	bulkSmsNigeria, err = NewBulkSmsNigeria(os.Getenv("SMS_Auth_TOKEN"),
-		"SUREBLTD", "./resources/templates/sms", *http.DefaultClient)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func NewBulkSmsNigeria(token, sender, sharedTemplateDir string, client http.Client) (*BulkSmsNigeria, error) {
+func NewBulkSmsNigeria(token, sender string, client *http.Client) (*BulkSmsNigeria, error) {
 
 	if token == "" {
 		return nil, errors.New("SMS Auth token is required.")
@@ -44,100 +29,92 @@ func NewBulkSmsNigeria(token, sender, sharedTemplateDir string, client http.Clie
 		return nil, errors.New("SMS sender is required.")
 	}
 
-	templateDir := filepath.Join(sharedTemplateDir, "sms")
-	if _, err := os.Stat(templateDir); os.IsNotExist(err) {
-		return nil, errors.WithMessage(err, "SMS template directory does not exist.")
-	}
-
 	return &BulkSmsNigeria{
-		token:       token,
-		sender:      sender,
-		templateDir: sharedTemplateDir,
-		client:      client,
+		token:  token,
+		sender: sender,
+		client: client,
 	}, nil
 }
 
-func (b *BulkSmsNigeria) Send(ctx context.Context, phoneNumber, templateName string, data map[string]interface{}) error {
-
-	body, err := parseSMSTemplates(b.templateDir, templateName, data)
-	if err != nil {
-		return err
-	}
+// bulkSmsResponse is the body returned by BulkSMS Nigeria, holding data for
+// an accepted message and error otherwise.
+type bulkSmsResponse struct {
+	Data *struct {
+		Status    string    `json:"status"`
+		Message   string    `json:"message"`
+		MessageID jsonValue `json:"message_id"`
+		Cost      jsonValue `json:"cost"`
+		Currency  string    `json:"currency"`
+		Units     jsonValue `json:"units"`
+	} `json:"data"`
+	Error *struct {
+		Code    jsonValue `json:"code"`
+		Message string    `json:"message"`
+	} `json:"error"`
+}
 
+func (b *BulkSmsNigeria) Send(ctx context.Context, phoneNumber, message string) (Result, error) {
 	params := url.Values{}
 	params.Add("api_token", b.token)
 	params.Add("from", b.sender)
 	params.Add("to", phoneNumber)
-	params.Add("body", body)
-
-	resp, err := b.client.Get("https://www.bulksmsnigeria.com/api/v1/sms/create?" + params.Encode())
+	params.Add("body", message)
 
+	req, err := http.NewRequest(http.MethodGet, "https://www.bulksmsnigeria.com/api/v1/sms/create?"+params.Encode(), nil)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
-
-	respBody, err := ioutil.ReadAll(resp.Body)
+	resp, err := b.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return errors.WithMessage(err, "cannot read response body")
+		return Result{}, err
 	}
+	defer resp.Body.Close()
 
-	if !strings.Contains(string(respBody), "") {
-		return fmt.Errorf("cannot sent message, %s", string(respBody))
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, errors.WithMessage(err, "cannot read response body")
 	}
-
-	return nil
+	return parseBulkSmsResponse(resp.StatusCode, respBody)
 }
 
-func Send(ctx context.Context, phoneNumber, templateName string, data map[string]interface{}) error {
-	return bulkSmsNigeria.Send(ctx, phoneNumber, templateName, data)
-}
-
-func (b *BulkSmsNigeria) SendStr(ctx context.Context, phoneNumber, message string) error {
-	params := url.Values{}
-	params.Add("api_token", b.token)
-	params.Add("from", b.sender)
-	params.Add("to", phoneNumber)
-	params.Add("body", message)
-
-	resp, err := b.client.Get("https://www.bulksmsnigeria.com/api/v1/sms/create?" + params.Encode())
-
-	if err != nil {
-		return err
+// parseBulkSmsResponse returns the result reported in body and an error
+// unless the message was accepted.
+func parseBulkSmsResponse(statusCode int, body []byte) (Result, error) {
+	var r bulkSmsResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		var result Result
+		if statusCode < 200 || statusCode > 299 {
+			result.ErrorCode = fmt.Sprint(statusCode)
+		}
+		return result, fmt.Errorf("cannot send message, unexpected response %d: %s", statusCode, string(body))
 	}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errors.WithMessage(err, "cannot read response body")
+	var result Result
+	if r.Data != nil {
+		result = Result{
+			MessageID:   r.Data.MessageID.String(),
+			Status:      strings.ToLower(r.Data.Status),
+			Cost:        r.Data.Cost.Float(),
+			Currency:    r.Data.Currency,
+			Units:       r.Data.Units.Int(),
+			Description: r.Data.Message,
+		}
 	}
-
-	if !strings.Contains(string(respBody), "") {
-		return fmt.Errorf("cannot sent message, %s", string(respBody))
+	if r.Error != nil {
+		result.ErrorCode = r.Error.Code.String()
+		result.Description = r.Error.Message
 	}
-
-	return nil
-}
-
-func SendStr(ctx context.Context, phoneNumber, message string) error {
-	return bulkSmsNigeria.SendStr(ctx, phoneNumber, message)
-}
-
-func parseSMSTemplates(templateDir, templateName string, data map[string]interface{}) (string, error) {
-	txtFile := filepath.Join(templateDir, templateName+".txt")
-	txtTmpl, err := text.ParseFiles(txtFile)
-	if err != nil {
-		return "", errors.WithMessage(err, "Failed to load SMS template.")
+	if result.ErrorCode == "" && (statusCode < 200 || statusCode > 299) {
+		result.ErrorCode = fmt.Sprint(statusCode)
 	}
-
-	var txtDat bytes.Buffer
-	if err := txtTmpl.Execute(&txtDat, data); err != nil {
-		return "", errors.WithMessage(err, "Failed to parse SMS template.")
+	if result.ErrorCode != "" || result.Status != "success" {
+		if result.Description == "" {
+			result.Description = string(body)
+		}
+		if result.ErrorCode == "" {
+			return result, fmt.Errorf("cannot send message, %s", result.Description)
+		}
+		return result, fmt.Errorf("cannot send message, %s: %s", result.ErrorCode, result.Description)
 	}
-
-	return string(txtDat.Bytes()), nil
-}
-
-type DepositSMSPayload struct {
-	Name    string
-	Amount  float64
-	Balance float64
+	return result, nil
 }
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseBulkSmsResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       Result
+		err        string
+	}{
+		{
+			name:       "accepted",
+			statusCode: http.StatusOK,
+			body:       `{"data":{"status":"success","message":"Message Sent","message_id":"f4e2a1","cost":2.5,"currency":"NGN","units":"1"}}`,
+			want:       Result{MessageID: "f4e2a1", Status: "success", Cost: 2.5, Currency: "NGN", Units: 1, Description: "Message Sent"},
+		},
+		{
+			name:       "numeric message id",
+			statusCode: http.StatusOK,
+			body:       `{"data":{"status":"Success","message_id":1234,"cost":"3","units":2}}`,
+			want:       Result{MessageID: "1234", Status: "success", Cost: 3, Units: 2},
+		},
+		{
+			name:       "error code",
+			statusCode: http.StatusOK,
+			body:       `{"error":{"code":"BSNG-1002","message":"Insufficient balance"}}`,
+			want:       Result{ErrorCode: "BSNG-1002", Description: "Insufficient balance"},
+			err:        "cannot send message, BSNG-1002: Insufficient balance",
+		},
+		{
+			name:       "error code with a non-2xx status",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"error":{"code":401,"message":"Unauthenticated"}}`,
+			want:       Result{ErrorCode: "401", Description: "Unauthenticated"},
+			err:        "cannot send message, 401: Unauthenticated",
+		},
+		{
+			name:       "non-2xx status",
+			statusCode: http.StatusBadGateway,
+			body:       `{}`,
+			want:       Result{ErrorCode: "502", Description: "{}"},
+			err:        "cannot send message, 502: {}",
+		},
+		{
+			name:       "non-JSON non-2xx response",
+			statusCode: http.StatusServiceUnavailable,
+			body:       `<html>Service Unavailable</html>`,
+			want:       Result{ErrorCode: "503"},
+			err:        "cannot send message, unexpected response 503: <html>Service Unavailable</html>",
+		},
+		{
+			name:       "status other than success",
+			statusCode: http.StatusOK,
+			body:       `{"data":{"status":"queued","message":"Sender ID not approved"}}`,
+			want:       Result{Status: "queued", Description: "Sender ID not approved"},
+			err:        "cannot send message, Sender ID not approved",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseBulkSmsResponse(test.statusCode, []byte(test.body))
+			if got != test.want {
+				t.Errorf("got result %+v, want %+v", got, test.want)
+			}
+			if test.err == "" && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	html "html/template"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+	text "text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Names of the message templates.
+const (
+	SMSPaymentReceived  = "sms/payment_received"
+	SMSPaymentWithdrawn = "sms/payment_withdrawn"
+	SMSDSReceived       = "sms/ds_received"
+	SMSWelcome          = "sms/welcome_message"
+	SMSDSDigest         = "sms/ds_digest"
+
+	EmailDeposit           = "emails/deposit"
+	EmailWithdrawal        = "emails/withdrawal"
+	EmailMonthlyStatement  = "emails/monthly_statement"
+	EmailUserInvite        = "emails/user_invite"
+	EmailUserResetPassword = "emails/user_reset_password"
+	EmailDSDigest          = "emails/ds_digest"
+)
+
+// emailPrefix starts the names of the email templates.
+const emailPrefix = "emails/"
+
+// Languages of the templates. A template translated into a language is
+// named after the English template and the language, e.g.
+// sms/payment_received.yo.txt.
+const (
+	LanguageEnglish = "en"
+	LanguageYoruba  = "yo"
+	LanguageIgbo    = "ig"
+	LanguageHausa   = "ha"
+	LanguagePidgin  = "pcm"
+)
+
+var languages = []string{LanguageEnglish, LanguageYoruba, LanguageIgbo, LanguageHausa, LanguagePidgin}
+
+// SupportedLanguage reports whether lang is one of the Language constants.
+// The empty language is English.
+func SupportedLanguage(lang string) bool {
+	if lang == "" {
+		return true
+	}
+	for _, l := range languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// localized returns the key of the template name in lang.
+func localized(name, lang string) string {
+	if lang == "" || lang == LanguageEnglish {
+		return name
+	}
+	return name + "." + lang
+}
+
+// splitLanguage splits the base name of a template file into the template
+// name and the language, empty for English.
+func splitLanguage(base string) (name, lang string) {
+	dot := strings.LastIndex(base, ".")
+	if dot < strings.LastIndex(base, "/") {
+		return base, ""
+	}
+	return base[:dot], base[dot+1:]
+}
+
+// payloads holds the payload each template is executed with. A template
+// file without a payload is an error, so that templates and code cannot
+// drift apart.
+var payloads = map[string]interface{}{
+	SMSPaymentReceived:  DepositSMSPayload{},
+	SMSPaymentWithdrawn: DepositSMSPayload{},
+	SMSDSReceived:       DSDepositSMSPayload{},
+	SMSWelcome:          WelcomeSMSPayload{},
+	SMSDSDigest:         DSDigestPayload{},
+
+	EmailDeposit:           TransactionEmailPayload{},
+	EmailWithdrawal:        TransactionEmailPayload{},
+	EmailMonthlyStatement:  StatementEmailPayload{},
+	EmailUserInvite:        UserInviteEmailPayload{},
+	EmailUserResetPassword: UserResetPasswordEmailPayload{},
+	EmailDSDigest:          DSDigestPayload{},
+}
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+var defaultTemplates, defaultTemplatesErr = parseEmbeddedTemplates()
+
+func parseEmbeddedTemplates() (*Templates, error) {
+	fsys, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplates(fsys)
+}
+
+// Templates is a set of message templates checked against their payloads.
+type Templates struct {
+	texts map[string]*text.Template
+	htmls map[string]*html.Template
+}
+
+// ParseTemplates parses the templates of fsys, "sms/payment_received" naming
+// sms/payment_received.txt and its translation sms/payment_received.yo.txt.
+// An email template has a text part, defining the subject in a "subject"
+// template, and an HTML part, e.g. emails/deposit.txt and
+// emails/deposit.html. Every template must have a payload and an English
+// version, must not be empty and must execute against its payload.
+func ParseTemplates(fsys fs.FS) (*Templates, error) {
+	t := &Templates{texts: map[string]*text.Template{}, htmls: map[string]*html.Template{}}
+	var keys []string
+	err := fs.WalkDir(fsys, ".", func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := path.Ext(file)
+		name, lang := splitLanguage(strings.TrimSuffix(file, ext))
+		if ext != ".txt" && !(ext == ".html" && strings.HasPrefix(name, emailPrefix)) {
+			return errors.Errorf("unexpected template file %s", file)
+		}
+		if lang == LanguageEnglish || !SupportedLanguage(lang) {
+			return errors.Errorf("template %s has an unknown language", file)
+		}
+		payload, ok := payloads[name]
+		if !ok {
+			return errors.Errorf("template %s has no payload", name)
+		}
+		key := localized(name, lang)
+		if ext == ".txt" {
+			keys = append(keys, key)
+		}
+		src, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(src)) == "" {
+			return errors.Errorf("template %s is empty", file)
+		}
+
+		var tmpl executor
+		if ext == ".html" {
+			h, err := html.New(key).Option("missingkey=error").Parse(string(src))
+			if err != nil {
+				return errors.WithMessagef(err, "cannot parse template %s", file)
+			}
+			t.htmls[key], tmpl = h, h
+		} else {
+			x, err := text.New(key).Option("missingkey=error").Parse(string(src))
+			if err != nil {
+				return errors.WithMessagef(err, "cannot parse template %s", file)
+			}
+			if strings.HasPrefix(name, emailPrefix) {
+				subject := x.Lookup("subject")
+				if subject == nil {
+					return errors.Errorf("template %s does not define the subject", file)
+				}
+				if err = subject.Execute(&bytes.Buffer{}, payload); err != nil {
+					return errors.WithMessagef(err, "template %s does not match %T", file, payload)
+				}
+			}
+			t.texts[key], tmpl = x, x
+		}
+		if err = tmpl.Execute(&bytes.Buffer{}, payload); err != nil {
+			return errors.WithMessagef(err, "template %s does not match %T", file, payload)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name := range payloads {
+		if _, ok := t.texts[name]; !ok {
+			return nil, errors.Errorf("template %s is missing", name)
+		}
+	}
+	for key := range t.htmls {
+		if _, ok := t.texts[key]; !ok {
+			return nil, errors.Errorf("template %s has no text part", key)
+		}
+	}
+	for _, key := range keys {
+		if _, ok := t.htmls[key]; !ok && strings.HasPrefix(key, emailPrefix) {
+			return nil, errors.Errorf("template %s has no HTML part", key)
+		}
+	}
+	return t, nil
+}
+
+// lookup returns the key of the named template in lang, falling back to
+// English when the template is not translated into lang.
+func (t *Templates) lookup(name, lang string) string {
+	if key := localized(name, lang); t.texts[key] != nil {
+		return key
+	}
+	return name
+}
+
+// Render returns the message of the named template in lang with data, which
+// must be of the payload type of the template.
+func (t *Templates) Render(name, lang string, data interface{}) (string, error) {
+	if strings.HasPrefix(name, emailPrefix) {
+		return "", errors.Errorf("template %s is an email", name)
+	}
+	return t.execute(t.texts[t.lookup(name, lang)], name, data)
+}
+
+// RenderEmail returns the email of the named template in lang with data,
+// which must be of the payload type of the template.
+func (t *Templates) RenderEmail(name, lang string, data interface{}) (Email, error) {
+	if !strings.HasPrefix(name, emailPrefix) {
+		return Email{}, errors.Errorf("template %s is not an email", name)
+	}
+	key := t.lookup(name, lang)
+	var email Email
+	var err error
+	tmpl := t.texts[key]
+	if tmpl != nil {
+		tmpl = tmpl.Lookup("subject")
+	}
+	if email.Subject, err = t.execute(tmpl, name, data); err != nil {
+		return Email{}, err
+	}
+	if email.Text, err = t.execute(t.texts[key], name, data); err != nil {
+		return Email{}, err
+	}
+	if email.HTML, err = t.execute(t.htmls[key], name, data); err != nil {
+		return Email{}, err
+	}
+	return email, nil
+}
+
+// executor is a text or an HTML template.
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+func (t *Templates) execute(tmpl executor, name string, data interface{}) (string, error) {
+	if tmpl == nil || reflect.ValueOf(tmpl).IsNil() {
+		return "", errors.Errorf("unknown template %s", name)
+	}
+	if want := reflect.TypeOf(payloads[name]); reflect.TypeOf(data) != want {
+		return "", errors.Errorf("template %s takes a %s, not a %T", name, want, data)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.WithMessagef(err, "cannot render template %s", name)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEmbeddedTemplates(t *testing.T) {
+	if defaultTemplatesErr != nil {
+		t.Fatal(defaultTemplatesErr)
+	}
+	for name, payload := range payloads {
+		for _, lang := range languages {
+			if strings.HasPrefix(name, emailPrefix) {
+				email, err := defaultTemplates.RenderEmail(name, lang, payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if email.Subject == "" || email.Text == "" || email.HTML == "" {
+					t.Fatalf("template %s renders an incomplete email %+v", name, email)
+				}
+				continue
+			}
+			body, err := defaultTemplates.Render(name, lang, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body == "" {
+				t.Fatalf("template %s renders an empty message in %s", name, lang)
+			}
+		}
+	}
+}
+
+func TestRenderFallsBackToEnglish(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for name := range payloads {
+		if strings.HasPrefix(name, emailPrefix) {
+			fsys[name+".txt"] = &fstest.MapFile{Data: []byte(`{{ define "subject" }}Hello{{ end }}Hello`)}
+			fsys[name+".html"] = &fstest.MapFile{Data: []byte("<p>Hello</p>")}
+			continue
+		}
+		fsys[name+".txt"] = &fstest.MapFile{Data: []byte("Hello {{ .Name }}")}
+	}
+	fsys[SMSPaymentReceived+".yo.txt"] = &fstest.MapFile{Data: []byte("Ẹ kú iṣẹ́ {{ .Name }}")}
+	templates, err := ParseTemplates(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, lang, want string
+	}{
+		{SMSPaymentReceived, LanguageYoruba, "Ẹ kú iṣẹ́ Ada"},
+		{SMSPaymentReceived, "", "Hello Ada"},
+		{SMSPaymentReceived, LanguageHausa, "Hello Ada"},
+		{SMSPaymentWithdrawn, LanguageYoruba, "Hello Ada"},
+	}
+	for _, test := range tests {
+		got, err := templates.Render(test.name, test.lang, DepositSMSPayload{Name: "Ada"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s in %q: got %q, want %q", test.name, test.lang, got, test.want)
+		}
+	}
+
+	fsys[SMSPaymentReceived+".fr.txt"] = &fstest.MapFile{Data: []byte("Bonjour {{ .Name }}")}
+	if _, err := ParseTemplates(fsys); err == nil || !strings.Contains(err.Error(), "unknown language") {
+		t.Errorf("unknown language: got error %v", err)
+	}
+}
+
+func TestSMSPages(t *testing.T) {
+	tests := []struct {
+		message string
+		pages   int
+		unicode bool
+	}{
+		{"", 0, false},
+		{strings.Repeat("a", 160), 1, false},
+		{strings.Repeat("a", 161), 2, false},
+		{strings.Repeat("€", 80), 1, false},
+		{strings.Repeat("€", 81), 2, false},
+		{strings.Repeat("ẹ", 70), 1, true},
+		{strings.Repeat("ẹ", 71), 2, true},
+		{strings.Repeat("ẹ", 135), 3, true},
+	}
+	for _, test := range tests {
+		pages, unicode := SMSPages(test.message)
+		if pages != test.pages || unicode != test.unicode {
+			t.Errorf("%d characters: got %d pages, unicode %v, want %d, %v",
+				len([]rune(test.message)), pages, unicode, test.pages, test.unicode)
+		}
+	}
+}
+
+func TestParseTemplatesRejectsBrokenTemplates(t *testing.T) {
+	valid := fstest.MapFS{}
+	for name := range payloads {
+		if strings.HasPrefix(name, emailPrefix) {
+			valid[name+".txt"] = &fstest.MapFile{Data: []byte(`{{ define "subject" }}Hello{{ end }}Hello`)}
+			valid[name+".html"] = &fstest.MapFile{Data: []byte("<p>Hello</p>")}
+			continue
+		}
+		valid[name+".txt"] = &fstest.MapFile{Data: []byte("Dear {{ .Name }}")}
+	}
+	if _, err := ParseTemplates(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		data string
+		err  string
+	}{
+		{"empty", SMSPaymentReceived + ".txt", " \n", "is empty"},
+		{"missing field", SMSPaymentReceived + ".txt", "Dear {{ .FirstName }}", "does not match"},
+		{"unknown template", "sms/unknown.txt", "Hello", "has no payload"},
+		{"no subject", EmailDeposit + ".txt", "Hello", "does not define the subject"},
+		{"missing HTML field", EmailDeposit + ".html", "<p>{{ .Total }}</p>", "does not match"},
+	}
+	for _, test := range tests {
+		fsys := fstest.MapFS{}
+		for file, f := range valid {
+			fsys[file] = f
+		}
+		fsys[test.file] = &fstest.MapFile{Data: []byte(test.data)}
+		if _, err := ParseTemplates(fsys); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+
+	html := valid[EmailWithdrawal+".html"]
+	delete(valid, EmailWithdrawal+".html")
+	if _, err := ParseTemplates(valid); err == nil || !strings.Contains(err.Error(), "has no HTML part") {
+		t.Errorf("missing HTML part: got error %v", err)
+	}
+	valid[EmailWithdrawal+".html"] = html
+
+	delete(valid, SMSWelcome+".txt")
+	if _, err := ParseTemplates(valid); err == nil || !strings.Contains(err.Error(), "is missing") {
+		t.Errorf("missing template: got error %v", err)
+	}
+}
+
+func TestRenderChecksPayloadType(t *testing.T) {
+	if _, err := defaultTemplates.Render(SMSDSReceived, LanguageEnglish, DepositSMSPayload{}); err == nil {
+		t.Fatal("rendered a template with the payload of another template")
+	}
+}
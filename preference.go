@@ -0,0 +1,147 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ademuanthony/surebankltd/notify"
+	"github.com/pkg/errors"
+)
+
+// Delivery modes of the notifications of a customer.
+const (
+	// NotificationDeliveryTransaction sends a message for every transaction.
+	NotificationDeliveryTransaction = "transaction"
+	// NotificationDeliveryDigest sends one message in the evening for the DS
+	// contributions of the day. Other transactions are still notified one
+	// by one.
+	NotificationDeliveryDigest = "digest"
+)
+
+// NotificationPreferences are the choices of a customer about the messages
+// sent to them. The zero value sends every message on every channel as it
+// happens.
+type NotificationPreferences struct {
+	// DisabledChannels are the channels the customer does not want messages
+	// on.
+	DisabledChannels []string `json:"disabled_channels,omitempty"`
+	// Delivery is one of the NotificationDelivery constants, transaction
+	// when empty.
+	Delivery string `json:"delivery,omitempty"`
+	// QuietFrom and QuietTo are the hours, 0 to 23, between which messages
+	// are held back, e.g. 21 and 7. Equal hours disable quiet hours.
+	QuietFrom int `json:"quiet_from"`
+	QuietTo   int `json:"quiet_to"`
+}
+
+func (p NotificationPreferences) validate() error {
+	for _, channel := range p.DisabledChannels {
+		if channel != NotificationChannelSMS && channel != NotificationChannelEmail {
+			return errors.Errorf("unknown notification channel %q", channel)
+		}
+	}
+	if p.Delivery != "" && p.Delivery != NotificationDeliveryTransaction && p.Delivery != NotificationDeliveryDigest {
+		return errors.Errorf("unknown notification delivery %q", p.Delivery)
+	}
+	if p.QuietFrom < 0 || p.QuietFrom > 23 || p.QuietTo < 0 || p.QuietTo > 23 {
+		return errors.New("quiet hours must be between 0 and 23")
+	}
+	return nil
+}
+
+// channelEnabled reports whether the customer takes messages on channel.
+func (p NotificationPreferences) channelEnabled(channel string) bool {
+	for _, c := range p.DisabledChannels {
+		if c == channel {
+			return false
+		}
+	}
+	return true
+}
+
+// digest reports whether the DS contributions are notified in the daily
+// digest.
+func (p NotificationPreferences) digest() bool {
+	return p.Delivery == NotificationDeliveryDigest
+}
+
+// quietUntil returns the end of the quiet hours from, to that t falls in, or
+// t when it is not quiet.
+func quietUntil(from, to int, t time.Time) time.Time {
+	h := t.Hour()
+	quiet := h >= from && h < to
+	if from > to {
+		quiet = h >= from || h < to
+	}
+	if !quiet {
+		return t
+	}
+	end := time.Date(t.Year(), t.Month(), t.Day(), to, 0, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// UpdateNotificationPreferencesRequest sets the language and the
+// notification preferences of a customer.
+type UpdateNotificationPreferencesRequest struct {
+	CustomerID    string                  `json:"customer_id"`
+	Language      string                  `json:"language"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+// UpdateNotificationPreferencesHTTP is an HTTP Cloud Function that sets the
+// notification preferences of a customer.
+func UpdateNotificationPreferencesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).UpdateNotificationPreferencesHTTP)
+}
+
+func (h *Handler) UpdateNotificationPreferencesHTTP(w http.ResponseWriter, r *http.Request) {
+	var req UpdateNotificationPreferencesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	customer, err := updateNotificationPreferences(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot update notification preferences, %s", err.Error())
+		return
+	}
+	sendResponse(w, customer)
+}
+
+func updateNotificationPreferences(ctx context.Context, req UpdateNotificationPreferencesRequest,
+	currentDate time.Time, store Store) (*Customer, error) {
+
+	if err := req.Notifications.validate(); err != nil {
+		return nil, err
+	}
+	if !notify.SupportedLanguage(strings.ToLower(req.Language)) {
+		return nil, errors.Errorf("unsupported language %q", req.Language)
+	}
+	var customer *Customer
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		customer, err = tx.GetCustomer(ctx, req.CustomerID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read customer, please check the ID")
+		}
+		customer.Language = strings.ToLower(req.Language)
+		customer.Notifications = req.Notifications
+		customer.UpdatedAt = currentDate.Unix()
+		tx.UpdateCustomer(*customer)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
@@ -0,0 +1,79 @@
+package surebankltd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietUntil(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 3, day, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name     string
+		from, to int
+		t, want  time.Time
+	}{
+		{"disabled", 0, 0, at(2, 3, 0), at(2, 3, 0)},
+		{"before quiet hours", 13, 15, at(2, 12, 59), at(2, 12, 59)},
+		{"in quiet hours", 13, 15, at(2, 13, 30), at(2, 15, 0)},
+		{"end of quiet hours", 13, 15, at(2, 15, 0), at(2, 15, 0)},
+		{"evening before midnight", 21, 7, at(2, 22, 15), at(3, 7, 0)},
+		{"early morning after midnight", 21, 7, at(3, 2, 0), at(3, 7, 0)},
+		{"start of quiet hours past midnight", 21, 7, at(2, 21, 0), at(3, 7, 0)},
+		{"day time past midnight", 21, 7, at(2, 12, 0), at(2, 12, 0)},
+		{"end of quiet hours past midnight", 21, 7, at(3, 7, 0), at(3, 7, 0)},
+		{"end of the month", 22, 6, at(31, 23, 0), time.Date(2026, 4, 1, 6, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		if got := quietUntil(tt.from, tt.to, tt.t); !got.Equal(tt.want) {
+			t.Errorf("%s: quietUntil(%d, %d, %s) = %s, want %s", tt.name, tt.from, tt.to, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestNotificationPreferencesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		prefs NotificationPreferences
+		ok    bool
+	}{
+		{"zero value", NotificationPreferences{}, true},
+		{"every option", NotificationPreferences{
+			DisabledChannels: []string{NotificationChannelSMS, NotificationChannelEmail},
+			Delivery:         NotificationDeliveryDigest,
+			QuietFrom:        21,
+			QuietTo:          7,
+		}, true},
+		{"transaction delivery", NotificationPreferences{Delivery: NotificationDeliveryTransaction}, true},
+		{"unknown channel", NotificationPreferences{DisabledChannels: []string{"whatsapp"}}, false},
+		{"unknown delivery", NotificationPreferences{Delivery: "weekly"}, false},
+		{"quiet hour too late", NotificationPreferences{QuietFrom: 24}, false},
+		{"negative quiet hour", NotificationPreferences{QuietTo: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.prefs.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestNotificationPreferencesChannelEnabled(t *testing.T) {
+	tests := []struct {
+		disabled []string
+		channel  string
+		want     bool
+	}{
+		{nil, NotificationChannelSMS, true},
+		{nil, NotificationChannelEmail, true},
+		{[]string{NotificationChannelSMS}, NotificationChannelSMS, false},
+		{[]string{NotificationChannelSMS}, NotificationChannelEmail, true},
+		{[]string{NotificationChannelEmail, NotificationChannelSMS}, NotificationChannelSMS, false},
+	}
+	for _, tt := range tests {
+		p := NotificationPreferences{DisabledChannels: tt.disabled}
+		if got := p.channelEnabled(tt.channel); got != tt.want {
+			t.Errorf("channelEnabled(%q) with %v disabled = %v, want %v", tt.channel, tt.disabled, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,8 @@
-gcloud functions deploy CreateCustomerHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy ListCustomerHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy FindCustomerByIdHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy CreateAccountHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy ListAccountHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy ListDSAccountHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy ListDebtorsHTTP --runtime go113 --trigger-http --allow-unauthenticated
-gcloud functions deploy FindAccountByIdHTTP --runtime go113 --trigger-http --allow-unauthenticated
\ No newline at end of file
+gcloud functions deploy CreateCustomerHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy ListCustomerHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy FindCustomerByIdHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy CreateAccountHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy ListAccountHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy ListDSAccountHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy ListDebtorsHTTP --runtime go116 --trigger-http --allow-unauthenticated
+gcloud functions deploy FindAccountByIdHTTP --runtime go116 --trigger-http --allow-unauthenticated
\ No newline at end of file
@@ -0,0 +1,370 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+// Remittance statuses.
+const (
+	// RemittanceStatusPending is a remittance submitted by a sales rep and
+	// not yet counted by a supervisor.
+	RemittanceStatusPending = "pending"
+	// RemittanceStatusConfirmed is a remittance whose cash was received.
+	RemittanceStatusConfirmed = "confirmed"
+	// RemittanceStatusRejected is a remittance whose cash was not received.
+	RemittanceStatusRejected = "rejected"
+)
+
+// maxReconciliationDays bounds the number of days of a reconciliation report.
+const maxReconciliationDays = 31
+
+// Remittance is cash collected by a sales rep on a day and handed to the
+// branch. The rep declares Amount and the supervisor confirming the
+// remittance records the ReceivedAmount actually counted.
+type Remittance struct {
+	ID             string `json:"id" truss:"api-read"`
+	SalesRepID     string `json:"sales_rep_id" validate:"required"`
+	SalesRep       string `json:"sales_rep"`
+	BranchID       string `json:"branch_id"`
+	Date           int64  `json:"date"`
+	Amount         Money  `json:"amount" validate:"required,gt=0"`
+	Note           string `json:"note"`
+	Status         string `json:"status" truss:"api-read"`
+	ReceivedAmount Money  `json:"received_amount" truss:"api-read"`
+	// Shortage is the part of Amount that was not received.
+	Shortage    Money  `json:"shortage" truss:"api-read"`
+	ReviewedBy  string `json:"reviewed_by,omitempty" truss:"api-read"`
+	ReviewNote  string `json:"review_note,omitempty" truss:"api-read"`
+	ReviewedAt  int64  `json:"reviewed_at,omitempty" truss:"api-read"`
+	SubmittedAt int64  `json:"submitted_at" truss:"api-read"`
+}
+
+// SubmitRemittanceHTTP is an HTTP Cloud Function that records the cash a
+// sales rep hands to the branch. Date is the day the cash was collected and
+// defaults to today.
+func SubmitRemittanceHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).SubmitRemittanceHTTP)
+}
+
+func (h *Handler) SubmitRemittanceHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Remittance
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.SalesRepID == "" {
+		sendError(w, "sales rep is required")
+		return
+	}
+	if req.Amount <= 0 {
+		sendError(w, "amount must be greater than zero")
+		return
+	}
+
+	currentDate := timeNow()
+	today := now.New(currentDate).BeginningOfDay()
+	if req.Date == 0 {
+		req.Date = today.Unix()
+	}
+	req.Date = now.New(time.Unix(req.Date, 0)).BeginningOfDay().Unix()
+	if req.Date > today.Unix() {
+		sendError(w, "cash cannot be remitted before it is collected")
+		return
+	}
+
+	req.ID = uuid.NewRandom().String()
+	req.Status = RemittanceStatusPending
+	req.ReceivedAmount = 0
+	req.Shortage = 0
+	req.ReviewedBy, req.ReviewNote, req.ReviewedAt = "", "", 0
+	req.SubmittedAt = currentDate.Unix()
+
+	batch := h.store.Batch()
+	batch.CreateRemittance(req)
+	if err := batch.Commit(r.Context()); err != nil {
+		log.Println(err)
+		sendError(w, "cannot save remittance")
+		return
+	}
+	sendResponse(w, req)
+}
+
+// ReviewRemittanceRequest defines the outcome of the count of a remittance.
+type ReviewRemittanceRequest struct {
+	ID string `json:"id" validate:"required"`
+	// ReceivedAmount is the cash counted, it defaults to the declared amount
+	// when a remittance is confirmed.
+	ReceivedAmount Money  `json:"received_amount"`
+	ReviewedBy     string `json:"reviewed_by" validate:"required"`
+	Note           string `json:"note"`
+}
+
+// ConfirmRemittanceHTTP is an HTTP Cloud Function that records that a
+// supervisor received the cash of a remittance.
+func ConfirmRemittanceHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ConfirmRemittanceHTTP)
+}
+
+func (h *Handler) ConfirmRemittanceHTTP(w http.ResponseWriter, r *http.Request) {
+	h.reviewRemittance(w, r, RemittanceStatusConfirmed)
+}
+
+// RejectRemittanceHTTP is an HTTP Cloud Function that records that the cash
+// of a remittance was not received.
+func RejectRemittanceHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).RejectRemittanceHTTP)
+}
+
+func (h *Handler) RejectRemittanceHTTP(w http.ResponseWriter, r *http.Request) {
+	h.reviewRemittance(w, r, RemittanceStatusRejected)
+}
+
+func (h *Handler) reviewRemittance(w http.ResponseWriter, r *http.Request, status string) {
+	var req ReviewRemittanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.ReviewedBy == "" {
+		sendError(w, "reviewed by is required")
+		return
+	}
+
+	remittance, err := reviewRemittance(r.Context(), req, status, timeNow(), h.store)
+	if err != nil {
+		sendErrorf(w, "cannot review remittance, %s", err.Error())
+		return
+	}
+	sendResponse(w, remittance)
+}
+
+// reviewRemittance confirms or rejects a pending remittance.
+func reviewRemittance(ctx context.Context, req ReviewRemittanceRequest, status string, currentDate time.Time, store Store) (*Remittance, error) {
+	if req.ReceivedAmount < 0 {
+		return nil, errors.New("the received amount cannot be negative")
+	}
+
+	var remittance *Remittance
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		var err error
+		remittance, err = tx.GetRemittance(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read remittance, please check the ID")
+		}
+		if remittance.Status != RemittanceStatusPending {
+			return errors.Errorf("the remittance has been %s", remittance.Status)
+		}
+
+		remittance.Status = status
+		remittance.ReceivedAmount = 0
+		if status == RemittanceStatusConfirmed {
+			remittance.ReceivedAmount = req.ReceivedAmount
+			if remittance.ReceivedAmount == 0 {
+				remittance.ReceivedAmount = remittance.Amount
+			}
+		}
+		remittance.Shortage = remittance.Amount - remittance.ReceivedAmount
+		remittance.ReviewedBy = req.ReviewedBy
+		remittance.ReviewNote = req.Note
+		remittance.ReviewedAt = currentDate.Unix()
+		tx.UpdateRemittance(*remittance)
+		if remittance.ReceivedAmount > 0 {
+			tx.PostJournal(remittanceJournal(*remittance, currentDate))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return remittance, nil
+}
+
+// ListRemittancesRequest defines the options to filter and page remittances.
+type ListRemittancesRequest struct {
+	SalesRepID string `json:"sales_rep_id"`
+	Status     string `json:"status"`
+	From       int64  `json:"from"`
+	To         int64  `json:"to"`
+	Limit      int    `json:"limit" example:"10"`
+	Offset     int    `json:"offset" example:"20"`
+}
+
+// ListRemittancesHTTP is an HTTP Cloud Function that lists remittances,
+// newest collection day first.
+func ListRemittancesHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ListRemittancesHTTP)
+}
+
+func (h *Handler) ListRemittancesHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ListRemittancesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	remittances, err := h.store.ListRemittances(r.Context(), RemittanceQuery{
+		SalesRepID: req.SalesRepID,
+		Status:     req.Status,
+		From:       req.From,
+		To:         req.To,
+		Limit:      req.Limit,
+		Offset:     req.Offset,
+	})
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read remittances")
+		return
+	}
+	if remittances == nil {
+		remittances = []Remittance{}
+	}
+	sendResponse(w, remittances)
+}
+
+// ReconciliationRequest selects the days and optionally the sales rep of a
+// reconciliation report. From and To default to today.
+type ReconciliationRequest struct {
+	SalesRepID string `json:"sales_rep_id"`
+	From       int64  `json:"from"`
+	To         int64  `json:"to"`
+}
+
+// RemittanceReconciliation compares the cash a sales rep collected on a day
+// with the cash the rep remitted for it.
+type RemittanceReconciliation struct {
+	SalesRepID string `json:"sales_rep_id"`
+	Date       int64  `json:"date"`
+	Collected  Money  `json:"collected"`
+	// PaidOut is the cash the sales rep paid out for withdrawals, which is
+	// not owed to the branch.
+	PaidOut Money `json:"paid_out"`
+	// Remitted is the cash received from confirmed remittances and Pending
+	// the cash declared in remittances not yet reviewed.
+	Remitted    Money `json:"remitted"`
+	Pending     Money `json:"pending"`
+	Outstanding Money `json:"outstanding"`
+	// Short is set once the day is over while cash is outstanding.
+	Short bool `json:"short"`
+}
+
+// RemittanceReconciliationHTTP is an HTTP Cloud Function that reports the
+// cash collected, remitted and outstanding per sales rep per day.
+func RemittanceReconciliationHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).RemittanceReconciliationHTTP)
+}
+
+func (h *Handler) RemittanceReconciliationHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ReconciliationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	report, err := reconcileRemittances(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendError(w, err.Error())
+		return
+	}
+	sendResponse(w, report)
+}
+
+// reconcileRemittances builds the reconciliation of every sales rep that
+// collected or remitted cash on the requested days, oldest day first.
+func reconcileRemittances(ctx context.Context, req ReconciliationRequest, currentDate time.Time, store Store) ([]RemittanceReconciliation, error) {
+	today := now.New(currentDate).BeginningOfDay()
+	from, to := today, today
+	if req.From > 0 {
+		from = now.New(time.Unix(req.From, 0)).BeginningOfDay()
+	}
+	if req.To > 0 {
+		to = now.New(time.Unix(req.To, 0)).BeginningOfDay()
+	}
+	if to.Before(from) || to.Sub(from) >= maxReconciliationDays*24*time.Hour {
+		return nil, errors.Errorf("please select between 1 and %d days", maxReconciliationDays)
+	}
+
+	remittances, err := store.ListRemittances(ctx, RemittanceQuery{
+		SalesRepID: req.SalesRepID,
+		From:       from.Unix(),
+		To:         to.Unix(),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read remittances")
+	}
+
+	type key struct {
+		day   int64
+		repID string
+	}
+	rows := map[key]*RemittanceReconciliation{}
+	row := func(day int64, repID string) *RemittanceReconciliation {
+		k := key{day, repID}
+		if rows[k] == nil {
+			rows[k] = &RemittanceReconciliation{SalesRepID: repID, Date: day}
+		}
+		return rows[k]
+	}
+	for _, rem := range remittances {
+		switch rem.Status {
+		case RemittanceStatusConfirmed:
+			row(rem.Date, rem.SalesRepID).Remitted += rem.ReceivedAmount
+		case RemittanceStatusPending:
+			row(rem.Date, rem.SalesRepID).Pending += rem.Amount
+		}
+	}
+	for day := from; !day.After(to); day = day.Add(24 * time.Hour) {
+		d := now.New(day).BeginningOfDay().Unix()
+		repIDs := []string{req.SalesRepID}
+		if req.SalesRepID == "" {
+			if repIDs, err = salesRepsOn(ctx, store, d); err != nil {
+				log.Println(err)
+				return nil, errors.New("cannot read the collections of the sales reps")
+			}
+		}
+		for _, repID := range repIDs {
+			collected, err := store.Total(ctx, statSalesRepPath(d, repID, PaymentMethod_Cash))
+			if err != nil {
+				log.Println(err)
+				return nil, errors.New("cannot read the collections of the sales reps")
+			}
+			paidOut, err := store.Total(ctx, statSalesRepCashOutPath(d, repID))
+			if err != nil {
+				log.Println(err)
+				return nil, errors.New("cannot read the collections of the sales reps")
+			}
+			if collected != 0 || paidOut != 0 {
+				row(d, repID).Collected = collected
+				row(d, repID).PaidOut = paidOut
+			}
+		}
+	}
+
+	report := []RemittanceReconciliation{}
+	for _, r := range rows {
+		r.Outstanding = r.Collected - r.PaidOut - r.Remitted
+		r.Short = r.Outstanding > 0 && r.Date < today.Unix()
+		report = append(report, *r)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Date != report[j].Date {
+			return report[i].Date < report[j].Date
+		}
+		return report[i].SalesRepID < report[j].SalesRepID
+	})
+	return report, nil
+}
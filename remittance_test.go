@@ -0,0 +1,78 @@
+package surebankltd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+func TestReconcileRemittancesNetsCashPaidOut(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00040", AccountTypeSB, 0)
+	collectedAt := time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local)
+	day := now.New(collectedAt).BeginningOfDay().Unix()
+
+	for _, p := range []Transaction{
+		{SalesRepID: "rep1", Type: TransactionType_Deposit, Amount: 1000 * Naira, PaymentMethod: PaymentMethod_Cash},
+		{SalesRepID: "rep1", Type: TransactionType_Deposit, Amount: 200 * Naira, PaymentMethod: PaymentMethod_Bank},
+		{SalesRepID: "rep2", Type: TransactionType_Deposit, Amount: 500 * Naira, PaymentMethod: PaymentMethod_Cash},
+	} {
+		p.AccountNumber = "SB00040"
+		if _, err := create(ctx, p, collectedAt, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, req := range []MakeDeductionRequest{
+		{SalesRepID: "rep1", Amount: 300 * Naira, PaymentMethod: PaymentMethod_Cash},
+		{SalesRepID: "rep1", Amount: 100 * Naira, PaymentMethod: PaymentMethod_Bank},
+	} {
+		req.AccountNumber = "SB00040"
+		if _, err := makeDeduction(ctx, req, collectedAt.Add(time.Hour), store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch := store.Batch()
+	batch.CreateRemittance(Remittance{ID: "r1", SalesRepID: "rep1", Date: day, Amount: 700 * Naira, Status: RemittanceStatusPending})
+	batch.CreateRemittance(Remittance{ID: "r2", SalesRepID: "rep2", Date: day, Amount: 500 * Naira, Status: RemittanceStatusPending})
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	reviewedAt := collectedAt.AddDate(0, 0, 1)
+	if _, err := reviewRemittance(ctx, ReviewRemittanceRequest{ID: "r1", ReviewedBy: "supervisor"}, RemittanceStatusConfirmed, reviewedAt, store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reviewRemittance(ctx, ReviewRemittanceRequest{ID: "r2", ReceivedAmount: 400 * Naira, ReviewedBy: "supervisor"},
+		RemittanceStatusConfirmed, reviewedAt, store); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := reconcileRemittances(ctx, ReconciliationRequest{From: day, To: day}, reviewedAt, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]RemittanceReconciliation{
+		"rep1": {SalesRepID: "rep1", Date: day, Collected: 1000 * Naira, PaidOut: 300 * Naira, Remitted: 700 * Naira},
+		"rep2": {SalesRepID: "rep2", Date: day, Collected: 500 * Naira, Remitted: 400 * Naira, Outstanding: 100 * Naira, Short: true},
+	}
+	if len(report) != len(want) {
+		t.Fatalf("got %d rows, want %d: %+v", len(report), len(want), report)
+	}
+	for _, row := range report {
+		if row != want[row.SalesRepID] {
+			t.Errorf("got row %+v, want %+v", row, want[row.SalesRepID])
+		}
+	}
+
+	// The same day is not short while it is still going on.
+	report, err = reconcileRemittances(ctx, ReconciliationRequest{SalesRepID: "rep2"}, collectedAt, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 1 || report[0].Outstanding != 100*Naira || report[0].Short {
+		t.Errorf("got report %+v for today", report)
+	}
+}
@@ -0,0 +1,318 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/now"
+	"github.com/pkg/errors"
+)
+
+// ReverseTransactionRequest defines the information needed to reverse a
+// transaction.
+type ReverseTransactionRequest struct {
+	ID         string `json:"id" validate:"required" example:"HQ-000000125"`
+	Reason     string `json:"reason" validate:"required"`
+	ApprovedBy string `json:"approved_by" validate:"required"`
+}
+
+// legacyArchiveReason and legacyArchiveApprover are recorded on reversals
+// requested through ArchiveTransaction by clients that only send the receipt.
+const (
+	legacyArchiveReason   = "archived"
+	legacyArchiveApprover = "archive-transaction"
+)
+
+// ArchiveTransaction is kept for existing clients, it reverses the
+// transaction. The reason and the approver are optional for these clients.
+func ArchiveTransaction(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ArchiveTransaction)
+}
+
+func (h *Handler) ArchiveTransaction(w http.ResponseWriter, r *http.Request) {
+	var req ReverseTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = legacyArchiveReason
+	}
+	if req.ApprovedBy == "" {
+		req.ApprovedBy = legacyArchiveApprover
+	}
+	h.reverse(w, r, req)
+}
+
+// ReverseTransactionHTTP is an HTTP Cloud Function that reverses a
+// transaction with a compensating transaction.
+func ReverseTransactionHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ReverseTransactionHTTP)
+}
+
+func (h *Handler) ReverseTransactionHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ReverseTransactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.Reason == "" || req.ApprovedBy == "" {
+		sendError(w, "please provide the reason and the approver of the reversal")
+		return
+	}
+	h.reverse(w, r, req)
+}
+
+func (h *Handler) reverse(w http.ResponseWriter, r *http.Request, req ReverseTransactionRequest) {
+	reversal, err := reverseTransaction(r.Context(), req, timeNow(), h.store)
+	if err != nil {
+		sendError(w, err.Error())
+		return
+	}
+	sendResponse(w, reversal)
+}
+
+// reverseTransaction posts a transaction that cancels the effect of the
+// transaction of req.ID on the account balance and the ledger. Reversing a DS
+// deposit that took the DS fee also reverses the fee and the commission.
+func reverseTransaction(ctx context.Context, req ReverseTransactionRequest, currentDate time.Time, store Store) (*Transaction, error) {
+	original, err := getTransactionByReceiptNumber(ctx, req.ID, store)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read transaction, please check the receipt number")
+	}
+	account, err := getAccountByNumber(ctx, original.AccountNumber, store)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot map account data")
+	}
+
+	receipts := make([]string, 1, 2)
+	if original.FeeReceiptNo != "" {
+		receipts = append(receipts, "")
+	}
+	for i := range receipts {
+		if receipts[i], err = generateReceiptNumber(ctx, store, account.BranchID); err != nil {
+			log.Println(err)
+			return nil, fmt.Errorf("error in generating receipt number, %s", err.Error())
+		}
+	}
+
+	var reversal Transaction
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		original, err := tx.GetTransaction(ctx, req.ID)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read transaction, please check the receipt number")
+		}
+		switch {
+		case original.ReversalOf != "":
+			return errors.New("a reversal cannot be reversed")
+		case original.ReversedBy != "":
+			return errors.Errorf("this transaction has been reversed by %s", original.ReversedBy)
+		case original.ArchivedAt > 0:
+			return errors.New("this transaction has been archived")
+		}
+
+		account, err := tx.GetAccount(ctx, original.AccountNumber)
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot map account data")
+		}
+		if err = checkDayOpen(ctx, tx, account.BranchID, currentDate); err != nil {
+			return err
+		}
+
+		reversed := []Transaction{*original}
+		if original.FeeReceiptNo != "" {
+			fee, err := tx.GetTransaction(ctx, original.FeeReceiptNo)
+			if err != nil {
+				log.Println(err)
+				return errors.New("cannot read the DS fee of the transaction")
+			}
+			if fee.ReversedBy == "" {
+				reversed = append(reversed, *fee)
+			}
+		}
+
+		// Deposits and their fee share the commission.
+		var commission *DSCommission
+		if original.CommissionID != "" {
+			commission, err = tx.GetCommission(ctx, original.CommissionID)
+			if err != nil {
+				log.Println(err)
+				return errors.New("cannot read the commission of the transaction")
+			}
+			if commission.ReversedAt > 0 {
+				commission = nil
+			}
+		}
+
+		var cycle *DSCycle
+		if original.CycleID != "" {
+			cycle, err = tx.GetCycle(ctx, original.CycleID)
+			if err != nil {
+				log.Println(err)
+				return errors.New("cannot read the DS cycle of the transaction")
+			}
+			if cycle.Status == DSCycleStatusClosed && original.Kind != TransactionKind_Payout {
+				return errors.Errorf("the DS cycle of this transaction has been paid out with %s", cycle.PayoutReceiptNo)
+			}
+		}
+
+		recent, err := tx.ListTransactions(ctx, TransactionQuery{
+			AccountNumber: account.Number,
+			Limit:         4 * maxRecentTransactions,
+		})
+		if err != nil {
+			log.Println(err)
+			return errors.New("cannot read recent transactions")
+		}
+
+		for i, o := range reversed {
+			r := reversalOf(o, receipts[i], req, currentDate)
+			if o.Type == TransactionType_Deposit {
+				account.Balance -= o.Amount
+			} else {
+				account.Balance += o.Amount
+			}
+			r.Balance = account.Balance
+			if o.ReceiptNo == original.ReceiptNo {
+				reversal = r
+			}
+
+			tx.CreateTransaction(r)
+			tx.ReverseTransaction(o.ReceiptNo, r.ReceiptNo, currentDate.Unix())
+			tx.PostJournal(transactionJournal(o, account.Type, time.Unix(o.CreatedAt, 0)).
+				reverse(r.ReceiptNo, r.Narration, currentDate))
+
+			if cycle != nil {
+				switch {
+				case o.Kind == TransactionKind_Payout:
+					reopenCycle(cycle, account)
+				case o.Type == TransactionType_Deposit:
+					cycle.reverse(o.Amount, now.New(time.Unix(o.EffectiveDate, 0)).BeginningOfDay().Unix())
+				}
+			}
+
+			// The reversal is part of today's summary while the stats of the
+			// original day stop counting the transaction.
+			recordDailySummary(tx, account.BranchID, currentDate, o, -1)
+			globalBalance := o.Amount
+			if o.Type == TransactionType_Deposit {
+				globalBalance *= -1
+			}
+			// global balance
+			tx.IncrementTotal(statGlobalBalancePath(account.Type), globalBalance)
+			// The fee is not part of the stats of the deposit that took it.
+			if o.Kind != TransactionKind_Fee {
+				recordTransactionStats(tx, o, -1)
+			}
+		}
+		if account.Balance < 0 {
+			return errors.New("insufficient fund to reverse the transaction")
+		}
+
+		if commission != nil {
+			tx.ReverseCommission(commission.ID, currentDate.Unix())
+			recordCommissionStats(tx, *commission, -1)
+			// The commission date of accounts without cycles is the date of
+			// their last fee.
+			if cycle == nil && account.LastCommissionDate == commission.EffectiveDate {
+				account.LastCommissionDate = commission.PreviousCommissionDate
+			}
+			if cycle != nil && cycle.CommissionID == commission.ID {
+				cycle.FeeTaken -= commission.Amount
+				cycle.CommissionID = ""
+				cycle.AmountPayable = cycle.AmountPaid - cycle.FeeTaken
+			}
+		}
+		if cycle != nil {
+			// The next contribution starts a new cycle.
+			if cycle.Status == DSCycleStatusCancelled && account.CurrentCycleID == cycle.ID {
+				account.CurrentCycleID = ""
+				account.LastCommissionDate = 0
+			}
+			cycle.UpdatedAt = currentDate.Unix()
+			tx.UpdateCycle(*cycle)
+		}
+
+		account.RecentTransactions = recentTransactions(recent, reversed)
+		if original.Type == TransactionType_Deposit {
+			for _, t := range account.RecentTransactions {
+				if t.Type == TransactionType_Deposit {
+					account.LastPaymentDate = t.EffectiveDate
+					break
+				}
+			}
+		}
+		tx.UpdateAccount(*account)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reversal, nil
+}
+
+// reopenCycle undoes the payout of a cycle. The cycle receives contributions
+// again unless the account has moved on to a newer cycle.
+func reopenCycle(cycle *DSCycle, account *Account) {
+	cycle.PaidOut = 0
+	cycle.PayoutReceiptNo = ""
+	cycle.ClosedAt = 0
+	cycle.Status = DSCycleStatusCompleted
+	if account.CurrentCycleID == "" {
+		cycle.Status = DSCycleStatusOpen
+		account.CurrentCycleID = cycle.ID
+		account.LastCommissionDate = cycle.StartDate
+	}
+}
+
+// reversalOf returns the transaction that reverses tx.
+func reversalOf(tx Transaction, receiptNo string, req ReverseTransactionRequest, at time.Time) Transaction {
+	r := tx
+	r.ReceiptNo = receiptNo
+	r.Type = TransactionType_Deposit
+	if tx.Type == TransactionType_Deposit {
+		r.Type = TransactionType_Withdrawal
+	}
+	r.Narration = "Reversal of " + tx.ReceiptNo
+	r.Kind = ""
+	r.FeeReceiptNo = ""
+	r.CommissionID = ""
+	r.CycleID = ""
+	r.ReversalOf = tx.ReceiptNo
+	r.Reason = req.Reason
+	r.ApprovedBy = req.ApprovedBy
+	r.CreatedAt = at.Unix()
+	r.UpdatedAt = at.Unix()
+	r.IdempotencyKey = ""
+	return r
+}
+
+// recentTransactions returns the latest transactions of the account, newest
+// first, leaving out DS fees, reversals and the transactions they reverse.
+func recentTransactions(transactions, reversed []Transaction) []Transaction {
+	skip := map[string]bool{}
+	for _, t := range reversed {
+		skip[t.ReceiptNo] = true
+	}
+	recent := []Transaction{}
+	for _, t := range transactions {
+		if skip[t.ReceiptNo] || t.ReversedBy != "" || t.ReversalOf != "" || t.Kind == TransactionKind_Fee {
+			continue
+		}
+		recent = append(recent, t)
+		if len(recent) == maxRecentTransactions {
+			break
+		}
+	}
+	return recent
+}
@@ -0,0 +1,53 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestArchiveTransactionDefaultsReasonAndApprover(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00020", AccountTypeSB, 0)
+	deposit, err := create(ctx, Transaction{
+		AccountNumber: "SB00020",
+		Type:          TransactionType_Deposit,
+		Amount:        250 * Naira,
+		PaymentMethod: PaymentMethod_Cash,
+	}, timeNow(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(store)
+	w := httptest.NewRecorder()
+	h.ArchiveTransaction(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"id":"`+deposit.ReceiptNo+`"}`)))
+	var resp struct {
+		Success bool
+		Data    Transaction
+	}
+	if err = json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success {
+		t.Fatalf("got response %s, %v", w.Body, err)
+	}
+	if resp.Data.ReversalOf != deposit.ReceiptNo || resp.Data.Reason != legacyArchiveReason ||
+		resp.Data.ApprovedBy != legacyArchiveApprover {
+		t.Errorf("got reversal %+v", resp.Data)
+	}
+	account, err := store.GetAccount(ctx, "SB00020")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 0 || resp.Data.Balance != account.Balance {
+		t.Errorf("balance = %s and reversal balance = %s after archiving the only deposit",
+			account.Balance, resp.Data.Balance)
+	}
+
+	w = httptest.NewRecorder()
+	h.ReverseTransactionHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"id":"`+deposit.ReceiptNo+`"}`)))
+	if strings.Contains(w.Body.String(), `"success":true`) {
+		t.Errorf("a reversal without reason and approver succeeded: %s", w.Body)
+	}
+}
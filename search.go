@@ -0,0 +1,527 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Limits of a customer search.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// maxSearchKeys is the most values Firestore takes in an
+	// array-contains-any filter.
+	maxSearchKeys = 10
+	// searchCandidates is the number of index entries read per result
+	// wanted when the entries are filtered after they are read.
+	searchCandidates = 5
+)
+
+// Namespaces of the keys of the search index.
+const (
+	searchKeyName    = "n:"
+	searchKeyPhone   = "t:"
+	searchKeyAccount = "a:"
+	searchKeyTrigram = "g:"
+)
+
+// CustomerSearchEntry is the search index document of a customer. It is
+// rewritten whenever the customer or its accounts change.
+type CustomerSearchEntry struct {
+	CustomerID     string   `json:"customer_id"`
+	Name           string   `json:"name"`
+	ShortName      string   `json:"short_name"`
+	PhoneNumber    string   `json:"phone_number"`
+	AccountNumbers []string `json:"account_numbers"`
+	SalesRepID     string   `json:"sales_rep_id"`
+	Archived       bool     `json:"archived"`
+	// Keys are the prefixes of the names, phone number and account numbers
+	// and the trigrams of the names the customer is found by.
+	Keys      []string `json:"-"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// customerSearchEntry returns the search index entry of customer, whose
+// accounts are accountNumbers.
+func customerSearchEntry(customer Customer, accountNumbers []string) CustomerSearchEntry {
+	keys := map[string]bool{}
+	for _, token := range append(nameTokens(customer.Name), nameTokens(customer.ShortName)...) {
+		for _, prefix := range prefixes(token, 1) {
+			keys[searchKeyName+prefix] = true
+		}
+		for _, trigram := range trigrams(token) {
+			keys[searchKeyTrigram+trigram] = true
+		}
+	}
+	for _, prefix := range prefixes(searchPhoneNumber(customer.PhoneNumber), 3) {
+		keys[searchKeyPhone+prefix] = true
+	}
+	for _, number := range accountNumbers {
+		number = strings.ToLower(number)
+		for _, prefix := range prefixes(number, 2) {
+			keys[searchKeyAccount+prefix] = true
+		}
+		if i := strings.IndexFunc(number, unicode.IsDigit); i > 0 {
+			for _, prefix := range prefixes(strings.TrimLeft(number[i:], "0"), 3) {
+				keys[searchKeyAccount+prefix] = true
+			}
+		}
+	}
+
+	entry := CustomerSearchEntry{
+		CustomerID:     customer.ID,
+		Name:           customer.Name,
+		ShortName:      customer.ShortName,
+		PhoneNumber:    customer.PhoneNumber,
+		AccountNumbers: accountNumbers,
+		SalesRepID:     customer.SalesRepID,
+		Archived:       customer.ArchivedAt > 0,
+		UpdatedAt:      customer.UpdatedAt,
+	}
+	for key := range keys {
+		entry.Keys = append(entry.Keys, key)
+	}
+	sort.Strings(entry.Keys)
+	return entry
+}
+
+// accountNumbers returns the numbers of accounts.
+func accountNumbers(accounts []Account) []string {
+	numbers := make([]string, len(accounts))
+	for i, account := range accounts {
+		numbers[i] = account.Number
+	}
+	return numbers
+}
+
+// hookedLetters maps the letters of Hausa to the letters they are typed as.
+var hookedLetters = strings.NewReplacer("ɓ", "b", "ɗ", "d", "ƙ", "k", "ƴ", "y")
+
+// nameTokens returns the words of a name in lower case without accents, so
+// that Adébáyọ̀ matches adebayo.
+func nameTokens(name string) []string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Fields(hookedLetters.Replace(b.String()))
+}
+
+// searchPhoneNumber returns the digits of a Nigerian phone number without
+// the country code or the trunk prefix, so that 08031234567, +2348031234567
+// and 234 803 123 4567 are all 8031234567.
+func searchPhoneNumber(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if strings.HasPrefix(digits, "234") && len(digits) > 10 {
+		digits = digits[3:]
+	}
+	return strings.TrimLeft(digits, "0")
+}
+
+// prefixes returns the prefixes of s of at least min runes.
+func prefixes(s string, min int) []string {
+	runes := []rune(s)
+	var list []string
+	for n := min; n <= len(runes); n++ {
+		list = append(list, string(runes[:n]))
+	}
+	return list
+}
+
+// trigrams returns the runs of three runes of s.
+func trigrams(s string) []string {
+	runes := []rune(s)
+	var list []string
+	for i := 0; i+3 <= len(runes); i++ {
+		list = append(list, string(runes[i:i+3]))
+	}
+	return list
+}
+
+// SearchCustomersRequest looks customers up by a part of their name, short
+// name, phone number or account number.
+type SearchCustomersRequest struct {
+	Query           string `json:"query" example:"adebayo"`
+	SalesRepID      string `json:"sales_rep_id"`
+	IncludeArchived bool   `json:"include_archived"`
+	Limit           int    `json:"limit" example:"20"`
+	// Offset skips the first results. A search only goes as far as the
+	// first maxSearchLimit results.
+	Offset int `json:"offset" example:"20"`
+}
+
+// CustomerSearchResult is a customer found by a search. Fuzzy results match
+// a name with spelling mistakes and come after the exact matches.
+type CustomerSearchResult struct {
+	CustomerSearchEntry
+	Fuzzy bool `json:"fuzzy,omitempty"`
+}
+
+// SearchCustomersHTTP is an HTTP Cloud Function that searches customers by
+// name, short name, phone number or account number.
+func SearchCustomersHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).SearchCustomersHTTP)
+}
+
+func (h *Handler) SearchCustomersHTTP(w http.ResponseWriter, r *http.Request) {
+	var req SearchCustomersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	results, err := searchCustomers(r.Context(), req, h.store)
+	if err != nil {
+		sendErrorf(w, "cannot search customers, %s", err.Error())
+		return
+	}
+	if results == nil {
+		results = []CustomerSearchResult{}
+	}
+	sendResponse(w, results)
+}
+
+// searchCustomers returns up to req.Limit of the customers matching
+// req.Query, skipping the first req.Offset of them.
+func searchCustomers(ctx context.Context, req SearchCustomersRequest, store Store) ([]CustomerSearchResult, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	if req.Offset < 0 {
+		return nil, errors.New("offset cannot be negative")
+	}
+	if req.Offset+limit > maxSearchLimit {
+		return nil, errors.Errorf("a search lists the first %d customers only", maxSearchLimit)
+	}
+
+	results, err := matchCustomers(ctx, req, req.Offset+limit, store)
+	if err != nil {
+		return nil, err
+	}
+	start, end := pageBounds(len(results), req.Offset, limit)
+	return results[start:end], nil
+}
+
+// matchCustomers returns the first limit customers matching req.Query. A
+// query of digits is looked up in the phone and account numbers, a word with
+// digits in the account numbers and anything else in the names, every word
+// of the query starting a word of the name or short name. Names are matched
+// with spelling mistakes when there are not enough exact matches.
+func matchCustomers(ctx context.Context, req SearchCustomersRequest, limit int, store Store) ([]CustomerSearchResult, error) {
+	query := CustomerSearchQuery{SalesRepID: req.SalesRepID, IncludeArchived: req.IncludeArchived, Limit: limit}
+
+	text := strings.TrimSpace(req.Query)
+	tokens := nameTokens(text)
+	if len(tokens) == 0 {
+		return nil, errors.New("query is required")
+	}
+
+	if strings.Trim(text, "0123456789+-() ") == "" {
+		// The digits of an account number are indexed without their
+		// leading zeros. A part of a number starting with the country code
+		// is too short to tell from a number without it, so both are tried.
+		digits := strings.Join(tokens, "")
+		if number := strings.TrimLeft(digits, "0"); number != "" {
+			query.Keys = append(query.Keys, searchKeyAccount+number)
+		}
+		phones := []string{searchPhoneNumber(digits)}
+		if strings.HasPrefix(digits, "234") {
+			phones = append(phones, searchPhoneNumber(digits[3:]))
+		}
+		for _, phone := range phones {
+			if len(phone) >= 3 {
+				query.Keys = append(query.Keys, searchKeyPhone+phone)
+			}
+		}
+		if len(query.Keys) == 0 {
+			return nil, nil
+		}
+		return readSearchResults(ctx, store, query, nil)
+	}
+	if len(tokens) == 1 && strings.IndexFunc(tokens[0], unicode.IsDigit) >= 0 {
+		query.Keys = []string{searchKeyAccount + tokens[0]}
+		return readSearchResults(ctx, store, query, nil)
+	}
+
+	// The longest word selects the fewest entries, the others are checked
+	// on the entries read.
+	longest := tokens[0]
+	for _, token := range tokens {
+		if len(token) > len(longest) {
+			longest = token
+		}
+	}
+	query.Keys = []string{searchKeyName + longest}
+	query.Limit = limit * searchCandidates
+	results, err := readSearchResults(ctx, store, query, func(entry CustomerSearchEntry) (searchRank, bool) {
+		return searchRank{}, matchesName(entry, tokens)
+	})
+	if err != nil || len(results) >= limit {
+		return truncateResults(results, limit), err
+	}
+
+	// The index returns the entries having any of the trigrams in the order
+	// of their IDs, so an entry sharing many trigrams with the query may be
+	// left out of the candidates read when more than query.Limit entries
+	// share one of them. The candidates read are ranked by their distance
+	// to the query and then by the number of trigrams they share with it.
+	var grams []string
+	for _, token := range tokens {
+		for _, trigram := range trigrams(token) {
+			grams = append(grams, searchKeyTrigram+trigram)
+		}
+	}
+	if len(grams) == 0 {
+		return results, nil
+	}
+	query.Keys = grams
+	if len(query.Keys) > maxSearchKeys {
+		query.Keys = query.Keys[:maxSearchKeys]
+	}
+	fuzzy, err := readSearchResults(ctx, store, query, func(entry CustomerSearchEntry) (searchRank, bool) {
+		var rank searchRank
+		for _, token := range tokens {
+			d := nameDistance(entry, token)
+			if d > allowedEdits(token) {
+				return rank, false
+			}
+			rank.distance += d
+		}
+		rank.shared = sharedKeys(entry, grams)
+		return rank, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, result := range results {
+		found[result.CustomerID] = true
+	}
+	for _, result := range fuzzy {
+		if !found[result.CustomerID] {
+			result.Fuzzy = true
+			results = append(results, result)
+		}
+	}
+	return truncateResults(results, limit), nil
+}
+
+// searchRank orders the results of a search, the smallest distance first
+// and then the most keys shared with the query.
+type searchRank struct {
+	distance int
+	shared   int
+}
+
+func (r searchRank) less(o searchRank) bool {
+	if r.distance != o.distance {
+		return r.distance < o.distance
+	}
+	return r.shared > o.shared
+}
+
+// sharedKeys returns the number of keys that are keys of entry.
+func sharedKeys(entry CustomerSearchEntry, keys []string) int {
+	has := map[string]bool{}
+	for _, key := range entry.Keys {
+		has[key] = true
+	}
+	var n int
+	for _, key := range keys {
+		if has[key] {
+			n++
+		}
+	}
+	return n
+}
+
+// readSearchResults reads the entries of query and keeps those accepted by
+// match, sorted by the rank it returns and then by name. A nil match
+// accepts every entry.
+func readSearchResults(ctx context.Context, store Store, query CustomerSearchQuery,
+	match func(entry CustomerSearchEntry) (searchRank, bool)) ([]CustomerSearchResult, error) {
+
+	entries, err := store.SearchCustomers(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, errors.New("cannot read the search index")
+	}
+	var results []CustomerSearchResult
+	ranks := map[string]searchRank{}
+	for _, entry := range entries {
+		if match != nil {
+			rank, ok := match(entry)
+			if !ok {
+				continue
+			}
+			ranks[entry.CustomerID] = rank
+		}
+		results = append(results, CustomerSearchResult{CustomerSearchEntry: entry})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if ri, rj := ranks[results[i].CustomerID], ranks[results[j].CustomerID]; ri != rj {
+			return ri.less(rj)
+		}
+		return strings.ToLower(results[i].Name) < strings.ToLower(results[j].Name)
+	})
+	return results, nil
+}
+
+func truncateResults(results []CustomerSearchResult, limit int) []CustomerSearchResult {
+	if len(results) > limit {
+		return results[:limit]
+	}
+	return results
+}
+
+// matchesName reports whether every token starts a word of the name or the
+// short name of entry.
+func matchesName(entry CustomerSearchEntry, tokens []string) bool {
+	words := append(nameTokens(entry.Name), nameTokens(entry.ShortName)...)
+	for _, token := range tokens {
+		var ok bool
+		for _, word := range words {
+			if strings.HasPrefix(word, token) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// nameDistance returns the smallest number of edits turning token into a
+// word, or the start of a word, of the name or the short name of entry.
+func nameDistance(entry CustomerSearchEntry, token string) int {
+	best := -1
+	for _, word := range append(nameTokens(entry.Name), nameTokens(entry.ShortName)...) {
+		d := editDistance(token, word)
+		if runes := []rune(word); len(runes) > len([]rune(token)) {
+			if p := editDistance(token, string(runes[:len([]rune(token))])); p < d {
+				d = p
+			}
+		}
+		if best < 0 || d < best {
+			best = d
+		}
+	}
+	if best < 0 {
+		return len(token)
+	}
+	return best
+}
+
+// allowedEdits returns the number of spelling mistakes tolerated in token.
+func allowedEdits(token string) int {
+	switch n := len([]rune(token)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// ReindexCustomersHTTP is an HTTP Cloud Function that rebuilds the search
+// index entries of every customer, e.g. for the customers created before
+// the index existed.
+func ReindexCustomersHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ReindexCustomersHTTP)
+}
+
+func (h *Handler) ReindexCustomersHTTP(w http.ResponseWriter, r *http.Request) {
+	const pageSize = 200
+	var indexed int
+	for offset := 0; ; offset += pageSize {
+		customers, err := h.store.ListCustomers(r.Context(), FindCustomerRequest{
+			IncludeArchived: true,
+			Limit:           pageSize,
+			Offset:          offset,
+		})
+		if err != nil {
+			log.Println(err)
+			sendError(w, "cannot read customers")
+			return
+		}
+		batch := h.store.Batch()
+		for _, customer := range customers {
+			accounts, err := h.store.ListAccounts(r.Context(), AccountQuery{CustomerID: customer.ID})
+			if err != nil {
+				log.Println(err)
+				sendErrorf(w, "cannot read the accounts of %s", customer.Name)
+				return
+			}
+			batch.SetCustomerSearchEntry(customerSearchEntry(customer, accountNumbers(accounts)))
+		}
+		if err := batch.Commit(r.Context()); err != nil {
+			log.Println(err)
+			sendError(w, "cannot write the search index")
+			return
+		}
+		indexed += len(customers)
+		if len(customers) < pageSize {
+			break
+		}
+	}
+	sendResponse(w, indexed)
+}
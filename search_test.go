@@ -0,0 +1,214 @@
+package surebankltd
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchPhoneNumber(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"08031234567", "8031234567"},
+		{"+2348031234567", "8031234567"},
+		{"234 803 123 4567", "8031234567"},
+		{"(0803) 123-4567", "8031234567"},
+		{"2348031234567", "8031234567"},
+		// Too short to hold a country code.
+		{"2345", "2345"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := searchPhoneNumber(tt.phone); got != tt.want {
+			t.Errorf("searchPhoneNumber(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+}
+
+func TestNameTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Adébáyọ̀ Ògúnlẹ́sì", []string{"adebayo", "ogunlesi"}},
+		{"Chiọma Nwáńkwọ", []string{"chioma", "nwankwo"}},
+		{"Ɓala Ɗanjuma", []string{"bala", "danjuma"}},
+		{"ƙasim ƴar", []string{"kasim", "yar"}},
+		{"Mary-Jane  O'Neil", []string{"mary", "jane", "o", "neil"}},
+		{"Shop 2 Traders", []string{"shop", "2", "traders"}},
+		{"  ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := nameTokens(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nameTokens(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "ade", 3},
+		{"adebayo", "adebayo", 0},
+		{"adebayo", "adebayor", 1},
+		{"adebayo", "adabayo", 1},
+		{"adebayo", "adeyabo", 2},
+		{"kitten", "sitting", 3},
+		{"ọla", "ola", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := editDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+
+	for token, want := range map[string]int{
+		"ade":       0,
+		"bola":      1,
+		"chinedu":   1,
+		"oluwaseun": 2,
+		"ọlá":       0,
+	} {
+		if got := allowedEdits(token); got != want {
+			t.Errorf("allowedEdits(%q) = %d, want %d", token, got, want)
+		}
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	tests := []struct {
+		s    string
+		min  int
+		want []string
+	}{
+		{"ade", 1, []string{"a", "ad", "ade"}},
+		{"8031", 3, []string{"803", "8031"}},
+		{"80", 3, nil},
+		{"sb12", 2, []string{"sb", "sb1", "sb12"}},
+		{"ọla", 2, []string{"ọl", "ọla"}},
+		{"", 1, nil},
+	}
+	for _, tt := range tests {
+		if got := prefixes(tt.s, tt.min); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("prefixes(%q, %d) = %q, want %q", tt.s, tt.min, got, tt.want)
+		}
+	}
+}
+
+// newSearchTestCustomers indexes a customer with one account for every name.
+func newSearchTestCustomers(t *testing.T, store Store, names ...string) {
+	t.Helper()
+	batch := store.Batch()
+	for i, name := range names {
+		customer := Customer{
+			ID:          fmt.Sprintf("customer-%02d", i),
+			Name:        name,
+			PhoneNumber: fmt.Sprintf("080300000%02d", i),
+		}
+		number := fmt.Sprintf("SB000000%02d", i)
+		batch.CreateCustomer(customer)
+		batch.CreateAccount(Account{Number: number, CustomerID: customer.ID, Type: AccountTypeSB})
+		batch.SetCustomerSearchEntry(customerSearchEntry(customer, []string{number}))
+	}
+	if err := batch.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchCustomers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newSearchTestCustomers(t, store,
+		"Adebayo Ogunlesi", "Adebola Ade", "Bola Adebayo", "Chioma Nwankwo",
+		"Adeyabo Musa", "Adebayor Sani", "Adebayo Bello",
+	)
+	search := func(req SearchCustomersRequest) []string {
+		t.Helper()
+		results, err := searchCustomers(ctx, req, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range results {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		req  SearchCustomersRequest
+		want []string
+	}{
+		{SearchCustomersRequest{Query: "adebayo"}, []string{"Adebayo Bello", "Adebayo Ogunlesi", "Adebayor Sani", "Bola Adebayo"}},
+		{SearchCustomersRequest{Query: "adebayo", Limit: 2}, []string{"Adebayo Bello", "Adebayo Ogunlesi"}},
+		{SearchCustomersRequest{Query: "adebayo", Limit: 2, Offset: 2}, []string{"Adebayor Sani", "Bola Adebayo"}},
+		{SearchCustomersRequest{Query: "adebayo", Offset: 10}, nil},
+		{SearchCustomersRequest{Query: "bola ade"}, []string{"Bola Adebayo"}},
+		{SearchCustomersRequest{Query: "+234 803 000 0003"}, []string{"Chioma Nwankwo"}},
+		{SearchCustomersRequest{Query: "sb00000003"}, []string{"Chioma Nwankwo"}},
+		{SearchCustomersRequest{Query: "nwankwa"}, []string{"Chioma Nwankwo"}},
+	}
+	for _, tt := range tests {
+		if got := search(tt.req); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %+v = %q, want %q", tt.req, got, tt.want)
+		}
+	}
+
+	for _, req := range []SearchCustomersRequest{
+		{Query: "ade", Offset: -1},
+		{Query: "ade", Offset: maxSearchLimit},
+		{Query: " - "},
+	} {
+		if _, err := searchCustomers(ctx, req, store); err == nil {
+			t.Errorf("search %+v was accepted", req)
+		}
+	}
+}
+
+func TestFuzzySearchRanksBySharedTrigrams(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	// Both names are one edit from the query, the second one shares more
+	// of its trigrams.
+	newSearchTestCustomers(t, store, "Olaaale Kunle", "Olawalu Kunle")
+
+	results, err := searchCustomers(ctx, SearchCustomersRequest{Query: "olawale"}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Name != "Olawalu Kunle" || !results[0].Fuzzy {
+		t.Errorf("got results %+v", results)
+	}
+}
+
+func TestSearchCustomerList(t *testing.T) {
+	store := NewMemoryStore()
+	newSearchTestCustomers(t, store, "Adebayo Ogunlesi", "Bola Adebayo", "Adebayo Bello")
+
+	w := httptest.NewRecorder()
+	body := `{"args":["adebayo"],"limit":2,"offset":1}`
+	NewHandler(store).ListCustomerHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if strings.Contains(w.Body.String(), "total_count") {
+		t.Errorf("a search claims a total count: %s", w.Body)
+	}
+	var got []string
+	for _, id := range []string{"customer-00", "customer-01", "customer-02"} {
+		if strings.Contains(w.Body.String(), `"id":"`+id+`"`) {
+			got = append(got, id)
+		}
+	}
+	if want := []string{"customer-00", "customer-01"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %q, want %q: %s", got, want, w.Body)
+	}
+}
@@ -0,0 +1,238 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSequenceBlockSize is the number of sequence values an instance
+	// reserves at once when SEQUENCE_BLOCK_SIZE is not set.
+	defaultSequenceBlockSize = 10
+	// receiptSequenceDigits is the zero padded width of the receipt sequence.
+	receiptSequenceDigits = 8
+	// maxReceiptAuditRange bounds the number of receipts checked by one audit.
+	maxReceiptAuditRange = 1000
+)
+
+// Sequence is a named monotonic counter.
+type Sequence struct {
+	Name string `json:"name"`
+	Next int64  `json:"next"`
+}
+
+// SequenceBlock records a range of sequence values reserved by an instance.
+// Values of a block that were never used show up as gaps in an audit.
+type SequenceBlock struct {
+	Sequence   string `json:"sequence"`
+	Start      int64  `json:"start"`
+	End        int64  `json:"end"`
+	ReservedAt int64  `json:"reserved_at"`
+}
+
+// sequenceBlockSize returns the configured block size.
+func sequenceBlockSize() int64 {
+	if v := os.Getenv("SEQUENCE_BLOCK_SIZE"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && size > 0 {
+			return size
+		}
+		log.Printf("invalid SEQUENCE_BLOCK_SIZE %q, using %d", v, defaultSequenceBlockSize)
+	}
+	return defaultSequenceBlockSize
+}
+
+// sequenceAllocator hands out sequence values from blocks reserved through
+// reserve so that the shared sequence document is only written once per block.
+type sequenceAllocator struct {
+	mu        sync.Mutex
+	blockSize int64
+	reserve   func(ctx context.Context, name string, size int64) (int64, error)
+	blocks    map[string]*sequenceRange
+}
+
+type sequenceRange struct {
+	next, end int64
+}
+
+func newSequenceAllocator(blockSize int64, reserve func(ctx context.Context, name string, size int64) (int64, error)) *sequenceAllocator {
+	return &sequenceAllocator{
+		blockSize: blockSize,
+		reserve:   reserve,
+		blocks:    map[string]*sequenceRange{},
+	}
+}
+
+// next returns the next value of the named sequence.
+func (a *sequenceAllocator) next(ctx context.Context, name string) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	block, ok := a.blocks[name]
+	if !ok || block.next > block.end {
+		start, err := a.reserve(ctx, name, a.blockSize)
+		if err != nil {
+			return 0, err
+		}
+		block = &sequenceRange{next: start, end: start + a.blockSize - 1}
+		a.blocks[name] = block
+	}
+	value := block.next
+	block.next++
+	return value, nil
+}
+
+// luhnCheckDigit returns the Luhn check digit of a string of digits.
+func luhnCheckDigit(digits string) int {
+	var sum int
+	double := true
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// validLuhn reports whether the last digit of digits is its Luhn check digit.
+func validLuhn(digits string) bool {
+	if len(digits) < 2 || !isDigits(digits) {
+		return false
+	}
+	return luhnCheckDigit(digits[:len(digits)-1]) == int(digits[len(digits)-1]-'0')
+}
+
+// receiptPrefix returns the receipt prefix of a branch, made of the first
+// letters and digits of its ID. Receipts of transactions without a branch
+// use HQ.
+func receiptPrefix(branchID string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(branchID) {
+		if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			b.WriteRune(c)
+		}
+		if b.Len() == 4 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "HQ"
+	}
+	return b.String()
+}
+
+// receiptSequenceName returns the name of the receipt sequence of a prefix.
+func receiptSequenceName(prefix string) string {
+	return "receipt-" + prefix
+}
+
+// formatReceiptNumber returns the receipt number of a sequence value, e.g.
+// HQ-000000125 where the final 5 is the check digit of 00000012.
+func formatReceiptNumber(prefix string, seq int64) string {
+	digits := fmt.Sprintf("%0*d", receiptSequenceDigits, seq)
+	return fmt.Sprintf("%s-%s%d", prefix, digits, luhnCheckDigit(digits))
+}
+
+// isLegacyReceiptNumber reports whether receipt has the random TX format used
+// before sequential receipts.
+func isLegacyReceiptNumber(receipt string) bool {
+	return len(receipt) == 8 && strings.HasPrefix(receipt, "TX") && isDigits(receipt[2:])
+}
+
+// validReceiptNumber reports whether receipt is a legacy receipt number or a
+// sequential receipt number with a valid check digit.
+func validReceiptNumber(receipt string) bool {
+	if isLegacyReceiptNumber(receipt) {
+		return true
+	}
+	i := strings.LastIndexByte(receipt, '-')
+	if i <= 0 {
+		return false
+	}
+	return len(receipt)-i-1 > receiptSequenceDigits && validLuhn(receipt[i+1:])
+}
+
+// ReceiptAuditRequest selects the receipts of a branch to audit.
+type ReceiptAuditRequest struct {
+	BranchID string `json:"branch_id"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+}
+
+// ReceiptAudit lists the reserved receipt numbers that have no transaction.
+type ReceiptAudit struct {
+	Prefix string          `json:"prefix"`
+	Blocks []SequenceBlock `json:"blocks"`
+	Gaps   []string        `json:"gaps"`
+}
+
+// ReceiptAuditHTTP is an HTTP Cloud Function that reports the gaps in the
+// receipt numbers of a branch between two sequence values.
+func ReceiptAuditHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).ReceiptAuditHTTP)
+}
+
+func (h *Handler) ReceiptAuditHTTP(w http.ResponseWriter, r *http.Request) {
+	var req ReceiptAuditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+	if req.From <= 0 {
+		req.From = 1
+	}
+	if req.To < req.From || req.To-req.From >= maxReceiptAuditRange {
+		sendErrorf(w, "please audit between 1 and %d receipts at a time", maxReceiptAuditRange)
+		return
+	}
+
+	prefix := receiptPrefix(req.BranchID)
+	blocks, err := h.store.ListSequenceBlocks(r.Context(), receiptSequenceName(prefix))
+	if err != nil {
+		log.Println(err)
+		sendError(w, "cannot read receipt blocks")
+		return
+	}
+
+	audit := ReceiptAudit{Prefix: prefix, Gaps: []string{}}
+	for _, block := range blocks {
+		if block.End < req.From || block.Start > req.To {
+			continue
+		}
+		audit.Blocks = append(audit.Blocks, block)
+		for seq := block.Start; seq <= block.End; seq++ {
+			if seq < req.From || seq > req.To {
+				continue
+			}
+			receipt := formatReceiptNumber(prefix, seq)
+			_, err := h.store.GetTransaction(r.Context(), receipt)
+			if err == ErrNotFound {
+				audit.Gaps = append(audit.Gaps, receipt)
+			} else if err != nil {
+				log.Println(err)
+				sendError(w, "cannot read transaction data")
+				return
+			}
+		}
+	}
+
+	sendResponse(w, audit)
+}
+
+var errInvalidReceiptNumber = errors.New("invalid receipt number")
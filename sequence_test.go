@@ -0,0 +1,115 @@
+package surebankltd
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   int
+	}{
+		{"7992739871", 3},
+		{"00000012", 5},
+		{"0000000", 0},
+		{"1", 8},
+		{"0000123", 0},
+	}
+	for _, tt := range tests {
+		if got := luhnCheckDigit(tt.digits); got != tt.want {
+			t.Errorf("luhnCheckDigit(%q) = %d, want %d", tt.digits, got, tt.want)
+		}
+	}
+
+	for digits, want := range map[string]bool{
+		"79927398713": true,
+		"79927398710": false,
+		"79927398731": false,
+		"000000125":   true,
+		"5":           false,
+		"12a4":        false,
+	} {
+		if got := validLuhn(digits); got != want {
+			t.Errorf("validLuhn(%q) = %v, want %v", digits, got, want)
+		}
+	}
+}
+
+func TestReceiptNumbers(t *testing.T) {
+	if got := formatReceiptNumber("HQ", 12); got != "HQ-000000125" {
+		t.Errorf("formatReceiptNumber = %s, want HQ-000000125", got)
+	}
+	for branch, want := range map[string]string{
+		"":             "HQ",
+		"lagos-island": "LAGO",
+		"a-1 b":        "A1B",
+		"ìbàdàn":       "BDN",
+		"--":           "HQ",
+	} {
+		if got := receiptPrefix(branch); got != want {
+			t.Errorf("receiptPrefix(%q) = %s, want %s", branch, got, want)
+		}
+	}
+	for receipt, want := range map[string]bool{
+		"HQ-000000125":    true,
+		"LAGO-000000125":  true,
+		"HQ-000000126":    false,
+		"HQ-00000125":     false,
+		"TX123456":        true,
+		"TX12345":         false,
+		"-000000125":      false,
+		"HQ-0000001234x5": false,
+	} {
+		if got := validReceiptNumber(receipt); got != want {
+			t.Errorf("validReceiptNumber(%q) = %v, want %v", receipt, got, want)
+		}
+	}
+}
+
+func TestSequenceAllocatorReservesBlocks(t *testing.T) {
+	var mu sync.Mutex
+	next := map[string]int64{}
+	reserved := map[string]int{}
+	allocator := newSequenceAllocator(10, func(ctx context.Context, name string, size int64) (int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		start := next[name] + 1
+		next[name] += size
+		reserved[name]++
+		return start, nil
+	})
+
+	const n = 95
+	var wg sync.WaitGroup
+	values := make(chan int64, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := allocator.next(context.Background(), "receipt-HQ")
+			if err != nil {
+				t.Error(err)
+			}
+			values <- v
+		}()
+	}
+	wg.Wait()
+	close(values)
+
+	seen := map[int64]bool{}
+	for v := range values {
+		if v < 1 || v > n || seen[v] {
+			t.Fatalf("got value %d twice or out of range", v)
+		}
+		seen[v] = true
+	}
+	if reserved["receipt-HQ"] != 10 {
+		t.Errorf("reserved %d blocks for %d values, want 10", reserved["receipt-HQ"], n)
+	}
+
+	if v, err := allocator.next(context.Background(), "receipt-LAGO"); err != nil || v != 1 {
+		t.Errorf("first value of another sequence = %d, %v, want 1", v, err)
+	}
+}
@@ -0,0 +1,213 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ademuanthony/surebankltd/notify"
+	"github.com/jinzhu/now"
+	"github.com/pkg/errors"
+)
+
+// statementPageSize is the number of accounts read at a time when queueing
+// the monthly statements.
+const statementPageSize = 500
+
+// MonthlyStatementsRequest selects the month of the statements, e.g.
+// "2026-09". It defaults to the previous month.
+type MonthlyStatementsRequest struct {
+	Month string `json:"month" example:"2026-09"`
+}
+
+// MonthlyStatementsReport counts the statements queued for a month.
+type MonthlyStatementsReport struct {
+	Month string `json:"month"`
+	// Queued statements are emailed by the notification worker.
+	Queued int `json:"queued"`
+	// Skipped counts the accounts of customers without an email address,
+	// the dormant empty accounts and the statements queued before.
+	Skipped int `json:"skipped"`
+}
+
+// SendMonthlyStatementsHTTP is an HTTP Cloud Function, run on a schedule at
+// the start of the month, that queues the monthly statement emails of the
+// customers with an email address. Running it again for a month only queues
+// the statements that were missed.
+func SendMonthlyStatementsHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).SendMonthlyStatementsHTTP)
+}
+
+func (h *Handler) SendMonthlyStatementsHTTP(w http.ResponseWriter, r *http.Request) {
+	var req MonthlyStatementsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	currentDate := timeNow()
+	month := now.New(currentDate).BeginningOfMonth().AddDate(0, -1, 0)
+	if req.Month != "" {
+		m, err := time.ParseInLocation("2006-01", req.Month, currentDate.Location())
+		if err != nil {
+			sendError(w, "month must be formatted as YYYY-MM")
+			return
+		}
+		month = m
+	}
+
+	report, err := queueMonthlyStatements(r.Context(), h.store, month, currentDate)
+	if err != nil {
+		sendErrorf(w, "cannot queue statements, %s", err.Error())
+		return
+	}
+	sendResponse(w, report)
+}
+
+func queueMonthlyStatements(ctx context.Context, store Store, month, currentDate time.Time) (*MonthlyStatementsReport, error) {
+	start := now.New(month).BeginningOfMonth()
+	end := start.AddDate(0, 1, 0)
+	if end.After(currentDate) {
+		return nil, errors.New("the month has not ended")
+	}
+
+	report := &MonthlyStatementsReport{Month: start.Format("2006-01")}
+	customers := map[string]*Customer{}
+	for offset := 0; ; offset += statementPageSize {
+		accounts, err := store.ListAccounts(ctx, AccountQuery{Limit: statementPageSize, Offset: offset})
+		if err != nil {
+			log.Println(err)
+			return nil, errors.New("cannot read accounts")
+		}
+		for i := range accounts {
+			queued, err := queueStatement(ctx, store, &accounts[i], customers, start, end, currentDate)
+			if err != nil {
+				return nil, err
+			}
+			if queued {
+				report.Queued++
+			} else {
+				report.Skipped++
+			}
+		}
+		if len(accounts) < statementPageSize {
+			break
+		}
+	}
+	return report, nil
+}
+
+// queueStatement queues the statement of account for the month from start
+// to end. It reports whether a statement was queued.
+func queueStatement(ctx context.Context, store Store, account *Account, customers map[string]*Customer,
+	start, end, currentDate time.Time) (bool, error) {
+
+	customer, ok := customers[account.CustomerID]
+	if !ok {
+		var err error
+		if customer, err = store.GetCustomer(ctx, account.CustomerID); err != nil && err != ErrNotFound {
+			log.Println(err)
+			return false, errors.Errorf("cannot read the customer of %s", account.Number)
+		}
+		customers[account.CustomerID] = customer
+	}
+	if customer == nil || customer.Email == "" {
+		return false, nil
+	}
+
+	id := fmt.Sprintf("statement_%s_%s", account.Number, start.Format("200601"))
+	if _, err := store.GetNotification(ctx, id); err == nil {
+		return false, nil
+	} else if err != ErrNotFound {
+		log.Println(err)
+		return false, errors.New("cannot read notification")
+	}
+
+	transactions, err := store.ListTransactions(ctx, TransactionQuery{AccountNumber: account.Number, From: start.Unix()})
+	if err != nil {
+		log.Println(err)
+		return false, errors.Errorf("cannot read the transactions of %s", account.Number)
+	}
+	payload := accountStatement(customer, account, transactions, start, end)
+	if len(payload.Lines) == 0 && account.Balance == 0 {
+		return false, nil
+	}
+
+	n := newEmailNotification(customer, notify.EmailMonthlyStatement, payload, currentDate)
+	n.ID = id
+	n.AccountNumber = account.Number
+	batch := store.Batch()
+	batch.CreateNotification(*n)
+	if err = batch.Commit(ctx); err != nil {
+		log.Println(err)
+		return false, errors.Errorf("cannot queue the statement of %s", account.Number)
+	}
+	return true, nil
+}
+
+// accountStatement returns the statement of account for the month from
+// start to end, transactions holding every transaction since start. The
+// balances are worked back from the current balance of the account.
+func accountStatement(customer *Customer, account *Account, transactions []Transaction, start, end time.Time) notify.StatementEmailPayload {
+	signed := func(t Transaction) Money {
+		if t.Type == TransactionType_Deposit {
+			return t.Amount
+		}
+		return -t.Amount
+	}
+
+	closing := account.Balance
+	var period []Transaction
+	for _, t := range transactions {
+		if t.CreatedAt >= end.Unix() {
+			closing -= signed(t)
+		} else if t.CreatedAt >= start.Unix() {
+			period = append(period, t)
+		}
+	}
+	sort.Slice(period, func(i, j int) bool {
+		if period[i].CreatedAt != period[j].CreatedAt {
+			return period[i].CreatedAt < period[j].CreatedAt
+		}
+		return period[i].ReceiptNo < period[j].ReceiptNo
+	})
+
+	opening := closing
+	for _, t := range period {
+		opening -= signed(t)
+	}
+	payload := notify.StatementEmailPayload{
+		Name:           customer.Name,
+		AccountNumber:  account.Number,
+		Period:         start.Format("January 2006"),
+		OpeningBalance: opening.String(),
+		ClosingBalance: closing.String(),
+	}
+	var deposits, withdrawals Money
+	balance := opening
+	for _, t := range period {
+		balance += signed(t)
+		line := notify.StatementLine{
+			Date:      time.Unix(t.CreatedAt, 0).Format("02/01/2006"),
+			ReceiptNo: t.ReceiptNo,
+			Narration: t.Narration,
+			Balance:   balance.String(),
+		}
+		if t.Type == TransactionType_Deposit {
+			deposits += t.Amount
+			line.Credit = t.Amount.String()
+		} else {
+			withdrawals += t.Amount
+			line.Debit = t.Amount.String()
+		}
+		payload.Lines = append(payload.Lines, line)
+	}
+	payload.TotalDeposits = deposits.String()
+	payload.TotalWithdrawals = withdrawals.String()
+	return payload
+}
@@ -0,0 +1,268 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+// maxDashboardDays bounds the number of days added up by one dashboard request.
+const maxDashboardDays = 92
+
+// accountTypes lists the account types with a global balance.
+var accountTypes = []string{AccountTypeDS, AccountTypeSB}
+
+// statTransactionPath returns the path of the count or total of the
+// transactions of a type posted on a day.
+func statTransactionPath(day int64, txType TransactionType, field string) string {
+	return fmt.Sprintf("stats/transaction/%d/%s/%s", day, txType, field)
+}
+
+// statSalesRepPath returns the path of the deposits collected by a sales rep
+// on a day with a payment method.
+func statSalesRepPath(day int64, salesRepID, paymentMethod string) string {
+	if salesRepID == "" {
+		salesRepID = "unassigned"
+	}
+	return fmt.Sprintf("stats/transaction/%d/%s/%s", day, salesRepID, paymentMethod)
+}
+
+// statSalesRepCashOutPath returns the path of the cash withdrawals paid out
+// by a sales rep on a day.
+func statSalesRepCashOutPath(day int64, salesRepID string) string {
+	return statSalesRepPath(day, salesRepID, "cash_out")
+}
+
+// statCommissionPath returns the path of the count or total of all commissions.
+func statCommissionPath(field string) string {
+	return "stats/commission/" + field
+}
+
+// statDailyCommissionPath returns the path of the count or total of the
+// commissions taken on a day.
+func statDailyCommissionPath(day int64, field string) string {
+	return fmt.Sprintf("stats/commission/%d/%s", day, field)
+}
+
+// statGlobalBalancePath returns the path of the total balance of an account type.
+func statGlobalBalancePath(accountType string) string {
+	return "stats/globalBalance/" + accountType
+}
+
+// recordTransactionStats adds tx to the stats of the day it was posted, or
+// takes it out when n is -1. Deposits also count towards the sales rep that
+// collected them and cash withdrawals towards the sales rep that paid them.
+func recordTransactionStats(w Writer, tx Transaction, n int64) {
+	day := now.New(time.Unix(tx.CreatedAt, 0)).BeginningOfDay().Unix()
+	w.IncrementCount(statTransactionPath(day, tx.Type, "count"), n)
+	w.IncrementTotal(statTransactionPath(day, tx.Type, "total"), tx.Amount*Money(n))
+	switch {
+	case tx.Type == TransactionType_Deposit:
+		w.IncrementTotal(statSalesRepPath(day, tx.SalesRepID, tx.PaymentMethod), tx.Amount*Money(n))
+	case tx.PaymentMethod != PaymentMethod_Bank:
+		w.IncrementTotal(statSalesRepCashOutPath(day, tx.SalesRepID), tx.Amount*Money(n))
+	}
+}
+
+// recordCommissionStats adds commission to the commission stats, or takes
+// it out when n is -1.
+func recordCommissionStats(w Writer, commission DSCommission, n int64) {
+	day := now.New(time.Unix(commission.Date, 0)).BeginningOfDay().Unix()
+	w.IncrementCount(statCommissionPath("count"), n)
+	w.IncrementTotal(statCommissionPath("total"), commission.Amount*Money(n))
+	w.IncrementCount(statDailyCommissionPath(day, "count"), n)
+	w.IncrementTotal(statDailyCommissionPath(day, "total"), commission.Amount*Money(n))
+}
+
+// DashboardRequest selects the days of the dashboard. From and To default to today.
+type DashboardRequest struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// SalesRepStat is the money collected and the cash paid out by a sales rep.
+type SalesRepStat struct {
+	SalesRepID string `json:"sales_rep_id"`
+	Cash       Money  `json:"cash"`
+	Bank       Money  `json:"bank"`
+	CashOut    Money  `json:"cash_out"`
+}
+
+// DashboardPeriod adds up the stats of the days of a period.
+type DashboardPeriod struct {
+	From            int64          `json:"from"`
+	To              int64          `json:"to"`
+	DepositCount    int64          `json:"deposit_count"`
+	DepositTotal    Money          `json:"deposit_total"`
+	WithdrawalCount int64          `json:"withdrawal_count"`
+	WithdrawalTotal Money          `json:"withdrawal_total"`
+	CommissionCount int64          `json:"commission_count"`
+	CommissionTotal Money          `json:"commission_total"`
+	SalesReps       []SalesRepStat `json:"sales_reps"`
+}
+
+// Dashboard is the summary shown to admins.
+type Dashboard struct {
+	Today           DashboardPeriod  `json:"today"`
+	Period          DashboardPeriod  `json:"period"`
+	CommissionCount int64            `json:"commission_count"`
+	CommissionTotal Money            `json:"commission_total"`
+	GlobalBalances  map[string]Money `json:"global_balances"`
+}
+
+// DashboardHTTP is an HTTP Cloud Function that returns the stats of today
+// and of a range of days.
+func DashboardHTTP(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).DashboardHTTP)
+}
+
+func (h *Handler) DashboardHTTP(w http.ResponseWriter, r *http.Request) {
+	var req DashboardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		sendError(w, "cannot decode client request")
+		return
+	}
+
+	today := now.New(timeNow()).BeginningOfDay()
+	from, to := today, today
+	if req.From > 0 {
+		from = now.New(time.Unix(req.From, 0)).BeginningOfDay()
+	}
+	if req.To > 0 {
+		to = now.New(time.Unix(req.To, 0)).BeginningOfDay()
+	}
+	if to.Before(from) || to.Sub(from) >= maxDashboardDays*24*time.Hour {
+		sendErrorf(w, "please select between 1 and %d days", maxDashboardDays)
+		return
+	}
+
+	var dashboard Dashboard
+	var err error
+	if dashboard.Today, err = dashboardPeriod(r.Context(), h.store, today, today); err != nil {
+		log.Println(err)
+		sendError(w, "cannot read today's stats")
+		return
+	}
+	if dashboard.Period, err = dashboardPeriod(r.Context(), h.store, from, to); err != nil {
+		log.Println(err)
+		sendError(w, "cannot read the stats of the period")
+		return
+	}
+	if dashboard.CommissionCount, err = h.store.Count(r.Context(), statCommissionPath("count")); err != nil {
+		log.Println(err)
+		sendError(w, "cannot read commission stats")
+		return
+	}
+	if dashboard.CommissionTotal, err = h.store.Total(r.Context(), statCommissionPath("total")); err != nil {
+		log.Println(err)
+		sendError(w, "cannot read commission stats")
+		return
+	}
+	dashboard.GlobalBalances = map[string]Money{}
+	for _, accountType := range accountTypes {
+		if dashboard.GlobalBalances[accountType], err = h.store.Total(r.Context(), statGlobalBalancePath(accountType)); err != nil {
+			log.Println(err)
+			sendError(w, "cannot read global balances")
+			return
+		}
+	}
+
+	sendResponse(w, dashboard)
+}
+
+// dashboardPeriod adds up the stats of the days from from to to.
+func dashboardPeriod(ctx context.Context, store Store, from, to time.Time) (DashboardPeriod, error) {
+	period := DashboardPeriod{From: from.Unix(), To: to.Unix(), SalesReps: []SalesRepStat{}}
+	reps := map[string]*SalesRepStat{}
+	for day := from; !day.After(to); day = day.Add(24 * time.Hour) {
+		d := now.New(day).BeginningOfDay().Unix()
+		counts := []struct {
+			path  string
+			value *int64
+		}{
+			{statTransactionPath(d, TransactionType_Deposit, "count"), &period.DepositCount},
+			{statTransactionPath(d, TransactionType_Withdrawal, "count"), &period.WithdrawalCount},
+			{statDailyCommissionPath(d, "count"), &period.CommissionCount},
+		}
+		for _, c := range counts {
+			n, err := store.Count(ctx, c.path)
+			if err != nil {
+				return period, err
+			}
+			*c.value += n
+		}
+		totals := []struct {
+			path  string
+			value *Money
+		}{
+			{statTransactionPath(d, TransactionType_Deposit, "total"), &period.DepositTotal},
+			{statTransactionPath(d, TransactionType_Withdrawal, "total"), &period.WithdrawalTotal},
+			{statDailyCommissionPath(d, "total"), &period.CommissionTotal},
+		}
+		for _, t := range totals {
+			amount, err := store.Total(ctx, t.path)
+			if err != nil {
+				return period, err
+			}
+			*t.value += amount
+		}
+
+		repIDs, err := salesRepsOn(ctx, store, d)
+		if err != nil {
+			return period, err
+		}
+		for _, repID := range repIDs {
+			rep, ok := reps[repID]
+			if !ok {
+				rep = &SalesRepStat{SalesRepID: repID}
+				reps[repID] = rep
+			}
+			cash, err := store.Total(ctx, statSalesRepPath(d, repID, PaymentMethod_Cash))
+			if err != nil {
+				return period, err
+			}
+			bank, err := store.Total(ctx, statSalesRepPath(d, repID, PaymentMethod_Bank))
+			if err != nil {
+				return period, err
+			}
+			cashOut, err := store.Total(ctx, statSalesRepCashOutPath(d, repID))
+			if err != nil {
+				return period, err
+			}
+			rep.Cash += cash
+			rep.Bank += bank
+			rep.CashOut += cashOut
+		}
+	}
+	for _, rep := range reps {
+		period.SalesReps = append(period.SalesReps, *rep)
+	}
+	sort.Slice(period.SalesReps, func(i, j int) bool {
+		return period.SalesReps[i].SalesRepID < period.SalesReps[j].SalesRepID
+	})
+	return period, nil
+}
+
+// salesRepsOn returns the sales reps with deposit or cash withdrawal stats on a day.
+func salesRepsOn(ctx context.Context, store Store, day int64) ([]string, error) {
+	// Sales rep stats share the day with the transaction types.
+	keys, err := store.ListCounterKeys(ctx, fmt.Sprintf("stats/transaction/%d", day))
+	if err != nil {
+		return nil, err
+	}
+	var repIDs []string
+	for _, key := range keys {
+		if key == string(TransactionType_Deposit) || key == string(TransactionType_Withdrawal) {
+			continue
+		}
+		repIDs = append(repIDs, key)
+	}
+	return repIDs, nil
+}
@@ -0,0 +1,117 @@
+package surebankltd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+func TestDashboard(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00050", AccountTypeSB, 0)
+	batch := store.Batch()
+	batch.CreateCustomer(Customer{ID: "customer-DS00050", Name: "Test Customer", PhoneNumber: "08030000000"})
+	batch.CreateAccount(Account{Number: "DS00050", CustomerID: "customer-DS00050", Type: AccountTypeDS, Target: 500 * Naira})
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	today := now.New(timeNow()).BeginningOfDay()
+	yesterday := today.AddDate(0, 0, -1)
+
+	post := func(number string, amount Money, method, rep string, at time.Time) *Transaction {
+		t.Helper()
+		tx, err := create(ctx, Transaction{
+			AccountNumber: number,
+			Type:          TransactionType_Deposit,
+			Amount:        amount,
+			PaymentMethod: method,
+			SalesRepID:    rep,
+		}, at, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	post("SB00050", 1000*Naira, PaymentMethod_Cash, "rep1", yesterday.Add(9*time.Hour))
+	post("SB00050", 500*Naira, PaymentMethod_Bank, "rep1", today.Add(9*time.Hour))
+	reversed := post("SB00050", 300*Naira, PaymentMethod_Cash, "rep2", today.Add(10*time.Hour))
+	// The first contribution of the cycle is taken as the fee.
+	post("DS00050", 500*Naira, PaymentMethod_Cash, "rep1", today.Add(11*time.Hour))
+	post("DS00050", 500*Naira, PaymentMethod_Cash, "rep1", today.Add(12*time.Hour))
+	if _, err := makeDeduction(ctx, MakeDeductionRequest{
+		AccountNumber: "SB00050",
+		Amount:        200 * Naira,
+		PaymentMethod: PaymentMethod_Cash,
+		SalesRepID:    "rep2",
+	}, today.Add(13*time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reverseTransaction(ctx, ReverseTransactionRequest{
+		ID:         reversed.ReceiptNo,
+		Reason:     "wrong account",
+		ApprovedBy: "manager",
+	}, today.Add(14*time.Hour), store); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"from":%d,"to":%d}`, yesterday.Unix(), today.Unix())
+	NewHandler(store).DashboardHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	var resp struct {
+		Success bool
+		Data    Dashboard
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || !resp.Success {
+		t.Fatalf("got response %s, %v", w.Body, err)
+	}
+
+	wantToday := DashboardPeriod{
+		From:            today.Unix(),
+		To:              today.Unix(),
+		DepositCount:    3,
+		DepositTotal:    1500 * Naira,
+		WithdrawalCount: 1,
+		WithdrawalTotal: 200 * Naira,
+		CommissionCount: 1,
+		CommissionTotal: 500 * Naira,
+		SalesReps: []SalesRepStat{
+			{SalesRepID: "rep1", Cash: 1000 * Naira, Bank: 500 * Naira},
+			{SalesRepID: "rep2", CashOut: 200 * Naira},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data.Today, wantToday) {
+		t.Errorf("today = %+v, want %+v", resp.Data.Today, wantToday)
+	}
+	wantPeriod := wantToday
+	wantPeriod.From = yesterday.Unix()
+	wantPeriod.DepositCount, wantPeriod.DepositTotal = 4, 2500*Naira
+	wantPeriod.SalesReps = []SalesRepStat{
+		{SalesRepID: "rep1", Cash: 2000 * Naira, Bank: 500 * Naira},
+		{SalesRepID: "rep2", CashOut: 200 * Naira},
+	}
+	if !reflect.DeepEqual(resp.Data.Period, wantPeriod) {
+		t.Errorf("period = %+v, want %+v", resp.Data.Period, wantPeriod)
+	}
+	if resp.Data.CommissionCount != 1 || resp.Data.CommissionTotal != 500*Naira {
+		t.Errorf("commissions = %d, %s, want 1, %s", resp.Data.CommissionCount, resp.Data.CommissionTotal, 500*Naira)
+	}
+	wantBalances := map[string]Money{AccountTypeSB: 1300 * Naira, AccountTypeDS: 500 * Naira}
+	if !reflect.DeepEqual(resp.Data.GlobalBalances, wantBalances) {
+		t.Errorf("global balances = %v, want %v", resp.Data.GlobalBalances, wantBalances)
+	}
+
+	w = httptest.NewRecorder()
+	body = fmt.Sprintf(`{"from":%d,"to":%d}`, today.Unix(), yesterday.Unix())
+	NewHandler(store).DashboardHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if strings.Contains(w.Body.String(), `"success":true`) {
+		t.Errorf("a range ending before it starts was accepted: %s", w.Body)
+	}
+}
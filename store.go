@@ -0,0 +1,264 @@
+package surebankltd
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by a Store when the requested document does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Store is the persistence layer used by the HTTP functions. The Firestore
+// implementation is used in production while the in-memory implementation
+// backs tests and local runs.
+type Store interface {
+	GetCustomer(ctx context.Context, id string) (*Customer, error)
+	// GetCustomers returns the customers with the given IDs in the same
+	// order, leaving out those that do not exist.
+	GetCustomers(ctx context.Context, ids []string) ([]Customer, error)
+	ListCustomers(ctx context.Context, req FindCustomerRequest) ([]Customer, error)
+	// ListCustomerChanges returns the changes made to a customer, newest first.
+	ListCustomerChanges(ctx context.Context, customerID string) ([]CustomerChange, error)
+	// SearchCustomers returns the search index entries having any of the
+	// keys of the query.
+	SearchCustomers(ctx context.Context, query CustomerSearchQuery) ([]CustomerSearchEntry, error)
+
+	GetAccount(ctx context.Context, number string) (*Account, error)
+	ListAccounts(ctx context.Context, query AccountQuery) ([]Account, error)
+
+	GetTransaction(ctx context.Context, receiptNo string) (*Transaction, error)
+	// ListTransactions returns the matching transactions, newest first.
+	ListTransactions(ctx context.Context, query TransactionQuery) ([]Transaction, error)
+
+	GetCommission(ctx context.Context, id string) (*DSCommission, error)
+
+	GetCommissionPolicy(ctx context.Context, id string) (*CommissionPolicy, error)
+	// ListCommissionPolicies returns every version of the commission policies.
+	ListCommissionPolicies(ctx context.Context) ([]CommissionPolicy, error)
+
+	GetCycle(ctx context.Context, id string) (*DSCycle, error)
+	// ListCycles returns the DS cycles of an account, newest first.
+	ListCycles(ctx context.Context, accountNumber string) ([]DSCycle, error)
+
+	GetDailySummary(ctx context.Context, branchID string, day int64) (*DailySummary, error)
+	// ListDailySummaries returns the matching summaries, newest first.
+	ListDailySummaries(ctx context.Context, query DailySummaryQuery) ([]DailySummary, error)
+	GetDayLock(ctx context.Context, branchID string) (*DayLock, error)
+
+	GetExpense(ctx context.Context, id string) (*Expense, error)
+	// ListExpenses returns the matching expenses, newest first.
+	ListExpenses(ctx context.Context, query ExpenseQuery) ([]Expense, error)
+
+	// ListLodgements returns the matching lodgements, newest first.
+	ListLodgements(ctx context.Context, query LodgementQuery) ([]Lodgement, error)
+
+	GetNotification(ctx context.Context, id string) (*Notification, error)
+	// ListNotifications returns the matching notifications, newest first or,
+	// when DueBefore is set, in the order they are due.
+	ListNotifications(ctx context.Context, query NotificationQuery) ([]Notification, error)
+
+	GetRemittance(ctx context.Context, id string) (*Remittance, error)
+	// ListRemittances returns the matching remittances, newest collection day first.
+	ListRemittances(ctx context.Context, query RemittanceQuery) ([]Remittance, error)
+
+	// ListLedgerAccounts returns every ledger account with its debit and credit totals.
+	ListLedgerAccounts(ctx context.Context) ([]LedgerAccount, error)
+	// ListLedgerEntries returns the matching ledger entries in posting order.
+	ListLedgerEntries(ctx context.Context, query LedgerQuery) ([]LedgerEntry, error)
+
+	// ListExpiredIdempotencyRecords returns up to limit records that expired before the given time.
+	ListExpiredIdempotencyRecords(ctx context.Context, before int64, limit int) ([]IdempotencyRecord, error)
+
+	// NextSequence returns the next value of the named sequence. Values are
+	// unique and increase within an instance; values reserved by an instance
+	// that stops before using them are left as gaps.
+	NextSequence(ctx context.Context, name string) (int64, error)
+	// ListSequenceBlocks returns the blocks reserved from the named sequence.
+	ListSequenceBlocks(ctx context.Context, name string) ([]SequenceBlock, error)
+
+	// Count returns the aggregated count of the stat counter at path.
+	Count(ctx context.Context, path string) (int64, error)
+	// Total returns the aggregated amount of the stat counter at path.
+	Total(ctx context.Context, path string) (Money, error)
+	// ListCounterKeys returns the names of the path segments following
+	// parent in the paths of the stat counters.
+	ListCounterKeys(ctx context.Context, parent string) ([]string, error)
+
+	// Batch returns a Batch whose writes are applied atomically on Commit.
+	Batch() Batch
+
+	// RunTransaction runs fn in a read-then-write transaction and commits the
+	// writes made through tx when fn returns nil. fn is retried when the
+	// transaction conflicts with another one, so it must not have side effects
+	// outside of tx.
+	RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error
+}
+
+// Writer contains the write operations supported by a Store.
+type Writer interface {
+	CreateCustomer(customer Customer)
+	// UpdateCustomer persists the details and the preferences of the customer.
+	UpdateCustomer(customer Customer)
+	CreateCustomerChange(change CustomerChange)
+	// SetCustomerSearchEntry replaces the search index entry of a customer.
+	SetCustomerSearchEntry(entry CustomerSearchEntry)
+	CreateAccount(account Account)
+	// UpdateAccount persists the balance, payment dates, current DS cycle and
+	// recent transactions of the account.
+	UpdateAccount(account Account)
+	// RenameAccountCustomer persists the customer name of the account and of
+	// its recent transactions.
+	RenameAccountCustomer(account Account)
+	CreateTransaction(tx Transaction)
+	// RenameTransactionCustomer sets the customer name of a transaction.
+	RenameTransactionCustomer(receiptNo, customerName string)
+	// ReverseTransaction links a transaction to the transaction reversing it.
+	ReverseTransaction(receiptNo, reversalReceiptNo string, reversedAt int64)
+	CreateCommission(commission DSCommission)
+	// ReverseCommission marks a commission as given back to the customer.
+	ReverseCommission(id string, reversedAt int64)
+	CreateCommissionPolicy(policy CommissionPolicy)
+	CreateCycle(cycle DSCycle)
+	// UpdateCycle persists the contributions, fee, payout and status of the cycle.
+	UpdateCycle(cycle DSCycle)
+	// IncrementDailySummary adds the amounts and counts of delta to the
+	// summary of the branch on the given day.
+	IncrementDailySummary(branchID string, day int64, delta DailySummary)
+	// CloseDailySummary replaces the summary of a day with its closed version.
+	CloseDailySummary(summary DailySummary)
+	SetDayLock(lock DayLock)
+	CreateExpense(expense Expense)
+	// ArchiveExpense persists the archiving of the expense.
+	ArchiveExpense(expense Expense)
+	CreateLodgement(lodgement Lodgement)
+	CreateNotification(n Notification)
+	// UpdateNotification persists the delivery state of the notification.
+	UpdateNotification(n Notification)
+	CreateRemittance(remittance Remittance)
+	// UpdateRemittance persists the review of the remittance.
+	UpdateRemittance(remittance Remittance)
+	// PostJournal writes the entries of a balanced journal and adds them to
+	// the totals of their ledger accounts.
+	PostJournal(journal Journal)
+	SetIdempotencyRecord(rec IdempotencyRecord)
+	DeleteIdempotencyRecord(id string)
+	IncrementCount(path string, n int64)
+	IncrementTotal(path string, amount Money)
+}
+
+// Batch is a group of writes that are committed together.
+type Batch interface {
+	Writer
+	Commit(ctx context.Context) error
+}
+
+// Tx is a Store transaction. Every read must happen before the first write.
+type Tx interface {
+	GetCustomer(ctx context.Context, id string) (*Customer, error)
+	GetAccount(ctx context.Context, number string) (*Account, error)
+	ListAccounts(ctx context.Context, query AccountQuery) ([]Account, error)
+	GetTransaction(ctx context.Context, receiptNo string) (*Transaction, error)
+	ListTransactions(ctx context.Context, query TransactionQuery) ([]Transaction, error)
+	GetCommission(ctx context.Context, id string) (*DSCommission, error)
+	GetCommissionPolicy(ctx context.Context, id string) (*CommissionPolicy, error)
+	GetCycle(ctx context.Context, id string) (*DSCycle, error)
+	GetDailySummary(ctx context.Context, branchID string, day int64) (*DailySummary, error)
+	GetDayLock(ctx context.Context, branchID string) (*DayLock, error)
+	GetExpense(ctx context.Context, id string) (*Expense, error)
+	GetNotification(ctx context.Context, id string) (*Notification, error)
+	GetRemittance(ctx context.Context, id string) (*Remittance, error)
+	GetIdempotencyRecord(ctx context.Context, id string) (*IdempotencyRecord, error)
+	Writer
+}
+
+// CustomerSearchQuery defines the options to read the search index.
+type CustomerSearchQuery struct {
+	// Keys holds up to 10 keys, an entry matching any of them.
+	Keys            []string
+	SalesRepID      string
+	IncludeArchived bool
+	Limit           int
+}
+
+// AccountQuery defines the options to filter and page accounts.
+type AccountQuery struct {
+	CustomerID      string
+	Type            string
+	SalesRepID      string
+	PositiveBalance bool
+	// LastPaymentFrom and LastPaymentTo bound LastPaymentDate when not zero.
+	// Setting either of them orders the result by LastPaymentDate ascending,
+	// otherwise accounts are ordered by CreatedAt descending.
+	LastPaymentFrom int64
+	LastPaymentTo   int64
+	Limit           int
+	Offset          int
+}
+
+// TransactionQuery defines the options to filter transactions.
+type TransactionQuery struct {
+	AccountNumber string
+	CycleID       string
+	// From and To bound CreatedAt when not zero.
+	From  int64
+	To    int64
+	Limit int
+}
+
+// DailySummaryQuery defines the options to filter and page the daily
+// summaries of a branch.
+type DailySummaryQuery struct {
+	BranchID string
+	// From and To bound Date when not zero.
+	From   int64
+	To     int64
+	Limit  int
+	Offset int
+}
+
+// RemittanceQuery defines the options to filter and page remittances.
+type RemittanceQuery struct {
+	SalesRepID string
+	Status     string
+	// From and To bound Date when not zero.
+	From   int64
+	To     int64
+	Limit  int
+	Offset int
+}
+
+// ExpenseQuery defines the options to filter and page expenses.
+type ExpenseQuery struct {
+	BranchID string
+	Category string
+	// From and To bound Date when not zero.
+	From            int64
+	To              int64
+	IncludeArchived bool
+	Limit           int
+	Offset          int
+}
+
+// LodgementQuery defines the options to filter and page lodgements.
+type LodgementQuery struct {
+	BranchID string
+	// From and To bound Date when not zero.
+	From   int64
+	To     int64
+	Limit  int
+	Offset int
+}
+
+// NotificationQuery defines the options to filter and page notifications.
+type NotificationQuery struct {
+	Status     string
+	CustomerID string
+	// MessageID selects by the message ID given by the provider.
+	MessageID string
+	Failed    bool
+	// DueBefore bounds NextAttemptAt when not zero.
+	DueBefore int64
+	Limit     int
+	Offset    int
+}
@@ -0,0 +1,1273 @@
+package surebankltd
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/ademuanthony/surebankltd/counter"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+)
+
+type firestoreStore struct {
+	client        *firestore.Client
+	sequences     *sequenceAllocator
+	counterShards int
+	counterCache  *counter.Cache
+}
+
+// NewFirestoreStore returns a Store backed by the given Firestore client.
+func NewFirestoreStore(client *firestore.Client) Store {
+	s := &firestoreStore{
+		client:        client,
+		counterShards: counterShards(),
+		counterCache:  counter.NewCache(counterCacheTTL()),
+	}
+	s.sequences = newSequenceAllocator(sequenceBlockSize(), s.reserveSequence)
+	return s
+}
+
+// getDoc reads the document at path into v, mapping a missing document to ErrNotFound.
+func (s *firestoreStore) getDoc(ctx context.Context, path string, v interface{}) error {
+	docSnap, err := s.client.Doc(path).Get(ctx)
+	if docSnap != nil && !docSnap.Exists() {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return dataTo(docSnap, v)
+}
+
+// dataTo decodes the document into v. Reads never write, so a document whose
+// amounts are still stored as float naira is refused until MigrateMoneyHTTP
+// has converted it.
+func dataTo(docSnap *firestore.DocumentSnapshot, v interface{}) error {
+	if hasLegacyMoney(docSnap) {
+		return errors.Errorf("%s holds amounts in naira, run the money migration first", docSnap.Ref.Path)
+	}
+	return docSnap.DataTo(v)
+}
+
+func (s *firestoreStore) GetCustomer(ctx context.Context, id string) (*Customer, error) {
+	var customer Customer
+	if err := s.getDoc(ctx, "customer/"+id, &customer); err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (s *firestoreStore) GetCustomers(ctx context.Context, ids []string) ([]Customer, error) {
+	refs := make([]*firestore.DocumentRef, len(ids))
+	for i, id := range ids {
+		refs[i] = s.client.Doc("customer/" + id)
+	}
+	docs, err := s.client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	var customers []Customer
+	for _, doc := range docs {
+		if !doc.Exists() {
+			continue
+		}
+		var c Customer
+		if err = doc.DataTo(&c); err != nil {
+			return nil, err
+		}
+		customers = append(customers, c)
+	}
+	return customers, nil
+}
+
+func (s *firestoreStore) ListCustomers(ctx context.Context, req FindCustomerRequest) ([]Customer, error) {
+	field, desc, err := customerOrder(req.Order)
+	if err != nil {
+		return nil, err
+	}
+	direction := firestore.Asc
+	if desc {
+		direction = firestore.Desc
+	}
+	var query firestore.Query = s.client.Collection("customer").OrderBy(field, direction)
+	if req.Limit > 0 {
+		query = query.Limit(req.Limit)
+	}
+	if req.Offset > 0 {
+		query = query.Offset(req.Offset)
+	}
+	if req.SalesRepID != "" {
+		query = query.Where("SalesRepID", "==", req.SalesRepID)
+	}
+	if !req.IncludeArchived {
+		query = query.Where("ArchivedAt", "==", 0)
+	}
+	if req.Delivery != "" {
+		query = query.Where("Notifications.Delivery", "==", req.Delivery)
+	}
+
+	var customers []Customer
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c Customer
+		if err = doc.DataTo(&c); err != nil {
+			return nil, err
+		}
+		customers = append(customers, c)
+	}
+	return customers, nil
+}
+
+func (s *firestoreStore) ListCustomerChanges(ctx context.Context, customerID string) ([]CustomerChange, error) {
+	iter := s.client.Collection("customerChange").Where("CustomerID", "==", customerID).
+		OrderBy("CreatedAt", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+	var changes []CustomerChange
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c CustomerChange
+		if err = doc.DataTo(&c); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func (s *firestoreStore) SearchCustomers(ctx context.Context, q CustomerSearchQuery) ([]CustomerSearchEntry, error) {
+	var query firestore.Query = s.client.Collection("customerSearch").Where("Keys", "array-contains-any", q.Keys)
+	if q.SalesRepID != "" {
+		query = query.Where("SalesRepID", "==", q.SalesRepID)
+	}
+	if !q.IncludeArchived {
+		query = query.Where("Archived", "==", false)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	var entries []CustomerSearchEntry
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e CustomerSearchEntry
+		if err = doc.DataTo(&e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *firestoreStore) GetAccount(ctx context.Context, number string) (*Account, error) {
+	var account Account
+	if err := s.getDoc(ctx, "account/"+number, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (s *firestoreStore) ListAccounts(ctx context.Context, q AccountQuery) ([]Account, error) {
+	return readAccounts(ctx, accountQuery(s.client, q).Documents(ctx))
+}
+
+// accountQuery returns the Firestore query of q.
+func accountQuery(client *firestore.Client, q AccountQuery) firestore.Query {
+	var query firestore.Query = client.Collection("account").Query
+	if q.CustomerID != "" {
+		query = query.Where("CustomerID", "==", q.CustomerID)
+	}
+	if q.Type != "" {
+		query = query.Where("Type", "==", q.Type)
+	}
+	if q.PositiveBalance {
+		query = query.Where("Balance", ">", 0)
+	}
+	if q.LastPaymentFrom > 0 {
+		query = query.Where("LastPaymentDate", ">=", q.LastPaymentFrom)
+	}
+	if q.LastPaymentTo > 0 {
+		query = query.Where("LastPaymentDate", "<=", q.LastPaymentTo)
+	}
+	if q.LastPaymentFrom > 0 || q.LastPaymentTo > 0 {
+		query = query.OrderBy("LastPaymentDate", firestore.Asc)
+	} else {
+		query = query.OrderBy("CreatedAt", firestore.Desc)
+	}
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		query = query.Offset(q.Offset)
+	}
+	if q.SalesRepID != "" {
+		query = query.Where("SalesRepID", "==", q.SalesRepID)
+	}
+	return query
+}
+
+func readAccounts(ctx context.Context, iter *firestore.DocumentIterator) ([]Account, error) {
+	defer iter.Stop()
+	var accounts []Account
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var a Account
+		if err = dataTo(doc, &a); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (s *firestoreStore) GetTransaction(ctx context.Context, receiptNo string) (*Transaction, error) {
+	var tx Transaction
+	if err := s.getDoc(ctx, "transaction/"+receiptNo, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (s *firestoreStore) ListTransactions(ctx context.Context, q TransactionQuery) ([]Transaction, error) {
+	return readTransactions(ctx, transactionQuery(s.client, q).Documents(ctx))
+}
+
+// transactionQuery returns the Firestore query of q.
+func transactionQuery(client *firestore.Client, q TransactionQuery) firestore.Query {
+	var query firestore.Query = client.Collection("transaction").Query
+	if q.AccountNumber != "" {
+		query = query.Where("AccountNumber", "==", q.AccountNumber)
+	}
+	if q.CycleID != "" {
+		query = query.Where("CycleID", "==", q.CycleID)
+	}
+	if q.From > 0 {
+		query = query.Where("CreatedAt", ">=", q.From)
+	}
+	if q.To > 0 {
+		query = query.Where("CreatedAt", "<=", q.To)
+	}
+	query = query.OrderBy("CreatedAt", firestore.Desc)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	return query
+}
+
+func readTransactions(ctx context.Context, iter *firestore.DocumentIterator) ([]Transaction, error) {
+	defer iter.Stop()
+	var transactions []Transaction
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var tx Transaction
+		if err = dataTo(doc, &tx); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions, nil
+}
+
+func (s *firestoreStore) GetCommission(ctx context.Context, id string) (*DSCommission, error) {
+	var commission DSCommission
+	if err := s.getDoc(ctx, "commission/"+id, &commission); err != nil {
+		return nil, err
+	}
+	return &commission, nil
+}
+
+func (s *firestoreStore) GetCommissionPolicy(ctx context.Context, id string) (*CommissionPolicy, error) {
+	var policy CommissionPolicy
+	if err := s.getDoc(ctx, "commissionPolicy/"+id, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (s *firestoreStore) ListCommissionPolicies(ctx context.Context) ([]CommissionPolicy, error) {
+	iter := s.client.Collection("commissionPolicy").OrderBy("EffectiveFrom", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	var policies []CommissionPolicy
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var p CommissionPolicy
+		if err = dataTo(doc, &p); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+func (s *firestoreStore) GetCycle(ctx context.Context, id string) (*DSCycle, error) {
+	var cycle DSCycle
+	if err := s.getDoc(ctx, "dsCycle/"+id, &cycle); err != nil {
+		return nil, err
+	}
+	return &cycle, nil
+}
+
+func (s *firestoreStore) ListCycles(ctx context.Context, accountNumber string) ([]DSCycle, error) {
+	iter := s.client.Collection("dsCycle").
+		Where("AccountNumber", "==", accountNumber).
+		OrderBy("StartDate", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+	var cycles []DSCycle
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c DSCycle
+		if err = dataTo(doc, &c); err != nil {
+			return nil, err
+		}
+		cycles = append(cycles, c)
+	}
+	return cycles, nil
+}
+
+func (s *firestoreStore) GetDailySummary(ctx context.Context, branchID string, day int64) (*DailySummary, error) {
+	var summary DailySummary
+	if err := s.getDoc(ctx, "dailySummary/"+dailySummaryID(branchID, day), &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func (s *firestoreStore) ListDailySummaries(ctx context.Context, q DailySummaryQuery) ([]DailySummary, error) {
+	query := s.client.Collection("dailySummary").Where("BranchID", "==", q.BranchID)
+	if q.From > 0 {
+		query = query.Where("Date", ">=", q.From)
+	}
+	if q.To > 0 {
+		query = query.Where("Date", "<=", q.To)
+	}
+	query = query.OrderBy("Date", firestore.Desc).Offset(q.Offset)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	var summaries []DailySummary
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var summary DailySummary
+		if err = dataTo(doc, &summary); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+func (s *firestoreStore) GetDayLock(ctx context.Context, branchID string) (*DayLock, error) {
+	var lock DayLock
+	if err := s.getDoc(ctx, "dayLock/"+branchKey(branchID), &lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (s *firestoreStore) GetExpense(ctx context.Context, id string) (*Expense, error) {
+	var expense Expense
+	if err := s.getDoc(ctx, "expense/"+id, &expense); err != nil {
+		return nil, err
+	}
+	return &expense, nil
+}
+
+func (s *firestoreStore) ListExpenses(ctx context.Context, q ExpenseQuery) ([]Expense, error) {
+	var query firestore.Query = s.client.Collection("expense").Query
+	if q.BranchID != "" {
+		query = query.Where("BranchID", "==", q.BranchID)
+	}
+	if q.Category != "" {
+		query = query.Where("Category", "==", q.Category)
+	}
+	if !q.IncludeArchived {
+		query = query.Where("ArchivedAt", "==", 0)
+	}
+	if q.From > 0 {
+		query = query.Where("Date", ">=", q.From)
+	}
+	if q.To > 0 {
+		query = query.Where("Date", "<=", q.To)
+	}
+	query = query.OrderBy("Date", firestore.Desc).OrderBy("CreatedAt", firestore.Desc).Offset(q.Offset)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	var expenses []Expense
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e Expense
+		if err = dataTo(doc, &e); err != nil {
+			return nil, err
+		}
+		expenses = append(expenses, e)
+	}
+	return expenses, nil
+}
+
+func (s *firestoreStore) ListLodgements(ctx context.Context, q LodgementQuery) ([]Lodgement, error) {
+	var query firestore.Query = s.client.Collection("lodgement").Query
+	if q.BranchID != "" {
+		query = query.Where("BranchID", "==", q.BranchID)
+	}
+	if q.From > 0 {
+		query = query.Where("Date", ">=", q.From)
+	}
+	if q.To > 0 {
+		query = query.Where("Date", "<=", q.To)
+	}
+	query = query.OrderBy("Date", firestore.Desc).OrderBy("CreatedAt", firestore.Desc).Offset(q.Offset)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	var lodgements []Lodgement
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var l Lodgement
+		if err = dataTo(doc, &l); err != nil {
+			return nil, err
+		}
+		lodgements = append(lodgements, l)
+	}
+	return lodgements, nil
+}
+
+func (s *firestoreStore) GetNotification(ctx context.Context, id string) (*Notification, error) {
+	var n Notification
+	if err := s.getDoc(ctx, "notification/"+id, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (s *firestoreStore) ListNotifications(ctx context.Context, q NotificationQuery) ([]Notification, error) {
+	var query firestore.Query = s.client.Collection("notification").Query
+	if q.Status != "" {
+		query = query.Where("Status", "==", q.Status)
+	}
+	if q.CustomerID != "" {
+		query = query.Where("CustomerID", "==", q.CustomerID)
+	}
+	if q.MessageID != "" {
+		query = query.Where("ProviderMessageID", "==", q.MessageID)
+	}
+	if q.Failed {
+		query = query.Where("Failed", "==", true)
+	}
+	if q.DueBefore > 0 {
+		query = query.Where("NextAttemptAt", "<=", q.DueBefore).OrderBy("NextAttemptAt", firestore.Asc)
+	} else {
+		query = query.OrderBy("CreatedAt", firestore.Desc)
+	}
+	query = query.Offset(q.Offset)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	var notifications []Notification
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var n Notification
+		if err = dataTo(doc, &n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+func (s *firestoreStore) GetRemittance(ctx context.Context, id string) (*Remittance, error) {
+	var remittance Remittance
+	if err := s.getDoc(ctx, "remittance/"+id, &remittance); err != nil {
+		return nil, err
+	}
+	return &remittance, nil
+}
+
+func (s *firestoreStore) ListRemittances(ctx context.Context, q RemittanceQuery) ([]Remittance, error) {
+	var query firestore.Query = s.client.Collection("remittance").Query
+	if q.SalesRepID != "" {
+		query = query.Where("SalesRepID", "==", q.SalesRepID)
+	}
+	if q.Status != "" {
+		query = query.Where("Status", "==", q.Status)
+	}
+	if q.From > 0 {
+		query = query.Where("Date", ">=", q.From)
+	}
+	if q.To > 0 {
+		query = query.Where("Date", "<=", q.To)
+	}
+	query = query.OrderBy("Date", firestore.Desc).OrderBy("SubmittedAt", firestore.Desc).Offset(q.Offset)
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	var remittances []Remittance
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var r Remittance
+		if err = dataTo(doc, &r); err != nil {
+			return nil, err
+		}
+		remittances = append(remittances, r)
+	}
+	return remittances, nil
+}
+
+// ListLedgerAccounts derives the ledger accounts from their totals. Posting
+// only writes the counters, so the account documents never exist and are
+// listed as missing documents.
+func (s *firestoreStore) ListLedgerAccounts(ctx context.Context) ([]LedgerAccount, error) {
+	var accounts []LedgerAccount
+	iter := s.client.Collection("ledgerAccount").DocumentRefs(ctx)
+	for {
+		ref, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		a := newLedgerAccount(ref.ID)
+		if a.Debit, err = s.Total(ctx, ledgerTotalPath(a.Code, "debit")); err != nil {
+			return nil, err
+		}
+		if a.Credit, err = s.Total(ctx, ledgerTotalPath(a.Code, "credit")); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (s *firestoreStore) ListLedgerEntries(ctx context.Context, q LedgerQuery) ([]LedgerEntry, error) {
+	var query firestore.Query = s.client.Collection("ledger").Query
+	if q.Ledger != "" {
+		query = query.Where("Ledger", "==", q.Ledger)
+	}
+	if q.ReceiptNo != "" {
+		query = query.Where("ReceiptNo", "==", q.ReceiptNo)
+	}
+	if q.From > 0 {
+		query = query.Where("Date", ">=", q.From)
+	}
+	if q.To > 0 {
+		query = query.Where("Date", "<=", q.To)
+	}
+	query = query.OrderBy("Date", firestore.Asc).OrderBy("CreatedAt", firestore.Asc)
+
+	var entries []LedgerEntry
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e LedgerEntry
+		if err = doc.DataTo(&e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *firestoreStore) ListExpiredIdempotencyRecords(ctx context.Context, before int64, limit int) ([]IdempotencyRecord, error) {
+	query := s.client.Collection("idempotencyKey").Where("ExpiresAt", "<", before).Limit(limit)
+
+	var records []IdempotencyRecord
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec IdempotencyRecord
+		if err = doc.DataTo(&rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (s *firestoreStore) NextSequence(ctx context.Context, name string) (int64, error) {
+	return s.sequences.next(ctx, name)
+}
+
+// reserveSequence atomically reserves size values of the named sequence and
+// records the block under sequence/<name>/blocks.
+func (s *firestoreStore) reserveSequence(ctx context.Context, name string, size int64) (int64, error) {
+	var start int64
+	seqRef := s.client.Doc("sequence/" + name)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, t *firestore.Transaction) error {
+		seq := Sequence{Name: name, Next: 1}
+		docSnap, err := t.Get(seqRef)
+		if err != nil && (docSnap == nil || docSnap.Exists()) {
+			return err
+		}
+		if docSnap.Exists() {
+			if err = docSnap.DataTo(&seq); err != nil {
+				return err
+			}
+		}
+		start = seq.Next
+		block := SequenceBlock{
+			Sequence:   name,
+			Start:      start,
+			End:        start + size - 1,
+			ReservedAt: timeNow().Unix(),
+		}
+		seq.Next += size
+		if err = t.Set(seqRef, seq); err != nil {
+			return err
+		}
+		return t.Create(seqRef.Collection("blocks").Doc(strconv.FormatInt(start, 10)), block)
+	})
+	return start, err
+}
+
+func (s *firestoreStore) ListSequenceBlocks(ctx context.Context, name string) ([]SequenceBlock, error) {
+	var blocks []SequenceBlock
+	iter := s.client.Collection("sequence/"+name+"/blocks").OrderBy("Start", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var block SequenceBlock
+		if err = doc.DataTo(&block); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// ledgerTotalPath returns the path of the debit or credit counter of a ledger account.
+func ledgerTotalPath(code, side string) string {
+	return "ledgerAccount/" + code + "/totals/" + side
+}
+
+// counterDocPath returns the document of the stat counter at path. Stat
+// paths with an odd number of segments name a collection, their counter is
+// kept in its "value" document.
+func counterDocPath(path string) string {
+	if strings.Count(path, "/")%2 == 0 {
+		return path + "/value"
+	}
+	return path
+}
+
+func (s *firestoreStore) counter(path string) *counter.Counter {
+	return counter.New(s.client.Doc(counterDocPath(path)), s.counterShards)
+}
+
+func (s *firestoreStore) ListCounterKeys(ctx context.Context, parent string) ([]string, error) {
+	var keys []string
+	if strings.Count(parent, "/")%2 == 0 {
+		iter := s.client.Collection(parent).DocumentRefs(ctx)
+		for {
+			ref, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, ref.ID)
+		}
+		return keys, nil
+	}
+	iter := s.client.Doc(parent).Collections(ctx)
+	for {
+		col, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, col.ID)
+	}
+	return keys, nil
+}
+
+func (s *firestoreStore) Count(ctx context.Context, path string) (int64, error) {
+	return s.counterCache.Get(ctx, path, counter.Int{Counter: s.counter(path)}.Value)
+}
+
+func (s *firestoreStore) Total(ctx context.Context, path string) (Money, error) {
+	total, err := s.counterCache.Get(ctx, path, counter.Amount{
+		Counter: s.counter(path),
+		ToMinor: legacyNairaToKobo,
+	}.Value)
+	return Money(total), err
+}
+
+// writer returns a firestoreWriter writing through the given functions.
+func (s *firestoreStore) writer(
+	create func(dr *firestore.DocumentRef, data interface{}) error,
+	set func(dr *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error,
+	update func(dr *firestore.DocumentRef, data []firestore.Update) error,
+	delete func(dr *firestore.DocumentRef) error) firestoreWriter {
+
+	return firestoreWriter{
+		client: s.client,
+		create: create,
+		set:    set,
+		update: update,
+		delete: delete,
+		store:  s,
+	}
+}
+
+func (s *firestoreStore) Batch() Batch {
+	b := s.client.Batch()
+	return &firestoreBatch{
+		firestoreWriter: s.writer(
+			func(dr *firestore.DocumentRef, data interface{}) error {
+				b.Create(dr, data)
+				return nil
+			},
+			func(dr *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error {
+				b.Set(dr, data, opts...)
+				return nil
+			},
+			func(dr *firestore.DocumentRef, data []firestore.Update) error {
+				b.Update(dr, data)
+				return nil
+			},
+			func(dr *firestore.DocumentRef) error {
+				b.Delete(dr)
+				return nil
+			},
+		),
+		batch: b,
+	}
+}
+
+func (s *firestoreStore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	var paths []string
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, t *firestore.Transaction) error {
+		tx := &firestoreTx{
+			firestoreWriter: s.writer(
+				t.Create,
+				t.Set,
+				func(dr *firestore.DocumentRef, data []firestore.Update) error {
+					return t.Update(dr, data)
+				},
+				func(dr *firestore.DocumentRef) error {
+					return t.Delete(dr)
+				},
+			),
+			tx: t,
+		}
+		if err := fn(ctx, tx); err != nil {
+			return err
+		}
+		paths = tx.counterPaths()
+		return tx.flush()
+	})
+	if err == nil {
+		s.counterCache.Invalidate(paths...)
+	}
+	return err
+}
+
+type counterIncrement struct {
+	path string
+	inc  int64
+}
+
+// firestoreWriter implements Writer on top of the write functions of either
+// a firestore.WriteBatch or a firestore.Transaction. Counter increments are
+// collected and added on flush.
+type firestoreWriter struct {
+	client   *firestore.Client
+	create   func(dr *firestore.DocumentRef, data interface{}) error
+	set      func(dr *firestore.DocumentRef, data interface{}, opts ...firestore.SetOption) error
+	update   func(dr *firestore.DocumentRef, data []firestore.Update) error
+	delete   func(dr *firestore.DocumentRef) error
+	store    *firestoreStore
+	counters []counterIncrement
+	err      error
+}
+
+func (w *firestoreWriter) record(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *firestoreWriter) CreateCustomer(customer Customer) {
+	w.record(w.create(w.client.Doc("customer/"+customer.ID), customer))
+}
+
+func (w *firestoreWriter) UpdateCustomer(customer Customer) {
+	w.record(w.update(w.client.Doc("customer/"+customer.ID), []firestore.Update{
+		{Path: "Name", Value: customer.Name},
+		{Path: "ShortName", Value: customer.ShortName},
+		{Path: "Email", Value: customer.Email},
+		{Path: "PhoneNumber", Value: customer.PhoneNumber},
+		{Path: "Address", Value: customer.Address},
+		{Path: "SalesRepID", Value: customer.SalesRepID},
+		{Path: "SalesRep", Value: customer.SalesRep},
+		{Path: "BranchID", Value: customer.BranchID},
+		{Path: "Branch", Value: customer.Branch},
+		{Path: "Language", Value: customer.Language},
+		{Path: "Notifications", Value: customer.Notifications},
+		{Path: "UpdatedAt", Value: customer.UpdatedAt},
+		{Path: "ArchivedAt", Value: customer.ArchivedAt},
+	}))
+}
+
+func (w *firestoreWriter) CreateCustomerChange(change CustomerChange) {
+	w.record(w.create(w.client.Doc("customerChange/"+change.ID), change))
+}
+
+func (w *firestoreWriter) SetCustomerSearchEntry(entry CustomerSearchEntry) {
+	w.record(w.set(w.client.Doc("customerSearch/"+entry.CustomerID), entry))
+}
+
+func (w *firestoreWriter) CreateAccount(account Account) {
+	w.record(w.create(w.client.Doc("account/"+account.Number), account))
+}
+
+func (w *firestoreWriter) UpdateAccount(account Account) {
+	w.record(w.update(w.client.Doc("account/"+account.Number), []firestore.Update{
+		{Path: "Balance", Value: account.Balance},
+		{Path: "LastPaymentDate", Value: account.LastPaymentDate},
+		{Path: "LastCommissionDate", Value: account.LastCommissionDate},
+		{Path: "CurrentCycleID", Value: account.CurrentCycleID},
+		{Path: "RecentTransactions", Value: account.RecentTransactions},
+	}))
+}
+
+func (w *firestoreWriter) RenameAccountCustomer(account Account) {
+	w.record(w.update(w.client.Doc("account/"+account.Number), []firestore.Update{
+		{Path: "Customer", Value: account.Customer},
+		{Path: "RecentTransactions", Value: account.RecentTransactions},
+	}))
+}
+
+func (w *firestoreWriter) CreateTransaction(tx Transaction) {
+	w.record(w.create(w.client.Doc("transaction/"+tx.ReceiptNo), tx))
+}
+
+func (w *firestoreWriter) RenameTransactionCustomer(receiptNo, customerName string) {
+	w.record(w.update(w.client.Doc("transaction/"+receiptNo), []firestore.Update{
+		{Path: "CustomerName", Value: customerName},
+	}))
+}
+
+func (w *firestoreWriter) ReverseTransaction(receiptNo, reversalReceiptNo string, reversedAt int64) {
+	w.record(w.update(w.client.Doc("transaction/"+receiptNo), []firestore.Update{
+		{Path: "ReversedBy", Value: reversalReceiptNo},
+		{Path: "ReversedAt", Value: reversedAt},
+		{Path: "UpdatedAt", Value: reversedAt},
+	}))
+}
+
+func (w *firestoreWriter) CreateCommission(commission DSCommission) {
+	w.record(w.create(w.client.Doc("commission/"+commission.ID), commission))
+}
+
+func (w *firestoreWriter) ReverseCommission(id string, reversedAt int64) {
+	w.record(w.update(w.client.Doc("commission/"+id), []firestore.Update{
+		{Path: "ReversedAt", Value: reversedAt},
+	}))
+}
+
+func (w *firestoreWriter) CreateCommissionPolicy(policy CommissionPolicy) {
+	w.record(w.create(w.client.Doc("commissionPolicy/"+policy.ID), policy))
+}
+
+func (w *firestoreWriter) CreateCycle(cycle DSCycle) {
+	w.record(w.create(w.client.Doc("dsCycle/"+cycle.ID), cycle))
+}
+
+func (w *firestoreWriter) UpdateCycle(cycle DSCycle) {
+	w.record(w.update(w.client.Doc("dsCycle/"+cycle.ID), []firestore.Update{
+		{Path: "DaysPaid", Value: cycle.DaysPaid},
+		{Path: "PaidThrough", Value: cycle.PaidThrough},
+		{Path: "AmountPaid", Value: cycle.AmountPaid},
+		{Path: "FeeTaken", Value: cycle.FeeTaken},
+		{Path: "CommissionID", Value: cycle.CommissionID},
+		{Path: "AmountPayable", Value: cycle.AmountPayable},
+		{Path: "PaidOut", Value: cycle.PaidOut},
+		{Path: "PayoutReceiptNo", Value: cycle.PayoutReceiptNo},
+		{Path: "Status", Value: cycle.Status},
+		{Path: "ClosedAt", Value: cycle.ClosedAt},
+		{Path: "UpdatedAt", Value: cycle.UpdatedAt},
+	}))
+}
+
+func (w *firestoreWriter) IncrementDailySummary(branchID string, day int64, delta DailySummary) {
+	w.record(w.set(w.client.Doc("dailySummary/"+dailySummaryID(branchID, day)), map[string]interface{}{
+		"BranchID":        branchID,
+		"Date":            day,
+		"Income":          firestore.Increment(int64(delta.Income)),
+		"DepositCount":    firestore.Increment(delta.DepositCount),
+		"Withdrawals":     firestore.Increment(int64(delta.Withdrawals)),
+		"WithdrawalCount": firestore.Increment(delta.WithdrawalCount),
+		"Fees":            firestore.Increment(int64(delta.Fees)),
+		"Expenditure":     firestore.Increment(int64(delta.Expenditure)),
+		"BankDeposit":     firestore.Increment(int64(delta.BankDeposit)),
+		"Lodged":          firestore.Increment(int64(delta.Lodged)),
+		"CashIn":          firestore.Increment(int64(delta.CashIn)),
+		"CashOut":         firestore.Increment(int64(delta.CashOut)),
+	}, firestore.MergeAll))
+}
+
+func (w *firestoreWriter) CloseDailySummary(summary DailySummary) {
+	w.record(w.set(w.client.Doc("dailySummary/"+dailySummaryID(summary.BranchID, summary.Date)), summary))
+}
+
+func (w *firestoreWriter) SetDayLock(lock DayLock) {
+	w.record(w.set(w.client.Doc("dayLock/"+branchKey(lock.BranchID)), lock))
+}
+
+func (w *firestoreWriter) CreateExpense(expense Expense) {
+	w.record(w.create(w.client.Doc("expense/"+expense.ID), expense))
+}
+
+func (w *firestoreWriter) ArchiveExpense(expense Expense) {
+	w.record(w.update(w.client.Doc("expense/"+expense.ID), []firestore.Update{
+		{Path: "ArchivedAt", Value: expense.ArchivedAt},
+		{Path: "ArchivedBy", Value: expense.ArchivedBy},
+		{Path: "ArchiveReason", Value: expense.ArchiveReason},
+	}))
+}
+
+func (w *firestoreWriter) CreateLodgement(lodgement Lodgement) {
+	w.record(w.create(w.client.Doc("lodgement/"+lodgement.ID), lodgement))
+}
+
+func (w *firestoreWriter) CreateNotification(n Notification) {
+	w.record(w.create(w.client.Doc("notification/"+n.ID), n))
+}
+
+func (w *firestoreWriter) UpdateNotification(n Notification) {
+	w.record(w.update(w.client.Doc("notification/"+n.ID), []firestore.Update{
+		{Path: "Status", Value: n.Status},
+		{Path: "Attempts", Value: n.Attempts},
+		{Path: "NextAttemptAt", Value: n.NextAttemptAt},
+		{Path: "Failed", Value: n.Failed},
+		{Path: "LastError", Value: n.LastError},
+		{Path: "SentAt", Value: n.SentAt},
+		{Path: "ProviderMessageID", Value: n.ProviderMessageID},
+		{Path: "Cost", Value: n.Cost},
+		{Path: "Currency", Value: n.Currency},
+		{Path: "Units", Value: n.Units},
+		{Path: "ErrorCode", Value: n.ErrorCode},
+		{Path: "DeliveryStatus", Value: n.DeliveryStatus},
+		{Path: "DeliveredAt", Value: n.DeliveredAt},
+		{Path: "UpdatedAt", Value: n.UpdatedAt},
+	}))
+}
+
+func (w *firestoreWriter) CreateRemittance(remittance Remittance) {
+	w.record(w.create(w.client.Doc("remittance/"+remittance.ID), remittance))
+}
+
+func (w *firestoreWriter) UpdateRemittance(remittance Remittance) {
+	w.record(w.update(w.client.Doc("remittance/"+remittance.ID), []firestore.Update{
+		{Path: "Status", Value: remittance.Status},
+		{Path: "ReceivedAmount", Value: remittance.ReceivedAmount},
+		{Path: "Shortage", Value: remittance.Shortage},
+		{Path: "ReviewedBy", Value: remittance.ReviewedBy},
+		{Path: "ReviewNote", Value: remittance.ReviewNote},
+		{Path: "ReviewedAt", Value: remittance.ReviewedAt},
+	}))
+}
+
+func (w *firestoreWriter) PostJournal(journal Journal) {
+	if err := journal.validate(); err != nil {
+		w.record(err)
+		return
+	}
+	for _, e := range journal.Entries {
+		w.record(w.create(w.client.Doc("ledger/"+e.ID), e))
+		if e.Debit != 0 {
+			w.IncrementTotal(ledgerTotalPath(e.Ledger, "debit"), e.Debit)
+		}
+		if e.Credit != 0 {
+			w.IncrementTotal(ledgerTotalPath(e.Ledger, "credit"), e.Credit)
+		}
+	}
+}
+
+func (w *firestoreWriter) SetIdempotencyRecord(rec IdempotencyRecord) {
+	w.record(w.set(w.client.Doc("idempotencyKey/"+rec.ID), rec))
+}
+
+func (w *firestoreWriter) DeleteIdempotencyRecord(id string) {
+	w.record(w.delete(w.client.Doc("idempotencyKey/" + id)))
+}
+
+func (w *firestoreWriter) IncrementCount(path string, n int64) {
+	w.counters = append(w.counters, counterIncrement{path: path, inc: n})
+}
+
+func (w *firestoreWriter) IncrementTotal(path string, amount Money) {
+	w.counters = append(w.counters, counterIncrement{path: path, inc: int64(amount)})
+}
+
+// flush adds the collected counter increments and returns the first error
+// recorded by a write. Shards are created by the increment itself so no read
+// is needed.
+func (w *firestoreWriter) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	for _, c := range w.counters {
+		shard, data := w.store.counter(c.path).Increment(c.inc)
+		if err := w.set(shard, data, firestore.MergeAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// counterPaths returns the paths of the incremented counters.
+func (w *firestoreWriter) counterPaths() []string {
+	paths := make([]string, len(w.counters))
+	for i, c := range w.counters {
+		paths[i] = c.path
+	}
+	return paths
+}
+
+type firestoreBatch struct {
+	firestoreWriter
+	batch *firestore.WriteBatch
+}
+
+func (b *firestoreBatch) Commit(ctx context.Context) error {
+	if err := b.flush(); err != nil {
+		return err
+	}
+	if _, err := b.batch.Commit(ctx); err != nil {
+		return err
+	}
+	b.store.counterCache.Invalidate(b.counterPaths()...)
+	return nil
+}
+
+type firestoreTx struct {
+	firestoreWriter
+	tx *firestore.Transaction
+}
+
+func (t *firestoreTx) getDoc(ctx context.Context, path string, v interface{}) error {
+	docSnap, err := t.tx.Get(t.client.Doc(path))
+	if docSnap != nil && !docSnap.Exists() {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return dataTo(docSnap, v)
+}
+
+func (t *firestoreTx) GetCustomer(ctx context.Context, id string) (*Customer, error) {
+	var customer Customer
+	if err := t.getDoc(ctx, "customer/"+id, &customer); err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+func (t *firestoreTx) GetAccount(ctx context.Context, number string) (*Account, error) {
+	var account Account
+	if err := t.getDoc(ctx, "account/"+number, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (t *firestoreTx) ListAccounts(ctx context.Context, q AccountQuery) ([]Account, error) {
+	return readAccounts(ctx, t.tx.Documents(accountQuery(t.client, q)))
+}
+
+func (t *firestoreTx) GetTransaction(ctx context.Context, receiptNo string) (*Transaction, error) {
+	var tx Transaction
+	if err := t.getDoc(ctx, "transaction/"+receiptNo, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (t *firestoreTx) ListTransactions(ctx context.Context, q TransactionQuery) ([]Transaction, error) {
+	return readTransactions(ctx, t.tx.Documents(transactionQuery(t.client, q)))
+}
+
+func (t *firestoreTx) GetCommission(ctx context.Context, id string) (*DSCommission, error) {
+	var commission DSCommission
+	if err := t.getDoc(ctx, "commission/"+id, &commission); err != nil {
+		return nil, err
+	}
+	return &commission, nil
+}
+
+func (t *firestoreTx) GetCommissionPolicy(ctx context.Context, id string) (*CommissionPolicy, error) {
+	var policy CommissionPolicy
+	if err := t.getDoc(ctx, "commissionPolicy/"+id, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (t *firestoreTx) GetCycle(ctx context.Context, id string) (*DSCycle, error) {
+	var cycle DSCycle
+	if err := t.getDoc(ctx, "dsCycle/"+id, &cycle); err != nil {
+		return nil, err
+	}
+	return &cycle, nil
+}
+
+func (t *firestoreTx) GetDailySummary(ctx context.Context, branchID string, day int64) (*DailySummary, error) {
+	var summary DailySummary
+	if err := t.getDoc(ctx, "dailySummary/"+dailySummaryID(branchID, day), &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func (t *firestoreTx) GetDayLock(ctx context.Context, branchID string) (*DayLock, error) {
+	var lock DayLock
+	if err := t.getDoc(ctx, "dayLock/"+branchKey(branchID), &lock); err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+func (t *firestoreTx) GetExpense(ctx context.Context, id string) (*Expense, error) {
+	var expense Expense
+	if err := t.getDoc(ctx, "expense/"+id, &expense); err != nil {
+		return nil, err
+	}
+	return &expense, nil
+}
+
+func (t *firestoreTx) GetNotification(ctx context.Context, id string) (*Notification, error) {
+	var n Notification
+	if err := t.getDoc(ctx, "notification/"+id, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (t *firestoreTx) GetRemittance(ctx context.Context, id string) (*Remittance, error) {
+	var remittance Remittance
+	if err := t.getDoc(ctx, "remittance/"+id, &remittance); err != nil {
+		return nil, err
+	}
+	return &remittance, nil
+}
+
+func (t *firestoreTx) GetIdempotencyRecord(ctx context.Context, id string) (*IdempotencyRecord, error) {
+	var rec IdempotencyRecord
+	if err := t.getDoc(ctx, "idempotencyKey/"+id, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
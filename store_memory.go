@@ -0,0 +1,1147 @@
+package surebankltd
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryStore is a Store that keeps every document in memory. It is meant
+// for tests and local runs.
+type MemoryStore struct {
+	// txMu serializes transactions, mu guards the documents.
+	txMu           sync.Mutex
+	mu             sync.Mutex
+	customers      map[string]Customer
+	changes        map[string]CustomerChange
+	searchEntries  map[string]CustomerSearchEntry
+	accounts       map[string]Account
+	transactions   map[string]Transaction
+	commissions    map[string]DSCommission
+	policies       map[string]CommissionPolicy
+	cycles         map[string]DSCycle
+	dailySummaries map[string]DailySummary
+	dayLocks       map[string]DayLock
+	expenses       map[string]Expense
+	lodgements     map[string]Lodgement
+	notifications  map[string]Notification
+	remittances    map[string]Remittance
+	ledgerEntries  []LedgerEntry
+	ledgerAccounts map[string]LedgerAccount
+	idempotency    map[string]IdempotencyRecord
+	sequences      map[string][]SequenceBlock
+	counts         map[string]int64
+	totals         map[string]Money
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		customers:      map[string]Customer{},
+		changes:        map[string]CustomerChange{},
+		searchEntries:  map[string]CustomerSearchEntry{},
+		accounts:       map[string]Account{},
+		transactions:   map[string]Transaction{},
+		commissions:    map[string]DSCommission{},
+		policies:       map[string]CommissionPolicy{},
+		cycles:         map[string]DSCycle{},
+		dailySummaries: map[string]DailySummary{},
+		dayLocks:       map[string]DayLock{},
+		expenses:       map[string]Expense{},
+		lodgements:     map[string]Lodgement{},
+		notifications:  map[string]Notification{},
+		remittances:    map[string]Remittance{},
+		ledgerAccounts: map[string]LedgerAccount{},
+		idempotency:    map[string]IdempotencyRecord{},
+		sequences:      map[string][]SequenceBlock{},
+		counts:         map[string]int64{},
+		totals:         map[string]Money{},
+	}
+}
+
+func (s *MemoryStore) GetCustomer(ctx context.Context, id string) (*Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	customer, ok := s.customers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &customer, nil
+}
+
+func (s *MemoryStore) GetCustomers(ctx context.Context, ids []string) ([]Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var customers []Customer
+	for _, id := range ids {
+		if customer, ok := s.customers[id]; ok {
+			customers = append(customers, customer)
+		}
+	}
+	return customers, nil
+}
+
+func (s *MemoryStore) ListCustomers(ctx context.Context, req FindCustomerRequest) ([]Customer, error) {
+	field, desc, err := customerOrder(req.Order)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var customers []Customer
+	for _, c := range s.customers {
+		if req.SalesRepID != "" && c.SalesRepID != req.SalesRepID {
+			continue
+		}
+		if !req.IncludeArchived && c.ArchivedAt > 0 {
+			continue
+		}
+		if req.Delivery != "" && c.Notifications.Delivery != req.Delivery {
+			continue
+		}
+		customers = append(customers, c)
+	}
+	sort.Slice(customers, func(i, j int) bool {
+		a, b := customers[i], customers[j]
+		if desc {
+			a, b = b, a
+		}
+		switch field {
+		case "Name":
+			return a.Name < b.Name
+		case "UpdatedAt":
+			return a.UpdatedAt < b.UpdatedAt
+		}
+		return a.CreatedAt < b.CreatedAt
+	})
+	start, end := pageBounds(len(customers), req.Offset, req.Limit)
+	return customers[start:end], nil
+}
+
+func (s *MemoryStore) ListCustomerChanges(ctx context.Context, customerID string) ([]CustomerChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var changes []CustomerChange
+	for _, c := range s.changes {
+		if c.CustomerID == customerID {
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].CreatedAt > changes[j].CreatedAt
+	})
+	return changes, nil
+}
+
+func (s *MemoryStore) SearchCustomers(ctx context.Context, q CustomerSearchQuery) ([]CustomerSearchEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := map[string]bool{}
+	for _, key := range q.Keys {
+		keys[key] = true
+	}
+	var entries []CustomerSearchEntry
+	for _, e := range s.searchEntries {
+		if (q.SalesRepID != "" && e.SalesRepID != q.SalesRepID) || (!q.IncludeArchived && e.Archived) {
+			continue
+		}
+		for _, key := range e.Keys {
+			if keys[key] {
+				entries = append(entries, e)
+				break
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CustomerID < entries[j].CustomerID
+	})
+	start, end := pageBounds(len(entries), 0, q.Limit)
+	return entries[start:end], nil
+}
+
+func (s *MemoryStore) GetAccount(ctx context.Context, number string) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account, ok := s.accounts[number]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	account = copyAccount(account)
+	return &account, nil
+}
+
+func (s *MemoryStore) ListAccounts(ctx context.Context, q AccountQuery) ([]Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var accounts []Account
+	for _, a := range s.accounts {
+		if q.CustomerID != "" && a.CustomerID != q.CustomerID {
+			continue
+		}
+		if q.Type != "" && a.Type != q.Type {
+			continue
+		}
+		if q.SalesRepID != "" && a.SalesRepID != q.SalesRepID {
+			continue
+		}
+		if q.PositiveBalance && a.Balance <= 0 {
+			continue
+		}
+		if q.LastPaymentFrom > 0 && a.LastPaymentDate < q.LastPaymentFrom {
+			continue
+		}
+		if q.LastPaymentTo > 0 && a.LastPaymentDate > q.LastPaymentTo {
+			continue
+		}
+		accounts = append(accounts, copyAccount(a))
+	}
+	if q.LastPaymentFrom > 0 || q.LastPaymentTo > 0 {
+		sort.Slice(accounts, func(i, j int) bool {
+			return accounts[i].LastPaymentDate < accounts[j].LastPaymentDate
+		})
+	} else {
+		sort.Slice(accounts, func(i, j int) bool {
+			return accounts[i].CreatedAt > accounts[j].CreatedAt
+		})
+	}
+	start, end := pageBounds(len(accounts), q.Offset, q.Limit)
+	return accounts[start:end], nil
+}
+
+func (s *MemoryStore) GetTransaction(ctx context.Context, receiptNo string) (*Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.transactions[receiptNo]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &tx, nil
+}
+
+func (s *MemoryStore) ListTransactions(ctx context.Context, q TransactionQuery) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var transactions []Transaction
+	for _, tx := range s.transactions {
+		if q.AccountNumber != "" && tx.AccountNumber != q.AccountNumber {
+			continue
+		}
+		if q.CycleID != "" && tx.CycleID != q.CycleID {
+			continue
+		}
+		if q.From > 0 && tx.CreatedAt < q.From {
+			continue
+		}
+		if q.To > 0 && tx.CreatedAt > q.To {
+			continue
+		}
+		transactions = append(transactions, tx)
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].CreatedAt != transactions[j].CreatedAt {
+			return transactions[i].CreatedAt > transactions[j].CreatedAt
+		}
+		return transactions[i].ReceiptNo > transactions[j].ReceiptNo
+	})
+	start, end := pageBounds(len(transactions), 0, q.Limit)
+	return transactions[start:end], nil
+}
+
+func (s *MemoryStore) GetCommission(ctx context.Context, id string) (*DSCommission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	commission, ok := s.commissions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &commission, nil
+}
+
+func (s *MemoryStore) GetCommissionPolicy(ctx context.Context, id string) (*CommissionPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy, ok := s.policies[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &policy, nil
+}
+
+func (s *MemoryStore) ListCommissionPolicies(ctx context.Context) ([]CommissionPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var policies []CommissionPolicy
+	for _, p := range s.policies {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].EffectiveFrom < policies[j].EffectiveFrom
+	})
+	return policies, nil
+}
+
+func (s *MemoryStore) GetCycle(ctx context.Context, id string) (*DSCycle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cycle, ok := s.cycles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &cycle, nil
+}
+
+func (s *MemoryStore) ListCycles(ctx context.Context, accountNumber string) ([]DSCycle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cycles []DSCycle
+	for _, c := range s.cycles {
+		if c.AccountNumber == accountNumber {
+			cycles = append(cycles, c)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].StartDate > cycles[j].StartDate
+	})
+	return cycles, nil
+}
+
+func (s *MemoryStore) GetDailySummary(ctx context.Context, branchID string, day int64) (*DailySummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary, ok := s.dailySummaries[dailySummaryID(branchID, day)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &summary, nil
+}
+
+func (s *MemoryStore) ListDailySummaries(ctx context.Context, q DailySummaryQuery) ([]DailySummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var summaries []DailySummary
+	for _, summary := range s.dailySummaries {
+		if summary.BranchID != q.BranchID {
+			continue
+		}
+		if (q.From > 0 && summary.Date < q.From) || (q.To > 0 && summary.Date > q.To) {
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Date > summaries[j].Date })
+	start, end := pageBounds(len(summaries), q.Offset, q.Limit)
+	return summaries[start:end], nil
+}
+
+func (s *MemoryStore) GetDayLock(ctx context.Context, branchID string) (*DayLock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.dayLocks[branchKey(branchID)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &lock, nil
+}
+
+func (s *MemoryStore) GetExpense(ctx context.Context, id string) (*Expense, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expense, ok := s.expenses[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &expense, nil
+}
+
+func (s *MemoryStore) ListExpenses(ctx context.Context, q ExpenseQuery) ([]Expense, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expenses []Expense
+	for _, e := range s.expenses {
+		if (q.BranchID != "" && e.BranchID != q.BranchID) || (q.Category != "" && e.Category != q.Category) {
+			continue
+		}
+		if (q.From > 0 && e.Date < q.From) || (q.To > 0 && e.Date > q.To) {
+			continue
+		}
+		if !q.IncludeArchived && e.ArchivedAt > 0 {
+			continue
+		}
+		expenses = append(expenses, e)
+	}
+	sort.Slice(expenses, func(i, j int) bool {
+		if expenses[i].Date != expenses[j].Date {
+			return expenses[i].Date > expenses[j].Date
+		}
+		return expenses[i].CreatedAt > expenses[j].CreatedAt
+	})
+	start, end := pageBounds(len(expenses), q.Offset, q.Limit)
+	return expenses[start:end], nil
+}
+
+func (s *MemoryStore) ListLodgements(ctx context.Context, q LodgementQuery) ([]Lodgement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lodgements []Lodgement
+	for _, l := range s.lodgements {
+		if q.BranchID != "" && l.BranchID != q.BranchID {
+			continue
+		}
+		if (q.From > 0 && l.Date < q.From) || (q.To > 0 && l.Date > q.To) {
+			continue
+		}
+		lodgements = append(lodgements, l)
+	}
+	sort.Slice(lodgements, func(i, j int) bool {
+		if lodgements[i].Date != lodgements[j].Date {
+			return lodgements[i].Date > lodgements[j].Date
+		}
+		return lodgements[i].CreatedAt > lodgements[j].CreatedAt
+	})
+	start, end := pageBounds(len(lodgements), q.Offset, q.Limit)
+	return lodgements[start:end], nil
+}
+
+func (s *MemoryStore) GetNotification(ctx context.Context, id string) (*Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.notifications[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &n, nil
+}
+
+func (s *MemoryStore) ListNotifications(ctx context.Context, q NotificationQuery) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var notifications []Notification
+	for _, n := range s.notifications {
+		if (q.Status != "" && n.Status != q.Status) || (q.CustomerID != "" && n.CustomerID != q.CustomerID) {
+			continue
+		}
+		if q.MessageID != "" && n.ProviderMessageID != q.MessageID {
+			continue
+		}
+		if (q.Failed && !n.Failed) || (q.DueBefore > 0 && n.NextAttemptAt > q.DueBefore) {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		if q.DueBefore > 0 {
+			return notifications[i].NextAttemptAt < notifications[j].NextAttemptAt
+		}
+		return notifications[i].CreatedAt > notifications[j].CreatedAt
+	})
+	start, end := pageBounds(len(notifications), q.Offset, q.Limit)
+	return notifications[start:end], nil
+}
+
+func (s *MemoryStore) GetRemittance(ctx context.Context, id string) (*Remittance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	remittance, ok := s.remittances[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &remittance, nil
+}
+
+func (s *MemoryStore) ListRemittances(ctx context.Context, q RemittanceQuery) ([]Remittance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var remittances []Remittance
+	for _, r := range s.remittances {
+		if (q.SalesRepID != "" && r.SalesRepID != q.SalesRepID) || (q.Status != "" && r.Status != q.Status) {
+			continue
+		}
+		if (q.From > 0 && r.Date < q.From) || (q.To > 0 && r.Date > q.To) {
+			continue
+		}
+		remittances = append(remittances, r)
+	}
+	sort.Slice(remittances, func(i, j int) bool {
+		if remittances[i].Date != remittances[j].Date {
+			return remittances[i].Date > remittances[j].Date
+		}
+		return remittances[i].SubmittedAt > remittances[j].SubmittedAt
+	})
+	start, end := pageBounds(len(remittances), q.Offset, q.Limit)
+	return remittances[start:end], nil
+}
+
+func (s *MemoryStore) ListLedgerAccounts(ctx context.Context) ([]LedgerAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var accounts []LedgerAccount
+	for _, a := range s.ledgerAccounts {
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (s *MemoryStore) ListLedgerEntries(ctx context.Context, q LedgerQuery) ([]LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []LedgerEntry
+	for _, e := range s.ledgerEntries {
+		if q.Ledger != "" && e.Ledger != q.Ledger {
+			continue
+		}
+		if q.ReceiptNo != "" && e.ReceiptNo != q.ReceiptNo {
+			continue
+		}
+		if q.From > 0 && e.Date < q.From {
+			continue
+		}
+		if q.To > 0 && e.Date > q.To {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Date != entries[j].Date {
+			return entries[i].Date < entries[j].Date
+		}
+		return entries[i].CreatedAt < entries[j].CreatedAt
+	})
+	return entries, nil
+}
+
+func (s *MemoryStore) ListExpiredIdempotencyRecords(ctx context.Context, before int64, limit int) ([]IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []IdempotencyRecord
+	for _, rec := range s.idempotency {
+		if rec.ExpiresAt < before {
+			records = append(records, rec)
+		}
+		if limit > 0 && len(records) == limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+// NextSequence reserves blocks of a single value so the memory store never
+// leaves gaps.
+func (s *MemoryStore) NextSequence(ctx context.Context, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next int64 = 1
+	if blocks := s.sequences[name]; len(blocks) > 0 {
+		next = blocks[len(blocks)-1].End + 1
+	}
+	s.sequences[name] = append(s.sequences[name], SequenceBlock{
+		Sequence:   name,
+		Start:      next,
+		End:        next,
+		ReservedAt: timeNow().Unix(),
+	})
+	return next, nil
+}
+
+func (s *MemoryStore) ListSequenceBlocks(ctx context.Context, name string) ([]SequenceBlock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SequenceBlock(nil), s.sequences[name]...), nil
+}
+
+func (s *MemoryStore) Count(ctx context.Context, path string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[path], nil
+}
+
+func (s *MemoryStore) Total(ctx context.Context, path string) (Money, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totals[path], nil
+}
+
+func (s *MemoryStore) ListCounterKeys(ctx context.Context, parent string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := parent + "/"
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !strings.HasPrefix(path, prefix) {
+			return
+		}
+		key := strings.SplitN(path[len(prefix):], "/", 2)[0]
+		seen[key] = true
+	}
+	for path := range s.counts {
+		add(path)
+	}
+	for path := range s.totals {
+		add(path)
+	}
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemoryStore) Batch() Batch {
+	return &memoryBatch{store: s}
+}
+
+func (s *MemoryStore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	tx := &memoryTx{memoryBatch: memoryBatch{store: s}}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// memoryTx reads straight from the store. Transactions never conflict
+// because they are serialized by txMu. Like Firestore, it refuses reads made
+// after the first write so that code tested against it runs on Firestore.
+type memoryTx struct {
+	memoryBatch
+}
+
+// errReadAfterWrite is returned by a transaction read made after a write.
+var errReadAfterWrite = errors.New("transaction reads must come before writes")
+
+func (t *memoryTx) checkRead() error {
+	if len(t.checks) > 0 || len(t.writes) > 0 {
+		return errReadAfterWrite
+	}
+	return nil
+}
+
+func (t *memoryTx) GetCustomer(ctx context.Context, id string) (*Customer, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetCustomer(ctx, id)
+}
+
+func (t *memoryTx) GetAccount(ctx context.Context, number string) (*Account, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetAccount(ctx, number)
+}
+
+func (t *memoryTx) ListAccounts(ctx context.Context, q AccountQuery) ([]Account, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.ListAccounts(ctx, q)
+}
+
+func (t *memoryTx) GetTransaction(ctx context.Context, receiptNo string) (*Transaction, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetTransaction(ctx, receiptNo)
+}
+
+func (t *memoryTx) ListTransactions(ctx context.Context, q TransactionQuery) ([]Transaction, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.ListTransactions(ctx, q)
+}
+
+func (t *memoryTx) GetCommission(ctx context.Context, id string) (*DSCommission, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetCommission(ctx, id)
+}
+
+func (t *memoryTx) GetCommissionPolicy(ctx context.Context, id string) (*CommissionPolicy, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetCommissionPolicy(ctx, id)
+}
+
+func (t *memoryTx) GetCycle(ctx context.Context, id string) (*DSCycle, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetCycle(ctx, id)
+}
+
+func (t *memoryTx) GetDailySummary(ctx context.Context, branchID string, day int64) (*DailySummary, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetDailySummary(ctx, branchID, day)
+}
+
+func (t *memoryTx) GetDayLock(ctx context.Context, branchID string) (*DayLock, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetDayLock(ctx, branchID)
+}
+
+func (t *memoryTx) GetExpense(ctx context.Context, id string) (*Expense, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetExpense(ctx, id)
+}
+
+func (t *memoryTx) GetNotification(ctx context.Context, id string) (*Notification, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetNotification(ctx, id)
+}
+
+func (t *memoryTx) GetRemittance(ctx context.Context, id string) (*Remittance, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	return t.store.GetRemittance(ctx, id)
+}
+
+func (t *memoryTx) GetIdempotencyRecord(ctx context.Context, id string) (*IdempotencyRecord, error) {
+	if err := t.checkRead(); err != nil {
+		return nil, err
+	}
+	s := t.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.idempotency[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+// memoryBatch records writes as checks and mutations. On Commit every check
+// runs before any mutation so that a failing create leaves the store untouched.
+type memoryBatch struct {
+	store  *MemoryStore
+	checks []func() error
+	writes []func()
+}
+
+func (b *memoryBatch) create(exists func() bool, path string, write func()) {
+	b.checks = append(b.checks, func() error {
+		if exists() {
+			return errors.Errorf("document %s already exists", path)
+		}
+		return nil
+	})
+	b.writes = append(b.writes, write)
+}
+
+func (b *memoryBatch) update(exists func() bool, path string, write func()) {
+	b.checks = append(b.checks, func() error {
+		if !exists() {
+			return errors.Wrapf(ErrNotFound, "document %s", path)
+		}
+		return nil
+	})
+	b.writes = append(b.writes, write)
+}
+
+func (b *memoryBatch) CreateCustomer(customer Customer) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.customers[customer.ID]
+		return ok
+	}, "customer/"+customer.ID, func() {
+		s.customers[customer.ID] = customer
+	})
+}
+
+func (b *memoryBatch) UpdateCustomer(customer Customer) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.customers[customer.ID]
+		return ok
+	}, "customer/"+customer.ID, func() {
+		c := s.customers[customer.ID]
+		customer.CreatedAt = c.CreatedAt
+		s.customers[customer.ID] = customer
+	})
+}
+
+func (b *memoryBatch) CreateCustomerChange(change CustomerChange) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.changes[change.ID]
+		return ok
+	}, "customerChange/"+change.ID, func() {
+		s.changes[change.ID] = change
+	})
+}
+
+func (b *memoryBatch) SetCustomerSearchEntry(entry CustomerSearchEntry) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		s.searchEntries[entry.CustomerID] = entry
+	})
+}
+
+func (b *memoryBatch) CreateAccount(account Account) {
+	s := b.store
+	account = copyAccount(account)
+	b.create(func() bool {
+		_, ok := s.accounts[account.Number]
+		return ok
+	}, "account/"+account.Number, func() {
+		s.accounts[account.Number] = account
+	})
+}
+
+func (b *memoryBatch) UpdateAccount(account Account) {
+	s := b.store
+	account = copyAccount(account)
+	b.update(func() bool {
+		_, ok := s.accounts[account.Number]
+		return ok
+	}, "account/"+account.Number, func() {
+		a := s.accounts[account.Number]
+		a.Balance = account.Balance
+		a.LastPaymentDate = account.LastPaymentDate
+		a.LastCommissionDate = account.LastCommissionDate
+		a.CurrentCycleID = account.CurrentCycleID
+		a.RecentTransactions = account.RecentTransactions
+		s.accounts[account.Number] = a
+	})
+}
+
+func (b *memoryBatch) RenameAccountCustomer(account Account) {
+	s := b.store
+	account = copyAccount(account)
+	b.update(func() bool {
+		_, ok := s.accounts[account.Number]
+		return ok
+	}, "account/"+account.Number, func() {
+		a := s.accounts[account.Number]
+		a.Customer = account.Customer
+		a.RecentTransactions = account.RecentTransactions
+		s.accounts[account.Number] = a
+	})
+}
+
+func (b *memoryBatch) CreateTransaction(tx Transaction) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.transactions[tx.ReceiptNo]
+		return ok
+	}, "transaction/"+tx.ReceiptNo, func() {
+		s.transactions[tx.ReceiptNo] = tx
+	})
+}
+
+func (b *memoryBatch) ReverseTransaction(receiptNo, reversalReceiptNo string, reversedAt int64) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.transactions[receiptNo]
+		return ok
+	}, "transaction/"+receiptNo, func() {
+		tx := s.transactions[receiptNo]
+		tx.ReversedBy = reversalReceiptNo
+		tx.ReversedAt = reversedAt
+		tx.UpdatedAt = reversedAt
+		s.transactions[receiptNo] = tx
+	})
+}
+
+func (b *memoryBatch) RenameTransactionCustomer(receiptNo, customerName string) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.transactions[receiptNo]
+		return ok
+	}, "transaction/"+receiptNo, func() {
+		tx := s.transactions[receiptNo]
+		tx.CustomerName = customerName
+		s.transactions[receiptNo] = tx
+	})
+}
+
+func (b *memoryBatch) CreateCommission(commission DSCommission) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.commissions[commission.ID]
+		return ok
+	}, "commission/"+commission.ID, func() {
+		s.commissions[commission.ID] = commission
+	})
+}
+
+func (b *memoryBatch) ReverseCommission(id string, reversedAt int64) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.commissions[id]
+		return ok
+	}, "commission/"+id, func() {
+		commission := s.commissions[id]
+		commission.ReversedAt = reversedAt
+		s.commissions[id] = commission
+	})
+}
+
+func (b *memoryBatch) CreateCommissionPolicy(policy CommissionPolicy) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.policies[policy.ID]
+		return ok
+	}, "commissionPolicy/"+policy.ID, func() {
+		s.policies[policy.ID] = policy
+	})
+}
+
+func (b *memoryBatch) CreateCycle(cycle DSCycle) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.cycles[cycle.ID]
+		return ok
+	}, "dsCycle/"+cycle.ID, func() {
+		s.cycles[cycle.ID] = cycle
+	})
+}
+
+func (b *memoryBatch) UpdateCycle(cycle DSCycle) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.cycles[cycle.ID]
+		return ok
+	}, "dsCycle/"+cycle.ID, func() {
+		c := s.cycles[cycle.ID]
+		c.DaysPaid = cycle.DaysPaid
+		c.PaidThrough = cycle.PaidThrough
+		c.AmountPaid = cycle.AmountPaid
+		c.FeeTaken = cycle.FeeTaken
+		c.CommissionID = cycle.CommissionID
+		c.AmountPayable = cycle.AmountPayable
+		c.PaidOut = cycle.PaidOut
+		c.PayoutReceiptNo = cycle.PayoutReceiptNo
+		c.Status = cycle.Status
+		c.ClosedAt = cycle.ClosedAt
+		c.UpdatedAt = cycle.UpdatedAt
+		s.cycles[cycle.ID] = c
+	})
+}
+
+func (b *memoryBatch) IncrementDailySummary(branchID string, day int64, delta DailySummary) {
+	s := b.store
+	id := dailySummaryID(branchID, day)
+	b.writes = append(b.writes, func() {
+		summary := s.dailySummaries[id]
+		summary.BranchID = branchID
+		summary.Date = day
+		summary.Income += delta.Income
+		summary.DepositCount += delta.DepositCount
+		summary.Withdrawals += delta.Withdrawals
+		summary.WithdrawalCount += delta.WithdrawalCount
+		summary.Fees += delta.Fees
+		summary.Expenditure += delta.Expenditure
+		summary.BankDeposit += delta.BankDeposit
+		summary.Lodged += delta.Lodged
+		summary.CashIn += delta.CashIn
+		summary.CashOut += delta.CashOut
+		s.dailySummaries[id] = summary
+	})
+}
+
+func (b *memoryBatch) CloseDailySummary(summary DailySummary) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		s.dailySummaries[dailySummaryID(summary.BranchID, summary.Date)] = summary
+	})
+}
+
+func (b *memoryBatch) SetDayLock(lock DayLock) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		s.dayLocks[branchKey(lock.BranchID)] = lock
+	})
+}
+
+func (b *memoryBatch) CreateExpense(expense Expense) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.expenses[expense.ID]
+		return ok
+	}, "expense/"+expense.ID, func() {
+		s.expenses[expense.ID] = expense
+	})
+}
+
+func (b *memoryBatch) ArchiveExpense(expense Expense) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.expenses[expense.ID]
+		return ok
+	}, "expense/"+expense.ID, func() {
+		e := s.expenses[expense.ID]
+		e.ArchivedAt = expense.ArchivedAt
+		e.ArchivedBy = expense.ArchivedBy
+		e.ArchiveReason = expense.ArchiveReason
+		s.expenses[expense.ID] = e
+	})
+}
+
+func (b *memoryBatch) CreateLodgement(lodgement Lodgement) {
+	s := b.store
+	lodgement.Allocations = append([]LodgementAllocation(nil), lodgement.Allocations...)
+	b.create(func() bool {
+		_, ok := s.lodgements[lodgement.ID]
+		return ok
+	}, "lodgement/"+lodgement.ID, func() {
+		s.lodgements[lodgement.ID] = lodgement
+	})
+}
+
+func (b *memoryBatch) CreateNotification(n Notification) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.notifications[n.ID]
+		return ok
+	}, "notification/"+n.ID, func() {
+		s.notifications[n.ID] = n
+	})
+}
+
+func (b *memoryBatch) UpdateNotification(n Notification) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.notifications[n.ID]
+		return ok
+	}, "notification/"+n.ID, func() {
+		c := s.notifications[n.ID]
+		c.Status = n.Status
+		c.Attempts = n.Attempts
+		c.NextAttemptAt = n.NextAttemptAt
+		c.Failed = n.Failed
+		c.LastError = n.LastError
+		c.SentAt = n.SentAt
+		c.ProviderMessageID = n.ProviderMessageID
+		c.Cost = n.Cost
+		c.Currency = n.Currency
+		c.Units = n.Units
+		c.ErrorCode = n.ErrorCode
+		c.DeliveryStatus = n.DeliveryStatus
+		c.DeliveredAt = n.DeliveredAt
+		c.UpdatedAt = n.UpdatedAt
+		s.notifications[n.ID] = c
+	})
+}
+
+func (b *memoryBatch) CreateRemittance(remittance Remittance) {
+	s := b.store
+	b.create(func() bool {
+		_, ok := s.remittances[remittance.ID]
+		return ok
+	}, "remittance/"+remittance.ID, func() {
+		s.remittances[remittance.ID] = remittance
+	})
+}
+
+func (b *memoryBatch) UpdateRemittance(remittance Remittance) {
+	s := b.store
+	b.update(func() bool {
+		_, ok := s.remittances[remittance.ID]
+		return ok
+	}, "remittance/"+remittance.ID, func() {
+		r := s.remittances[remittance.ID]
+		r.Status = remittance.Status
+		r.ReceivedAmount = remittance.ReceivedAmount
+		r.Shortage = remittance.Shortage
+		r.ReviewedBy = remittance.ReviewedBy
+		r.ReviewNote = remittance.ReviewNote
+		r.ReviewedAt = remittance.ReviewedAt
+		s.remittances[remittance.ID] = r
+	})
+}
+
+func (b *memoryBatch) PostJournal(journal Journal) {
+	s := b.store
+	b.checks = append(b.checks, journal.validate)
+	b.writes = append(b.writes, func() {
+		for _, e := range journal.Entries {
+			s.ledgerEntries = append(s.ledgerEntries, e)
+			a, ok := s.ledgerAccounts[e.Ledger]
+			if !ok {
+				a = newLedgerAccount(e.Ledger)
+			}
+			a.Debit += e.Debit
+			a.Credit += e.Credit
+			s.ledgerAccounts[e.Ledger] = a
+		}
+	})
+}
+
+func (b *memoryBatch) SetIdempotencyRecord(rec IdempotencyRecord) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		s.idempotency[rec.ID] = rec
+	})
+}
+
+func (b *memoryBatch) DeleteIdempotencyRecord(id string) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		delete(s.idempotency, id)
+	})
+}
+
+func (b *memoryBatch) IncrementCount(path string, n int64) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		s.counts[path] += n
+	})
+}
+
+func (b *memoryBatch) IncrementTotal(path string, amount Money) {
+	s := b.store
+	b.writes = append(b.writes, func() {
+		s.totals[path] += amount
+	})
+}
+
+func (b *memoryBatch) Commit(ctx context.Context) error {
+	s := b.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, check := range b.checks {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	for _, write := range b.writes {
+		write()
+	}
+	return nil
+}
+
+// copyAccount returns a copy of account that does not share RecentTransactions.
+func copyAccount(account Account) Account {
+	if account.RecentTransactions != nil {
+		account.RecentTransactions = append([]Transaction(nil), account.RecentTransactions...)
+	}
+	return account
+}
+
+// pageBounds returns the slice bounds of the requested page of n items.
+func pageBounds(n, offset, limit int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	end := n
+	if limit > 0 && offset+limit < n {
+		end = offset + limit
+	}
+	return offset, end
+}
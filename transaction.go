@@ -5,13 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
-	"math/rand"
 	"net/http"
-	"strconv"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/ademuanthony/surebankltd/notify"
 	"github.com/jinzhu/now"
 	"github.com/pborman/uuid"
@@ -25,31 +21,36 @@ const (
 	// TransactionType_Withdrawal defines the type of withdrawal transaction.
 	TransactionType_Withdrawal TransactionType = "withdrawal"
 
+	// TransactionKind_Fee marks the withdrawal taking a DS fee.
+	TransactionKind_Fee = "ds_fee"
+	// TransactionKind_Payout marks the withdrawal paying out a DS cycle.
+	TransactionKind_Payout = "ds_payout"
+
 	PaymentMethod_Cash string = "cash"
 	PaymentMethod_Bank string = "bank_deposit"
-)
 
-func getTransactionByReceiptNumber(ctx context.Context, receiptNo string, client *firestore.Client) (*Transaction, error) {
-	docSnap, err := client.Collection("transaction").Doc(receiptNo).Get(ctx)
-	if err != nil {
-		return nil, err
-	}
+	// maxRecentTransactions is the number of transactions kept on an account.
+	maxRecentTransactions = 5
+)
 
-	var tx Transaction
-	if err = docSnap.DataTo(&tx); err != nil {
-		return nil, err
+func getTransactionByReceiptNumber(ctx context.Context, receiptNo string, store Store) (*Transaction, error) {
+	if !validReceiptNumber(receiptNo) {
+		return nil, errInvalidReceiptNumber
 	}
-	return &tx, nil
+	return store.GetTransaction(ctx, receiptNo)
 }
 
 func Deposit(w http.ResponseWriter, r *http.Request) {
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+	serve(w, r, (*Handler).Deposit)
+}
 
+// Deposit posts a deposit. Requests carrying an idempotency key are only
+// applied once.
+func (h *Handler) Deposit(w http.ResponseWriter, r *http.Request) {
+	h.withIdempotency(w, r, "deposit", h.deposit)
+}
+
+func (h *Handler) deposit(w http.ResponseWriter, r *http.Request) {
 	var req Transaction
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -57,7 +58,7 @@ func Deposit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	account, err := getAccountByNumber(r.Context(), req.AccountNumber, client)
+	account, err := getAccountByNumber(r.Context(), req.AccountNumber, h.store)
 	if err != nil {
 		sendError(w, "Invalid account number")
 		return
@@ -65,7 +66,7 @@ func Deposit(w http.ResponseWriter, r *http.Request) {
 
 	currentDate := timeNow()
 	if account.Type != AccountTypeDS {
-		m, err := create(r.Context(), req, currentDate, client)
+		m, err := create(r.Context(), req, currentDate, h.store)
 		if err != nil {
 			log.Print(err)
 			sendErrorf(w, "cannot create transaction, %s", err.Error())
@@ -75,13 +76,18 @@ func Deposit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if math.Mod(req.Amount, account.Target) != 0 {
-		sendErrorf(w, "Amount must be a multiple of %f", account.Target)
+	if account.Target <= 0 {
+		sendError(w, "The daily contribution of this account is not set")
+		return
+	}
+
+	if req.Amount <= 0 || req.Amount%account.Target != 0 {
+		sendErrorf(w, "Amount must be a multiple of %s", account.Target)
 		return
 	}
 
 	if req.Amount/account.Target > 50 {
-		sendErrorf(w, "Please pay for max of 50 days at a time, one day is %.2f", account.Target)
+		sendErrorf(w, "Please pay for max of 50 days at a time, one day is %s", account.Target)
 		return
 	}
 
@@ -89,11 +95,32 @@ func Deposit(w http.ResponseWriter, r *http.Request) {
 		req.PaymentMethod = "cash"
 	}
 
+	// The customer is told once about the whole payment, with the last of
+	// the daily contributions it pays for, unless the contributions are
+	// notified in the daily digest.
 	var tx *Transaction
 	amount, reqAmount := req.Amount, req.Amount
 	req.Amount = account.Target
 	for amount > 0 {
-		tx, err = create(r.Context(), req, currentDate, client)
+		var notice postingNotice
+		if amount == account.Target {
+			notice = func(customer *Customer, account *Account, m Transaction, at time.Time) []Notification {
+				if customer.Notifications.digest() {
+					return nil
+				}
+				m.Amount = reqAmount
+				return notifications(
+					newNotification(customer, notify.SMSDSReceived, notify.DSDepositSMSPayload{
+						Name:          customer.Name,
+						EffectiveDate: time.Unix(m.EffectiveDate, 0).Format("02/01/2006"),
+						Amount:        reqAmount.String(),
+						Balance:       account.Balance.String(),
+					}, at),
+					newEmailNotification(customer, notify.EmailDeposit, transactionEmail(customer, account, m), at),
+				)
+			}
+		}
+		tx, err = createWithNotice(r.Context(), req, currentDate, h.store, notice)
 		if err != nil {
 			sendErrorf(w, "Cannot create transaction, %s", err.Error())
 			return
@@ -101,40 +128,69 @@ func Deposit(w http.ResponseWriter, r *http.Request) {
 		amount -= account.Target
 		currentDate = currentDate.Add(4 * time.Second)
 	}
+	sendResponse(w, tx)
+}
 
-	customer, err := getCustomerByID(r.Context(), req.CustomerID, client)
-	if err != nil {
-		log.Println(err)
-	}
-	if serr := notify.Send(r.Context(), customer.PhoneNumber, "sms/ds_received",
-		map[string]interface{}{
-			"Name":          customer.Name,
-			"EffectiveDate": time.Unix(tx.EffectiveDate, 0).Format("01/01/2006"),
-			"Amount":        reqAmount,
-			"Balance":       account.Balance + reqAmount,
-		}); err != nil {
-		// TODO: log critical error. Send message to monitoring account
-		fmt.Println(serr)
+// postingNotice returns the notifications telling the customer about the
+// posting m, account holding the balance after it.
+type postingNotice func(customer *Customer, account *Account, m Transaction, at time.Time) []Notification
+
+// transactionNotice tells about savings deposits and about withdrawals, by
+// SMS and by email. DS deposits are notified by the caller, see
+// Handler.deposit.
+func transactionNotice(customer *Customer, account *Account, m Transaction, at time.Time) []Notification {
+	smsTemplate, emailTemplate := notify.SMSPaymentWithdrawn, notify.EmailWithdrawal
+	if m.Type == TransactionType_Deposit {
+		if account.Type != AccountTypeSB {
+			return nil
+		}
+		smsTemplate, emailTemplate = notify.SMSPaymentReceived, notify.EmailDeposit
+	}
+	return notifications(
+		newNotification(customer, smsTemplate, notify.DepositSMSPayload{
+			Name:    customer.Name,
+			Amount:  m.Amount.String(),
+			Balance: account.Balance.String(),
+		}, at),
+		newEmailNotification(customer, emailTemplate, transactionEmail(customer, account, m), at),
+	)
+}
+
+func transactionEmail(customer *Customer, account *Account, m Transaction) notify.TransactionEmailPayload {
+	return notify.TransactionEmailPayload{
+		Name:          customer.Name,
+		AccountNumber: account.Number,
+		ReceiptNo:     m.ReceiptNo,
+		Date:          time.Unix(m.CreatedAt, 0).Format("02/01/2006"),
+		Narration:     m.Narration,
+		Amount:        m.Amount.String(),
+		Balance:       account.Balance.String(),
 	}
-	sendResponse(w, tx)
 }
 
-func create(ctx context.Context, req Transaction, currentDate time.Time, client *firestore.Client) (*Transaction, error) {
+func create(ctx context.Context, req Transaction, currentDate time.Time, store Store) (*Transaction, error) {
+	return createWithNotice(ctx, req, currentDate, store, transactionNotice)
+}
+
+// createWithNotice posts req and queues the notification returned by notice,
+// if any, with it.
+func createWithNotice(ctx context.Context, req Transaction, currentDate time.Time, store Store, notice postingNotice) (*Transaction, error) {
+	if req.Amount <= 0 {
+		return nil, errAmountNotPositive
+	}
 
-	account, err := getAccountByNumber(ctx, req.AccountNumber, client)
+	account, err := getAccountByNumber(ctx, req.AccountNumber, store)
 	if err != nil {
 		log.Println(err)
 		return nil, errors.New("cannot map account data")
 	}
 
-	var customer Customer
-	customerRef := client.Doc("customer/" + account.CustomerID)
-	customerSnap, err := customerRef.Get(ctx)
+	customer, err := getCustomerByID(ctx, account.CustomerID, store)
 	if err != nil {
 		return nil, errors.Errorf("cannot read customer data, %s", err.Error())
 	}
-	if err = customerSnap.DataTo(&customer); err != nil {
-		return nil, errors.New("cannot map customer data")
+	if customer.ArchivedAt > 0 && req.Type == TransactionType_Deposit {
+		return nil, errors.New("the customer is archived")
 	}
 
 	// If now empty set it to the current time.
@@ -143,177 +199,210 @@ func create(ctx context.Context, req Transaction, currentDate time.Time, client
 	}
 
 	today := now.New(currentDate).BeginningOfDay()
-	effectiveDate := today
-	if account.Type == AccountTypeDS {
-		if len(account.RecentTransactions) > 0 {
-			effectiveDate = now.New(time.Unix(account.RecentTransactions[0].EffectiveDate, 0)).Time.Add(24 * time.Hour)
-		}
-	}
 
-	isFirstContribution, err := startingNewCircle(account.LastCommissionDate, effectiveDate)
-	if err != nil {
-		return nil, err
-	}
-
-	if req.Type == TransactionType_Deposit {
-		account.LastPaymentDate = effectiveDate.Unix()
-		account.Balance += req.Amount
-	} else {
-		account.Balance -= req.Amount
-	}
-
-	receiptNumber, err := generateReceiptNumber(ctx, client)
+	receiptNumber, err := generateReceiptNumber(ctx, store, account.BranchID)
 	if err != nil {
 		log.Println(err)
 		return nil, fmt.Errorf("error in generating receipt number, %s", err.Error())
 	}
 	req.ReceiptNo = receiptNumber
-	batch := client.Batch().Create(client.Doc("transaction/"+receiptNumber), req)
 
-	dailySummaryRef := client.Doc(fmt.Sprintf("dailySummary/%d", today.Unix()))
-	if _, err := dailySummaryRef.Get(ctx); err != nil {
-		if _, err = dailySummaryRef.Create(ctx, DailySummary{}); err != nil {
-			return nil, fmt.Errorf("cannot initialize daily summary, %s", err.Error())
+	// Receipts cannot be allocated inside the transaction, so the receipt of
+	// the DS fee is allocated up front when the account looks due for a new
+	// cycle.
+	// Should a concurrent posting make the fee due in the meantime the
+	// transaction is run again with a fee receipt.
+	var feeReceiptNumber string
+	allocateFeeReceipt := func() error {
+		if feeReceiptNumber != "" {
+			return nil
+		}
+		feeReceiptNumber, err = generateReceiptNumber(ctx, store, account.BranchID)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("error in generating receipt number, %s", err.Error())
+		}
+		return nil
+	}
+	var policies []CommissionPolicy
+	if req.Type == TransactionType_Deposit && account.Type == AccountTypeDS {
+		if due, _ := startingNewCircle(account.LastCommissionDate, today); due {
+			if err = allocateFeeReceipt(); err != nil {
+				return nil, err
+			}
+		}
+		if policies, err = store.ListCommissionPolicies(ctx); err != nil {
+			log.Println(err)
+			return nil, errors.New("cannot read commission policies")
 		}
 	}
-	batch = batch.Update(dailySummaryRef, []firestore.Update{{Path: "Income", Value: firestore.Increment(req.Amount)}})
 
-	if req.Type == TransactionType_Deposit && account.Type == AccountTypeDS && isFirstContribution {
-		receiptNumber, err := generateReceiptNumber(ctx, client)
+	// The account is read again inside the transaction so that the balance
+	// check and the balance write see the same state.
+	var posted Transaction
+	post := func(ctx context.Context, tx Tx) error {
+		account, err = tx.GetAccount(ctx, req.AccountNumber)
 		if err != nil {
 			log.Println(err)
-			return nil, fmt.Errorf("error in generating receipt number, %s", err.Error())
+			return errors.New("cannot map account data")
 		}
-		wm := Transaction{
-			ReceiptNo:     receiptNumber,
-			AccountNumber: account.Number,
-			Amount:        req.Amount,
-			Narration:     "DS fee deduction",
-			Type:          TransactionType_Withdrawal,
-			SalesRepID:    req.SalesRepID,
-			SalesRep:      req.SalesRep,
-			CustomerID:    req.CustomerID,
-			CustomerName:  req.CustomerName,
-			CreatedAt:     currentDate.Add(2 * time.Second).Unix(),
-			UpdatedAt:     currentDate.Unix(),
+		if err = checkDayOpen(ctx, tx, account.BranchID, currentDate); err != nil {
+			return err
 		}
-		batch = batch.Create(client.Doc("transaction/"+receiptNumber), wm)
-		account.Balance -= req.Amount
 
-		commission := DSCommission{
-			ID:            uuid.NewRandom().String(),
-			AccountNumber: account.Number,
-			CustomerID:    account.CustomerID,
-			CustomerName:  req.CustomerName,
-			Amount:        req.Amount,
-			Date:          currentDate.Unix(),
-			EffectiveDate: effectiveDate.Unix(),
+		m := req
+		m.CustomerID = account.CustomerID
+		if m.CustomerName == "" {
+			m.CustomerName = account.Customer
 		}
-		account.LastCommissionDate = commission.EffectiveDate
-		batch = batch.Create(client.Doc("commission/"+commission.ID), commission)
-		// commission count
-		commissionCountRef := client.Doc(fmt.Sprintf("stats/commission/count"))
-		commissionCounter, err := initCounter(ctx, 10, commissionCountRef)
-		if err != nil {
-			return nil, fmt.Errorf("cannot initialize commission count stat, %s", err.Error())
+		m.CreatedAt = currentDate.Unix()
+		m.UpdatedAt = currentDate.Unix()
+
+		var cycle *DSCycle
+		var policy CommissionPolicy
+		if account.Type == AccountTypeDS && account.CurrentCycleID != "" {
+			cycle, err = tx.GetCycle(ctx, account.CurrentCycleID)
+			if err != nil {
+				log.Println(err)
+				return errors.New("cannot read the DS cycle of the account")
+			}
+			if policy, err = cyclePolicy(ctx, tx, cycle); err != nil {
+				log.Println(err)
+				return errors.New("cannot read the commission policy of the DS cycle")
+			}
 		}
-		batch = commissionCounter.incrementCounter(ctx, commissionCountRef, 1, batch)
-		// commission total
-		commissionTotalRef := client.Doc(fmt.Sprintf("stats/commission/total"))
-		commissionTotalCounter, err := initCounter(ctx, 10, commissionTotalRef)
+
+		effectiveDate := today
+		if account.Type == AccountTypeDS {
+			effectiveDate = nextContributionDate(account, cycle, today)
+		}
+
+		m.EffectiveDate = effectiveDate.Unix()
+
+		isFirstContribution, err := startingNewCircle(account.LastCommissionDate, effectiveDate)
 		if err != nil {
-			return nil, fmt.Errorf("cannot initialize commission total stat, %s", err.Error())
+			return err
 		}
-		batch = commissionTotalCounter.incrementCounter(ctx, commissionTotalRef, commission.Amount, batch)
-	}
-
-	// send SMS notification
-	if req.Type == TransactionType_Deposit {
-		if account.Type == AccountTypeSB {
-			if err = notify.Send(ctx, customer.PhoneNumber, "sms/payment_received",
-				map[string]interface{}{
-					"Name":    customer.Name,
-					"Amount":  req.Amount,
-					"Balance": account.Balance,
-				}); err != nil {
-				// TODO: log critical error. Send message to monitoring account
-				fmt.Println(err)
+
+		if m.Type == TransactionType_Deposit {
+			account.LastPaymentDate = effectiveDate.Unix()
+			account.Balance += m.Amount
+		} else {
+			if account.Balance < m.Amount {
+				return errors.New("insufficient fund")
 			}
+			account.Balance -= m.Amount
 		}
-	} else {
-		if err = notify.Send(ctx, customer.PhoneNumber, "sms/payment_withdrawn",
-			map[string]interface{}{
-				"Name":    customer.Name,
-				"Amount":  req.Amount,
-				"Balance": account.Balance,
-			}); err != nil {
-			// TODO: log critical error. Send message to monitoring account
-			fmt.Println(err)
+		m.Balance = account.Balance
+
+		// A contribution starting a cycle records the commission policy in
+		// force, the fee is taken once the policy makes it due. The fee and
+		// the commission are linked to the deposit so that they can be rolled
+		// back when it is reversed.
+		var fee *Transaction
+		var commission *DSCommission
+		var newCycle bool
+		if m.Type == TransactionType_Deposit && account.Type == AccountTypeDS && isFirstContribution {
+			if cycle != nil {
+				cycle.Status = DSCycleStatusCompleted
+				cycle.UpdatedAt = currentDate.Unix()
+				tx.UpdateCycle(*cycle)
+			}
+			policy = selectCommissionPolicy(policies, account.BranchID, account.Type, effectiveDate.Unix())
+			cycle = &DSCycle{
+				ID:            uuid.NewRandom().String(),
+				AccountNumber: account.Number,
+				CustomerID:    account.CustomerID,
+				CustomerName:  m.CustomerName,
+				StartDate:     effectiveDate.Unix(),
+				DailyRate:     account.Target,
+				PolicyID:      policy.ID,
+				Status:        DSCycleStatusOpen,
+				CreatedAt:     currentDate.Unix(),
+			}
+			account.CurrentCycleID = cycle.ID
+			account.LastCommissionDate = cycle.StartDate
+			newCycle = true
+		}
+		if m.Type == TransactionType_Deposit && cycle != nil {
+			m.CycleID = cycle.ID
+			cycle.contribute(m.Amount, effectiveDate.Unix())
+			cycle.UpdatedAt = currentDate.Unix()
+			if amount := policy.fee(*cycle, m.Amount, false); amount > 0 {
+				if amount > account.Balance {
+					amount = account.Balance
+				}
+				if feeReceiptNumber == "" {
+					return errFeeReceiptRequired
+				}
+				f, c := newDSFee(account, cycle, policy, amount, feeReceiptNumber, m, currentDate)
+				fee, commission = &f, &c
+				m.FeeReceiptNo = fee.ReceiptNo
+				m.CommissionID = commission.ID
+			}
+			if newCycle {
+				tx.CreateCycle(*cycle)
+			} else {
+				tx.UpdateCycle(*cycle)
+			}
 		}
-	}
 
-	if len(account.RecentTransactions) >= 5 {
-		account.RecentTransactions = account.RecentTransactions[:len(account.RecentTransactions)-1]
-	}
-	account.RecentTransactions = append([]Transaction{req}, account.RecentTransactions...)
-	accountRef := client.Doc("account/" + req.AccountNumber)
-	batch = batch.Update(accountRef, []firestore.Update{
-		{Path: "Balance", Value: account.Balance},
-		{Path: "LastPaymentDate", Value: effectiveDate.Unix()},
-		{Path: "LastCommissionDate", Value: account.LastCommissionDate},
-		{Path: "RecentTransactions", Value: account.RecentTransactions},
-	})
-	globalBalance := req.Amount * -1
-	if req.Type == TransactionType_Deposit {
-		globalBalance *= -1
-		// deposit count
-		tsCountRef := client.Doc(fmt.Sprintf("stats/transaction/%d/%s/count", today.Unix(), req.Type))
-		tsCounter, err := initCounter(ctx, 10, tsCountRef)
-		if err != nil {
-			return nil, fmt.Errorf("cannot initialize transaction count stat, %s", err.Error())
+		tx.CreateTransaction(m)
+		tx.PostJournal(transactionJournal(m, account.Type, currentDate))
+		recordDailySummary(tx, account.BranchID, currentDate, m, 1)
+
+		if fee != nil {
+			postDSFee(tx, account, *fee, *commission, currentDate)
 		}
-		batch = tsCounter.incrementCounter(ctx, tsCountRef, 1, batch)
-		// deposit total
-		txTotalRef := client.Doc(fmt.Sprintf("stats/transaction/%d/%s/total", today.Unix(), req.Type))
-		tsTotal, err := initCounter(ctx, 10, txTotalRef)
-		if err != nil {
-			return nil, fmt.Errorf("cannot initialize transaction total stat, %s", err.Error())
+
+		if len(account.RecentTransactions) >= maxRecentTransactions {
+			account.RecentTransactions = account.RecentTransactions[:len(account.RecentTransactions)-1]
 		}
-		batch = tsTotal.incrementCounter(ctx, txTotalRef, req.Amount, batch)
-		// reps stat
-		repStatRef := client.Doc(fmt.Sprintf("stats/transaction/%d/%s/%s", today.Unix(), req.SalesRepID, req.PaymentMethod))
-		repStat, err := initCounter(ctx, 10, repStatRef)
-		if err != nil {
-			return nil, fmt.Errorf("cannot initialize transaction reps stat, %s", err.Error())
+		account.RecentTransactions = append([]Transaction{m}, account.RecentTransactions...)
+		account.LastPaymentDate = effectiveDate.Unix()
+		tx.UpdateAccount(*account)
+		recordTransactionStats(tx, m, 1)
+		globalBalance := m.Amount * -1
+		if m.Type == TransactionType_Deposit {
+			globalBalance *= -1
 		}
-		batch = repStat.incrementCounter(ctx, repStatRef, req.Amount, batch)
-	}
 
-	// global balance
-	txTotalRef := client.Doc(fmt.Sprintf("stats/globalBalance/%s", account.Type))
-	tsTotal, err := initCounter(ctx, 10, txTotalRef)
-	if err != nil {
-		return nil, fmt.Errorf("cannot initialize transaction total stat, %s", err.Error())
+		// global balance
+		tx.IncrementTotal(statGlobalBalancePath(account.Type), globalBalance)
+		if notice != nil {
+			for _, n := range notice(customer, account, m, currentDate) {
+				n.AccountNumber, n.ReceiptNo = m.AccountNumber, m.ReceiptNo
+				tx.CreateNotification(n)
+			}
+		}
+		posted = m
+		return nil
 	}
-	batch = tsTotal.incrementCounter(ctx, txTotalRef, globalBalance, batch)
-
-	if _, err = batch.Commit(ctx); err != nil {
+	err = store.RunTransaction(ctx, post)
+	if err == errFeeReceiptRequired {
+		if err = allocateFeeReceipt(); err != nil {
+			return nil, err
+		}
+		err = store.RunTransaction(ctx, post)
+	}
+	if err != nil {
 		return nil, err
 	}
-
-	return &req, nil
+	return &posted, nil
 }
 
 // Withdraw inserts a new withdrawal transaction into the database.
 func Withdraw(w http.ResponseWriter, r *http.Request) {
+	serve(w, r, (*Handler).Withdraw)
+}
 
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
+// Withdraw inserts a new withdrawal transaction into the store. Requests
+// carrying an idempotency key are only applied once.
+func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
+	h.withIdempotency(w, r, "withdraw", h.withdraw)
+}
 
+func (h *Handler) withdraw(w http.ResponseWriter, r *http.Request) {
 	var req WithdrawRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
@@ -327,8 +416,10 @@ func Withdraw(w http.ResponseWriter, r *http.Request) {
 		Narration:     fmt.Sprintf("%s - %s", req.PaymentMethod, req.Narration),
 		SalesRep:      req.SalesRep,
 		SalesRepID:    req.SalesRepID,
+		PaymentMethod: PaymentMethod_Cash,
 	}
 	if req.PaymentMethod == "Transfer" {
+		createReq.PaymentMethod = PaymentMethod_Bank
 		if len(req.Narration) > 0 {
 			createReq.Narration += " -"
 		}
@@ -338,7 +429,7 @@ func Withdraw(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	txn, err := makeDeduction(r.Context(), createReq, timeNow(), client)
+	txn, err := makeDeduction(r.Context(), createReq, timeNow(), h.store)
 	if err != nil {
 		sendError(w, err.Error())
 		return
@@ -348,189 +439,101 @@ func Withdraw(w http.ResponseWriter, r *http.Request) {
 
 // MakeDeduction inserts a new transaction of type withdrawal into the database.
 func makeDeduction(ctx context.Context, req MakeDeductionRequest,
-	now time.Time, client *firestore.Client) (*Transaction, error) {
+	now time.Time, store Store) (*Transaction, error) {
+	if req.Amount <= 0 {
+		return nil, errAmountNotPositive
+	}
 
-	account, err := getAccountByNumber(ctx, req.AccountNumber, client)
+	account, err := getAccountByNumber(ctx, req.AccountNumber, store)
 	if err != nil {
 		return nil, errors.New("invalid account number")
 	}
 
-	if account.Balance < req.Amount {
-		return nil, errors.New("insufficient fund")
+	customer, err := getCustomerByID(ctx, account.CustomerID, store)
+	if err != nil {
+		return nil, err
 	}
 
-	receiptNo, err := generateReceiptNumber(ctx, client)
-
-	m := Transaction{
-		AccountNumber: account.Number,
-		Type:          TransactionType_Withdrawal,
-		Amount:        req.Amount,
-		Narration:     req.Narration,
-		SalesRepID:    req.SalesRepID,
-		SalesRep:      req.SalesRep,
-		CustomerID:    account.CustomerID,
-		CustomerName:  account.Customer,
-		ReceiptNo:     receiptNo,
-		CreatedAt:     now.Unix(),
-		UpdatedAt:     now.Unix(),
+	receiptNo, err := generateReceiptNumber(ctx, store, account.BranchID)
+	if err != nil {
+		return nil, fmt.Errorf("error in generating receipt number, %s", err.Error())
 	}
 
-	batch := client.Batch()
+	var m Transaction
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		account, err = tx.GetAccount(ctx, req.AccountNumber)
+		if err != nil {
+			return errors.New("invalid account number")
+		}
+		if err = checkDayOpen(ctx, tx, account.BranchID, now); err != nil {
+			return err
+		}
 
-	batch = batch.Create(client.Doc("transaction/"+receiptNo), m)
-	account.Balance -= req.Amount
-	batch = batch.Update(client.Doc("account/"+req.AccountNumber), []firestore.Update{
-		{Path: "Balance", Value: account.Balance},
-	})
+		if account.Balance < req.Amount {
+			return errors.New("insufficient fund")
+		}
 
-	if _, err := batch.Commit(ctx); err != nil {
-		return nil, err
-	}
+		m = Transaction{
+			AccountNumber: account.Number,
+			Type:          TransactionType_Withdrawal,
+			Amount:        req.Amount,
+			Narration:     req.Narration,
+			PaymentMethod: req.PaymentMethod,
+			SalesRepID:    req.SalesRepID,
+			SalesRep:      req.SalesRep,
+			CustomerID:    account.CustomerID,
+			CustomerName:  account.Customer,
+			ReceiptNo:     receiptNo,
+			CreatedAt:     now.Unix(),
+			UpdatedAt:     now.Unix(),
+		}
 
-	customer, err := getCustomerByID(ctx, account.CustomerID, client)
+		account.Balance -= req.Amount
+		m.Balance = account.Balance
+		tx.CreateTransaction(m)
+		tx.PostJournal(transactionJournal(m, account.Type, now))
+		recordTransactionStats(tx, m, 1)
+		recordDailySummary(tx, account.BranchID, now, m, 1)
+		// global balance
+		tx.IncrementTotal(statGlobalBalancePath(account.Type), -req.Amount)
+		tx.UpdateAccount(*account)
+		for _, n := range transactionNotice(customer, account, m, now) {
+			n.AccountNumber, n.ReceiptNo = m.AccountNumber, m.ReceiptNo
+			tx.CreateNotification(n)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err = notify.Send(ctx, customer.PhoneNumber, "sms/payment_withdrawn",
-		map[string]interface{}{
-			"Name":    customer.Name,
-			"Amount":  req.Amount,
-			"Balance": account.Balance,
-		}); err != nil {
-		fmt.Println(err)
-	}
-
 	return &m, nil
 }
 
+// errAmountNotPositive is returned when posting an amount that is zero or
+// negative, which would move money the wrong way.
+var errAmountNotPositive = errors.New("amount must be greater than zero")
+
+// errFeeReceiptRequired is returned by the posting transaction of create when
+// a DS fee is due but no fee receipt was allocated.
+var errFeeReceiptRequired = errors.New("a receipt number is required for the DS fee")
+
 func startingNewCircle(lastCommissionDate int64, effectiveDate time.Time) (bool, error) {
 	lastDate := now.New(time.Unix(lastCommissionDate, 0)).BeginningOfDay()
 	effectiveDate = now.New(effectiveDate).BeginningOfDay()
 	duration := effectiveDate.Sub(lastDate)
-	r := duration.Hours() >= float64(31*24)
+	r := duration.Hours() >= float64(dsCycleDays*24)
 	return r, nil
 }
 
-func generateReceiptNumber(ctx context.Context, client *firestore.Client) (string, error) {
-	var receipt string
-	var uniqueFound bool
-	for !uniqueFound {
-		receipt = "TX"
-		rand.Seed(time.Now().UTC().UnixNano())
-		for i := 0; i < 6; i++ {
-			receipt += strconv.Itoa(rand.Intn(10))
-		}
-		if tx, _ := readTransactionByReceiptNumber(ctx, receipt, client); tx == nil {
-			uniqueFound = true
-		}
-	}
-	return receipt, nil
-}
-
-func readTransactionByReceiptNumber(ctx context.Context, receiptNumber string, client *firestore.Client) (*Transaction, error) {
-	docSnap, err := client.Collection("transaction").Doc(receiptNumber).Get(ctx)
+// generateReceiptNumber allocates the next receipt number of the branch. It
+// must not be called inside a store transaction.
+func generateReceiptNumber(ctx context.Context, store Store, branchID string) (string, error) {
+	prefix := receiptPrefix(branchID)
+	seq, err := store.NextSequence(ctx, receiptSequenceName(prefix))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	var tx Transaction
-	if err = docSnap.DataTo(&tx); err != nil {
-		return nil, err
-	}
-	return &tx, nil
-}
-
-// Archive soft deleted the transaction from the database.
-func ArchiveTransaction(w http.ResponseWriter, r *http.Request) {
-	currentDate := timeNow()
-	client, err := firestore.NewClient(r.Context(), "surebank")
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot establish database connection")
-		return
-	}
-
-	var req ArchiveTransactionRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Println(err)
-		sendError(w, "cannot decode client request")
-		return
-	}
-
-	tranx, err := getTransactionByReceiptNumber(r.Context(), req.ID, client)
-	if err != nil {
-		log.Println(err)
-		sendError(w, "cannot read transaction, please check the receipt number")
-		return
-	}
-
-	if tranx.ArchivedAt > 0 {
-		sendError(w, "This transaction has been archived")
-		return
-	}
-
-	batch := client.Batch().Update(client.Doc("transaction/"+req.ID), []firestore.Update{{Path: "", Value: currentDate.Unix()}})
-
-	var txAmount = tranx.Amount
-	if tranx.Type == TransactionType_Deposit {
-		txAmount *= -1
-	}
-	account, err := getAccountByNumber(r.Context(), tranx.AccountNumber, client)
-	if err != nil {
-		sendError(w, "cannot read error")
-		return
-	}
-	accountRef := client.Doc("account/" + tranx.AccountNumber)
-	batch = batch.Update(accountRef, []firestore.Update{
-		{Path: "Balance", Value: account.Balance},
-	})
-
-	globalBalance := tranx.Amount
-	if tranx.Type == TransactionType_Deposit {
-		globalBalance *= -1
-		// deposit count
-		today := now.New(time.Unix(tranx.CreatedAt, 0))
-		dailySummaryRef := client.Doc(fmt.Sprintf("dailySummary/%d", today.Unix()))
-		batch = batch.Update(dailySummaryRef, []firestore.Update{{Path: "Income", Value: firestore.Increment(-1 * tranx.Amount)}})
-
-		tsCountRef := client.Doc(fmt.Sprintf("stats/transaction/%d/%s/count", today.Unix(), tranx.Type))
-		tsCounter, err := initCounter(r.Context(), 10, tsCountRef)
-		if err != nil {
-			sendErrorf(w, "cannot initialize transaction count stat, %s", err.Error())
-			return
-		}
-		batch = tsCounter.incrementCounter(r.Context(), tsCountRef, -1, batch)
-		// deposit total
-		txTotalRef := client.Doc(fmt.Sprintf("stats/transaction/%d/%s/total", today.Unix(), tranx.Type))
-		tsTotal, err := initCounter(r.Context(), 10, txTotalRef)
-		if err != nil {
-			sendErrorf(w, "cannot initialize transaction total stat, %s", err.Error())
-			return
-		}
-		batch = tsTotal.incrementCounter(r.Context(), txTotalRef, tranx.Amount*-1, batch)
-		// reps stat
-		repStatRef := client.Doc(fmt.Sprintf("stats/transaction/%d/%s/%s", today.Unix(), tranx.SalesRepID, tranx.PaymentMethod))
-		repStat, err := initCounter(r.Context(), 10, repStatRef)
-		if err != nil {
-			sendErrorf(w, "cannot initialize transaction reps stat, %s", err.Error())
-		}
-		batch = repStat.incrementCounter(r.Context(), repStatRef, tranx.Amount*-1, batch)
-	}
-
-	// global balance
-	txTotalRef := client.Doc(fmt.Sprintf("stats/globalBalance/%s", account.Type))
-	tsTotal, err := initCounter(r.Context(), 10, txTotalRef)
-	if err != nil {
-		sendErrorf(w, "cannot initialize transaction total stat, %s", err.Error())
-	}
-	batch = tsTotal.incrementCounter(r.Context(), txTotalRef, globalBalance, batch)
-
-	if _, err = batch.Commit(r.Context()); err != nil {
-		sendErrorf(w, "error in committing transaction, %s", err.Error())
-		return
-	}
-
-	sendResponse(w, true)
+	return formatReceiptNumber(prefix, seq), nil
 }
 
 type TransactionType string
@@ -541,7 +544,7 @@ type Transaction struct {
 	AccountNumber string          `json:"account_number" example:"SB10003001" truss:"api-read"`
 	CustomerID    string          `json:"customer_id" truss:"api-read"`
 	CustomerName  string          `json:"customer_name" truss:"api-read"`
-	Amount        float64         `json:"amount" truss:"api-read"`
+	Amount        Money           `json:"amount" truss:"api-read"`
 	Narration     string          `json:"narration" truss:"api-read"`
 	PaymentMethod string          `json:"payment_method" truss:"api-read"`
 	SalesRepID    string          `json:"sales_rep_id" truss:"api-read"`
@@ -550,39 +553,49 @@ type Transaction struct {
 	CreatedAt     int64           `json:"created_at" truss:"api-read"`            // CreatedAt contains multiple format options for display.
 	UpdatedAt     int64           `json:"updated_at" truss:"api-read"`            // UpdatedAt contains multiple format options for display.
 	ArchivedAt    int64           `json:"archived_at,omitempty" truss:"api-read"` // ArchivedAt contains multiple format options for display.
-}
-
-// ArchiveTransactionRequest defines the information needed to archive a deposit. This will archive (soft-delete) the
-// existing database entry.
-type ArchiveTransactionRequest struct {
-	ID string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	// FeeReceiptNo and CommissionID link a DS deposit, and the fee it
+	// triggered, to the fee transaction and the commission.
+	FeeReceiptNo string `json:"fee_receipt_no,omitempty" truss:"api-read"`
+	CommissionID string `json:"commission_id,omitempty" truss:"api-read"`
+	// Kind tells system withdrawals such as DS fees and payouts apart from
+	// the ones customers ask for. It is empty for customer transactions.
+	Kind string `json:"kind,omitempty" truss:"api-read"`
+	// CycleID is the DS cycle of the contribution, fee or payout.
+	CycleID string `json:"cycle_id,omitempty" truss:"api-read"`
+	// ReversedBy is the receipt of the transaction reversing this one.
+	ReversedBy string `json:"reversed_by,omitempty" truss:"api-read"`
+	ReversedAt int64  `json:"reversed_at,omitempty" truss:"api-read"`
+	// ReversalOf is the receipt of the transaction this one reverses.
+	ReversalOf string `json:"reversal_of,omitempty" truss:"api-read"`
+	Reason     string `json:"reason,omitempty" truss:"api-read"`
+	ApprovedBy string `json:"approved_by,omitempty" truss:"api-read"`
+	// Balance is the balance of the account right after the transaction. It
+	// is zero on transactions recorded before it was kept.
+	Balance Money `json:"balance,omitempty" truss:"api-read"`
+	// IdempotencyKey may be sent instead of the Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 // WithdrawRequest contains information needed to make a new Transaction.
 type WithdrawRequest struct {
 	Type              TransactionType `json:"type" validate:"required,oneof=deposit withdrawal"`
 	AccountNumber     string          `json:"account_number" validate:"required"`
-	Amount            float64         `json:"amount" validate:"required,gt=0"`
+	Amount            Money           `json:"amount" validate:"required,gt=0"`
 	PaymentMethod     string          `json:"payment_method" validate:"required"`
 	Bank              string          `json:"bank"`
 	BankAccountNumber string          `json:"bank_account_number"`
 	Narration         string          `json:"narration"`
 	SalesRepID        string          `json:"sales_rep_id"`
 	SalesRep          string          `json:"sales_rep"`
+	// IdempotencyKey may be sent instead of the Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 type MakeDeductionRequest struct {
-	AccountNumber string  `json:"account_number" validate:"required"`
-	Amount        float64 `json:"amount" validate:"required,gt=0"`
-	Narration     string  `json:"narration"`
-	SalesRepID    string  `json:"sales_rep_id"`
-	SalesRep      string  `json:"sales_rep"`
-}
-
-// DailySummary is an object representing the database table.
-type DailySummary struct {
-	Income      float64 `boil:"income" json:"income" toml:"income" yaml:"income"`
-	Expenditure float64 `boil:"expenditure" json:"expenditure" toml:"expenditure" yaml:"expenditure"`
-	BankDeposit float64 `boil:"bank_deposit" json:"bank_deposit" toml:"bank_deposit" yaml:"bank_deposit"`
-	Date        int64   `boil:"date" json:"date" toml:"date" yaml:"date"`
+	AccountNumber string `json:"account_number" validate:"required"`
+	Amount        Money  `json:"amount" validate:"required,gt=0"`
+	Narration     string `json:"narration"`
+	PaymentMethod string `json:"payment_method"`
+	SalesRepID    string `json:"sales_rep_id"`
+	SalesRep      string `json:"sales_rep"`
 }
@@ -0,0 +1,274 @@
+package surebankltd
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func newTestAccount(t *testing.T, store Store, number, accountType string, balance Money) {
+	t.Helper()
+	batch := store.Batch()
+	batch.CreateCustomer(Customer{ID: "customer-" + number, Name: "Test Customer", PhoneNumber: "08030000000"})
+	batch.CreateAccount(Account{Number: number, CustomerID: "customer-" + number, Type: accountType, Balance: balance})
+	if err := batch.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentPostingsKeepBalance(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00001", AccountTypeSB, 1000*Naira)
+
+	const deposits, withdrawals = 40, 30
+	var wg sync.WaitGroup
+	errs := make(chan error, deposits+withdrawals)
+	for i := 0; i < deposits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := create(ctx, Transaction{
+				AccountNumber: "SB00001",
+				Type:          TransactionType_Deposit,
+				Amount:        10 * Naira,
+				PaymentMethod: PaymentMethod_Cash,
+			}, timeNow(), store)
+			errs <- err
+		}()
+	}
+	for i := 0; i < withdrawals; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := makeDeduction(ctx, MakeDeductionRequest{
+				AccountNumber: "SB00001",
+				Amount:        5 * Naira,
+			}, timeNow(), store)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	account, err := store.GetAccount(ctx, "SB00001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 1000*Naira + deposits*10*Naira - withdrawals*5*Naira
+	if account.Balance != want {
+		t.Fatalf("balance = %s, want %s", account.Balance, want)
+	}
+
+	total, err := store.Total(ctx, "stats/globalBalance/"+AccountTypeSB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := deposits*10*Naira - withdrawals*5*Naira; total != want {
+		t.Fatalf("global balance = %s, want %s", total, want)
+	}
+}
+
+func TestConcurrentWithdrawalsCannotOverdraw(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00002", AccountTypeSB, 100*Naira)
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var succeeded int
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := makeDeduction(ctx, MakeDeductionRequest{
+				AccountNumber: "SB00002",
+				Amount:        30 * Naira,
+			}, timeNow(), store)
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			} else if err.Error() != "insufficient fund" {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 3 {
+		t.Fatalf("%d withdrawals succeeded, want 3", succeeded)
+	}
+	account, err := store.GetAccount(ctx, "SB00002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 10*Naira {
+		t.Fatalf("balance = %s, want %s", account.Balance, 10*Naira)
+	}
+}
+
+func TestPostingRejectsNonPositiveAmounts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00003", AccountTypeSB, 100*Naira)
+
+	for _, amount := range []Money{0, -50 * Naira} {
+		if _, err := create(ctx, Transaction{
+			AccountNumber: "SB00003",
+			Type:          TransactionType_Deposit,
+			Amount:        amount,
+			PaymentMethod: PaymentMethod_Cash,
+		}, timeNow(), store); err != errAmountNotPositive {
+			t.Errorf("deposit of %s: got error %v", amount, err)
+		}
+		if _, err := makeDeduction(ctx, MakeDeductionRequest{
+			AccountNumber: "SB00003",
+			Amount:        amount,
+		}, timeNow(), store); err != errAmountNotPositive {
+			t.Errorf("withdrawal of %s: got error %v", amount, err)
+		}
+	}
+
+	account, err := store.GetAccount(ctx, "SB00003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 100*Naira {
+		t.Fatalf("balance = %s, want %s", account.Balance, 100*Naira)
+	}
+}
+
+// conflictStore runs every transaction once against the current documents,
+// discards it as if it had conflicted, lets interleave commit a competing
+// write and then retries it, the way Firestore does on contention.
+type conflictStore struct {
+	*MemoryStore
+	interleave func()
+	attempts   int
+}
+
+func (s *conflictStore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	s.attempts++
+	if err := fn(ctx, &memoryTx{memoryBatch: memoryBatch{store: s.MemoryStore}}); err != nil {
+		return err
+	}
+	if s.interleave != nil {
+		s.interleave()
+		s.interleave = nil
+	}
+	s.attempts++
+	return s.MemoryStore.RunTransaction(ctx, fn)
+}
+
+func TestPostingRetriesAfterConflict(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStore()
+	newTestAccount(t, memory, "SB00004", AccountTypeSB, 100*Naira)
+	store := &conflictStore{MemoryStore: memory, interleave: func() {
+		if _, err := create(ctx, Transaction{
+			AccountNumber: "SB00004",
+			Type:          TransactionType_Deposit,
+			Amount:        20 * Naira,
+			PaymentMethod: PaymentMethod_Cash,
+		}, timeNow(), memory); err != nil {
+			t.Fatal(err)
+		}
+	}}
+
+	if _, err := create(ctx, Transaction{
+		AccountNumber: "SB00004",
+		Type:          TransactionType_Deposit,
+		Amount:        10 * Naira,
+		PaymentMethod: PaymentMethod_Cash,
+	}, timeNow(), store); err != nil {
+		t.Fatal(err)
+	}
+	if store.attempts != 2 {
+		t.Fatalf("transaction ran %d times, want 2", store.attempts)
+	}
+
+	account, err := memory.GetAccount(ctx, "SB00004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 130*Naira {
+		t.Fatalf("balance = %s, want %s", account.Balance, 130*Naira)
+	}
+	txs, err := memory.ListTransactions(ctx, TransactionQuery{AccountNumber: "SB00004"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0].ReceiptNo == txs[1].ReceiptNo {
+		t.Fatalf("got transactions %+v, want two with distinct receipts", txs)
+	}
+	total, err := memory.Total(ctx, "stats/globalBalance/"+AccountTypeSB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 30*Naira {
+		t.Fatalf("global balance = %s, want %s", total, 30*Naira)
+	}
+}
+
+func TestWithdrawalRetryRereadsBalance(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStore()
+	newTestAccount(t, memory, "SB00005", AccountTypeSB, 50*Naira)
+	store := &conflictStore{MemoryStore: memory, interleave: func() {
+		if _, err := makeDeduction(ctx, MakeDeductionRequest{
+			AccountNumber: "SB00005",
+			Amount:        30 * Naira,
+		}, timeNow(), memory); err != nil {
+			t.Fatal(err)
+		}
+	}}
+
+	_, err := makeDeduction(ctx, MakeDeductionRequest{
+		AccountNumber: "SB00005",
+		Amount:        40 * Naira,
+	}, timeNow(), store)
+	if err == nil || err.Error() != "insufficient fund" {
+		t.Fatalf("got error %v, want insufficient fund", err)
+	}
+	account, err := memory.GetAccount(ctx, "SB00005")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 20*Naira {
+		t.Fatalf("balance = %s, want %s", account.Balance, 20*Naira)
+	}
+}
+
+func TestTransactionReadsMustPrecedeWrites(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	newTestAccount(t, store, "SB00006", AccountTypeSB, 0)
+
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		account, err := tx.GetAccount(ctx, "SB00006")
+		if err != nil {
+			return err
+		}
+		account.Balance = 5 * Naira
+		tx.UpdateAccount(*account)
+		_, err = tx.GetCustomer(ctx, account.CustomerID)
+		return err
+	})
+	if err != errReadAfterWrite {
+		t.Fatalf("got error %v, want %v", err, errReadAfterWrite)
+	}
+	account, err := store.GetAccount(ctx, "SB00006")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 0 {
+		t.Fatalf("balance = %s after a failed transaction", account.Balance)
+	}
+}